@@ -0,0 +1,567 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/charmbracelet/x/term"
+)
+
+// defaultAgentIdle is how long the agent keeps the vault session without
+// being used before it locks itself
+const defaultAgentIdle = time.Hour
+
+// Agent operations
+const (
+	agentOpStatus = "status"
+	agentOpUnlock = "unlock"
+	agentOpToken  = "token"
+	agentOpLock   = "lock"
+	agentOpStop   = "stop"
+	agentOpForget = "forget"
+)
+
+// errAgentNotRunning is returned by callAgent when nothing listens on the socket
+var errAgentNotRunning = errors.New("agent is not running (start it with: tkz agent)")
+
+// agentSocketPath returns the agent's socket, overridable with TKZ_AGENT_SOCK.
+// The default lives in its own 0700 directory so the socket is never
+// reachable by other users, even before its mode is tightened.
+func agentSocketPath() string {
+	if p := os.Getenv("TKZ_AGENT_SOCK"); p != "" {
+		return p
+	}
+	return filepath.Join(getConfigDir(), "agent", "agent.sock")
+}
+
+// agentRequest is one request on the agent socket; each connection carries
+// a single JSON request and response
+type agentRequest struct {
+	Op      string `json:"op"`
+	Session string `json:"session,omitempty"` // unlock
+	Client  string `json:"client,omitempty"`  // token
+	Force   bool   `json:"force,omitempty"`   // token
+	Token   string `json:"token,omitempty"`   // forget
+}
+
+type agentResponse struct {
+	Error string `json:"error,omitempty"`
+	Code  int    `json:"code,omitempty"` // Exit code for Error
+
+	Locked      bool         `json:"locked,omitempty"`
+	IdleLockIn  string       `json:"idle_lock_in,omitempty"`
+	Cached      int          `json:"cached,omitempty"`
+	Token       *tokenRecord `json:"token,omitempty"`
+	Interactive bool         `json:"interactive,omitempty"` // Caller must run the grant itself
+}
+
+// agentServer holds an unlocked vault session and a token cache for other
+// tkz processes of the same user. Only tokens leave the agent: the session
+// is never handed out, so locking it takes vault access away for good.
+type agentServer struct {
+	listener net.Listener
+	path     string
+	idle     time.Duration // 0 disables the idle lock
+	log      io.Writer
+
+	mu       sync.Mutex
+	session  string
+	cache    tokenCache
+	lastUsed time.Time
+	epoch    int // bumped whenever the cache is dropped
+
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// listenAgent creates the agent socket with mode 0600. A leftover socket from
+// an agent that died is removed; a live one is an error.
+func listenAgent(path string) (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(path); err == nil {
+		if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("an agent is already listening on %s", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+func newAgentServer(path, session string, idle time.Duration, log io.Writer) (*agentServer, error) {
+	l, err := listenAgent(path)
+	if err != nil {
+		return nil, err
+	}
+	return &agentServer{
+		listener: l,
+		path:     path,
+		idle:     idle,
+		log:      log,
+		session:  session,
+		cache:    make(tokenCache),
+		lastUsed: time.Now(),
+		done:     make(chan struct{}),
+	}, nil
+}
+
+// serve accepts connections until stop is called
+func (a *agentServer) serve() error {
+	if a.idle > 0 {
+		go a.watchIdle()
+	}
+	for {
+		conn, err := a.listener.Accept()
+		if err != nil {
+			select {
+			case <-a.done:
+				return nil
+			default:
+				return err
+			}
+		}
+		go a.handle(conn)
+	}
+}
+
+// stop closes the listener and removes the socket
+func (a *agentServer) stop() {
+	a.stopOnce.Do(func() {
+		close(a.done)
+		a.listener.Close()
+		os.Remove(a.path)
+	})
+}
+
+func (a *agentServer) watchIdle() {
+	// A tick must be positive, whatever the idle period
+	ticker := time.NewTicker(min(max(a.idle/4, time.Second), 30*time.Second))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-a.done:
+			return
+		case now := <-ticker.C:
+			a.checkIdle(now)
+		}
+	}
+}
+
+// checkIdle locks the agent once it has not been used for the idle period
+func (a *agentServer) checkIdle(now time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.session != "" && now.Sub(a.lastUsed) >= a.idle {
+		a.lockLocked()
+		fmt.Fprintln(a.log, "tkz agent: locked after being idle for", a.idle)
+	}
+}
+
+// lockLocked forgets the session and every cached token; a.mu must be held
+func (a *agentServer) lockLocked() {
+	a.session = ""
+	a.cache = make(tokenCache)
+	a.epoch++
+}
+
+func (a *agentServer) handle(conn net.Conn) {
+	defer conn.Close()
+	if err := checkPeer(conn); err != nil {
+		fmt.Fprintf(a.log, "tkz agent: rejected connection: %v\n", err)
+		return
+	}
+	var req agentRequest
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		return
+	}
+	resp := a.dispatch(req)
+	json.NewEncoder(conn).Encode(resp)
+	if req.Op == agentOpStop {
+		a.stop()
+	}
+}
+
+func (a *agentServer) dispatch(req agentRequest) agentResponse {
+	switch req.Op {
+	case agentOpStatus:
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		resp := agentResponse{Locked: a.session == "", Cached: len(a.cache)}
+		if a.idle > 0 && a.session != "" {
+			resp.IdleLockIn = formatRemaining(time.Until(a.lastUsed.Add(a.idle)))
+		}
+		return resp
+	case agentOpUnlock:
+		if req.Session == "" {
+			return agentResponse{Error: "no session given", Code: exitUsage}
+		}
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		if a.session != req.Session {
+			// Tokens fetched under another session may belong to another account
+			a.cache = make(tokenCache)
+			a.epoch++
+		}
+		a.session = req.Session
+		a.lastUsed = time.Now()
+		return agentResponse{}
+	case agentOpToken:
+		return a.token(req.Client, req.Force)
+	case agentOpLock:
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		a.lockLocked()
+		return agentResponse{}
+	case agentOpStop:
+		return agentResponse{}
+	case agentOpForget:
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		a.cache.forget(req.Token)
+		return agentResponse{Cached: len(a.cache)}
+	}
+	return agentResponse{Error: fmt.Sprintf("unknown op %q", req.Op), Code: exitUsage}
+}
+
+// token serves a client's token from the agent cache or runs the pipeline.
+// Clients the agent cannot serve (see agentServes) are left to the caller.
+func (a *agentServer) token(name string, force bool) agentResponse {
+	clients, err := loadClients()
+	if err != nil {
+		return agentResponse{Error: fmt.Sprintf("load clients: %v", err), Code: exitError}
+	}
+	client, err := findClient(clients, name)
+	if err != nil {
+		return agentResponse{Error: err.Error(), Code: exitCodeFor(err)}
+	}
+	if !agentServes(client, clients) {
+		return agentResponse{Interactive: true}
+	}
+
+	a.mu.Lock()
+	session, epoch := a.session, a.epoch
+	if session != "" {
+		a.lastUsed = time.Now()
+	}
+	if !force {
+		if cached, ok := a.cache.get(client, time.Now()); ok {
+			a.mu.Unlock()
+			rec := recordOf(cached)
+			return agentResponse{Token: &rec}
+		}
+	}
+	a.mu.Unlock()
+
+	if session == "" {
+		return agentResponse{Locked: true, Error: "agent is locked (run: tkz agent unlock)", Code: exitProviderLocked}
+	}
+
+	// Check the providers first, so a provider locked behind the agent's back
+	// reports as locked rather than as a failed item
+	result, err := localToken(session, clients, client, stderrPrompter{w: a.log})
+	if err != nil {
+		return agentResponse{Error: err.Error(), Code: exitCodeFor(err)}
+	}
+	a.mu.Lock()
+	// Don't repopulate the cache of an agent that was locked meanwhile
+	if a.epoch == epoch {
+		a.cache.put(*result)
+	}
+	a.mu.Unlock()
+	rec := recordOf(*result)
+	return agentResponse{Token: &rec}
+}
+
+// agentServes reports whether the agent may fetch a client's token. It only
+// holds a Bitwarden session: other providers would resolve secrets with the
+// agent's environment (VAULT_TOKEN, OP_ACCOUNT, ...) instead of the
+// caller's. Grants that need a browser or a device code run where the user
+// is, and DPoP tokens are only usable with the key of the process that
+// fetched them.
+func agentServes(client Client, clients []Client) bool {
+	return bitwardenOnly(client, clients) && !usesDPoP(client, clients) && !needsUser(client, clients)
+}
+
+// needsUser reports whether a client's grant, or the grant of its token
+// exchange subject chain, needs a browser or a device code
+func needsUser(client Client, clients []Client) bool {
+	seen := map[string]bool{}
+	for !seen[client.Name] {
+		seen[client.Name] = true
+		switch client.grant() {
+		case grantAuthorizationCode, grantDeviceCode:
+			return true
+		case grantTokenExchange:
+			subject, ok := findClientByName(clients, client.SubjectClient)
+			if !ok {
+				return false
+			}
+			client = subject
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+// usesBitwarden reports whether a client, or a client of its token
+// exchange subject chain, resolves its secrets from Bitwarden
+func usesBitwarden(client Client, clients []Client) bool {
+	return anyInSubjectChain(client, clients, func(c Client) bool {
+		p, err := providerFor(c.Provider)
+		return err == nil && p.info().name == providerBitwarden
+	})
+}
+
+// bitwardenOnly reports whether a client's token exchange subject chain
+// reads secrets, and reads them from Bitwarden alone
+func bitwardenOnly(client Client, clients []Client) bool {
+	found := false
+	other := anyInSubjectChain(client, clients, func(c Client) bool {
+		if !c.needsProvider() {
+			return false
+		}
+		if p, err := providerFor(c.Provider); err == nil && p.info().name == providerBitwarden {
+			found = true
+			return false
+		}
+		return true
+	})
+	return found && !other
+}
+
+// usesDPoP reports whether a client, or a client of its token exchange
+// subject chain, requests DPoP-bound tokens. Their proof key lives in the
+// process that ran the pipeline, so the agent must not fetch them.
+func usesDPoP(client Client, clients []Client) bool {
+	return anyInSubjectChain(client, clients, func(c Client) bool { return c.DPoP })
+}
+
+// anyInSubjectChain reports whether match holds for a client or a client of
+// its token exchange subject chain
+func anyInSubjectChain(client Client, clients []Client, match func(Client) bool) bool {
+	seen := map[string]bool{}
+	for !seen[client.Name] {
+		seen[client.Name] = true
+		if match(client) {
+			return true
+		}
+		if client.grant() != grantTokenExchange {
+			return false
+		}
+		subject, ok := findClientByName(clients, client.SubjectClient)
+		if !ok {
+			return false
+		}
+		client = subject
+	}
+	return false
+}
+
+// callAgent sends one request to the running agent. Token requests may run
+// a whole grant, so they get a longer deadline.
+func callAgent(req agentRequest) (*agentResponse, error) {
+	conn, err := net.DialTimeout("unix", agentSocketPath(), time.Second)
+	if err != nil {
+		return nil, errAgentNotRunning
+	}
+	defer conn.Close()
+	// The socket path may come from the environment; only talk to our own agent
+	if err := checkPeer(conn); err != nil {
+		return nil, err
+	}
+	timeout := 5 * time.Second
+	if req.Op == agentOpToken {
+		timeout = 2 * time.Minute
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, fmt.Errorf("agent: %w", err)
+	}
+	var resp agentResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("agent: %w", err)
+	}
+	return &resp, nil
+}
+
+// agentToken asks the running agent for a client's token. It returns
+// errAgentNotRunning without an agent, and a nil result with a nil error for
+// interactive grants the caller has to run itself.
+func agentToken(name string, force bool) (*TokenResult, error) {
+	resp, err := callAgent(agentRequest{Op: agentOpToken, Client: name, Force: force})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Error != "" {
+		return nil, &cliError{code: resp.Code, err: errors.New(resp.Error)}
+	}
+	if resp.Interactive || resp.Token == nil {
+		return nil, nil
+	}
+	result := resp.Token.result()
+	return &result, nil
+}
+
+// runAgentCmd implements `tkz agent [--idle <duration>]`, which runs the agent
+// in the foreground, and `tkz agent status|unlock|lock|stop`.
+func runAgentCmd(args []string, session string, stdout, stderr io.Writer) int {
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		if len(args) != 1 {
+			fmt.Fprintln(stderr, "Usage: tkz agent [status|unlock|lock|stop]")
+			return exitUsage
+		}
+		return runAgentControl(args[0], session, stdout, stderr)
+	}
+
+	fs := flag.NewFlagSet("agent", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	idle := fs.Duration("idle", defaultAgentIdle, "lock after this long without use (0 disables)")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: tkz agent [--idle <duration>]")
+		fmt.Fprintln(stderr, "       tkz agent status|unlock|lock|stop")
+		fmt.Fprintln(stderr)
+		fmt.Fprintln(stderr, "Run the tkz agent in the foreground. It holds the unlocked vault session")
+		fmt.Fprintln(stderr, "and a token cache for other tkz processes on "+agentSocketPath()+".")
+		fmt.Fprintln(stderr)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return exitUsage
+	}
+	if *idle < 0 || (*idle > 0 && *idle < time.Second) {
+		fmt.Fprintf(stderr, "tkz agent: --idle must be 0 or at least 1s, got %v\n", *idle)
+		return exitUsage
+	}
+
+	// Start unlocked if possible; otherwise `tkz agent unlock` or the TUI
+	// hands the agent a session later
+	if session == "" && term.IsTerminal(os.Stdin.Fd()) {
+		var err error
+		if session, err = promptUnlock(stderr); err != nil {
+			fmt.Fprintf(stderr, "tkz agent: %v (starting locked)\n", err)
+		}
+	}
+
+	a, err := newAgentServer(agentSocketPath(), session, *idle, stderr)
+	if err != nil {
+		fmt.Fprintf(stderr, "tkz agent: %v\n", err)
+		return exitError
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		a.stop()
+	}()
+
+	state := "unlocked"
+	if session == "" {
+		state = "locked"
+	}
+	fmt.Fprintf(stderr, "tkz agent: listening on %s (%s)\n", a.path, state)
+	if err := a.serve(); err != nil {
+		fmt.Fprintf(stderr, "tkz agent: %v\n", err)
+		return exitError
+	}
+	return exitOK
+}
+
+func runAgentControl(op string, session string, stdout, stderr io.Writer) int {
+	req := agentRequest{Op: op}
+	switch op {
+	case agentOpStatus, agentOpLock, agentOpStop:
+	case agentOpUnlock:
+		if session == "" {
+			if !term.IsTerminal(os.Stdin.Fd()) {
+				fmt.Fprintln(stderr, "tkz: no terminal to prompt for the master password (set BW_SESSION)")
+				return exitProviderLocked
+			}
+			var err error
+			if session, err = promptUnlock(stderr); err != nil {
+				fmt.Fprintf(stderr, "tkz: %v\n", err)
+				return exitProviderLocked
+			}
+		}
+		req.Session = session
+	default:
+		fmt.Fprintf(stderr, "tkz: unknown agent command %q (use status, unlock, lock, or stop)\n", op)
+		return exitUsage
+	}
+
+	resp, err := callAgent(req)
+	if err != nil {
+		fmt.Fprintf(stderr, "tkz: %v\n", err)
+		return exitError
+	}
+	if resp.Error != "" {
+		fmt.Fprintf(stderr, "tkz: %s\n", resp.Error)
+		return resp.Code
+	}
+
+	switch op {
+	case agentOpStatus:
+		if resp.Locked {
+			fmt.Fprintln(stdout, "Agent running, locked")
+			return exitProviderLocked
+		}
+		line := fmt.Sprintf("Agent running, unlocked, %d cached token(s)", resp.Cached)
+		if resp.IdleLockIn != "" {
+			line += ", locks in " + resp.IdleLockIn
+		}
+		fmt.Fprintln(stdout, line)
+	case agentOpUnlock:
+		fmt.Fprintln(stdout, "Agent unlocked")
+	case agentOpLock:
+		fmt.Fprintln(stdout, "Agent locked")
+	case agentOpStop:
+		fmt.Fprintln(stdout, "Agent stopped")
+	}
+	return exitOK
+}
+
+// promptUnlock reads the master password from the terminal without echo and
+// unlocks the vault with it
+func promptUnlock(stderr io.Writer) (string, error) {
+	fmt.Fprint(stderr, "Bitwarden master password: ")
+	password, err := term.ReadPassword(os.Stdin.Fd())
+	fmt.Fprintln(stderr)
+	if err != nil {
+		return "", err
+	}
+	if len(password) == 0 {
+		return "", errors.New("no password given")
+	}
+	return UnlockBWVault(string(password))
+}
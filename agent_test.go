@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startTestAgent runs an agent on a socket in a temp dir and points
+// agentSocketPath at it
+func startTestAgent(t *testing.T, session string, idle time.Duration) *agentServer {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "agent.sock")
+	t.Setenv("TKZ_AGENT_SOCK", path)
+	a, err := newAgentServer(path, session, idle, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go a.serve()
+	t.Cleanup(a.stop)
+	return a
+}
+
+// agentLocked reports whether the running agent is locked
+func agentLocked(t *testing.T) bool {
+	t.Helper()
+	resp, err := callAgent(agentRequest{Op: agentOpStatus})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.Locked
+}
+
+func seedAgent(a *agentServer, r TokenResult) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.cache.put(r)
+}
+
+func TestAgentLifecycle(t *testing.T) {
+	a := startTestAgent(t, "", 0)
+
+	info, err := os.Stat(a.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("expected socket mode 0600, got %o", perm)
+	}
+
+	if !agentLocked(t) {
+		t.Error("expected the agent to start locked")
+	}
+	if _, err := callAgent(agentRequest{Op: agentOpUnlock, Session: "sess"}); err != nil {
+		t.Fatal(err)
+	}
+	if agentLocked(t) {
+		t.Error("expected the agent to be unlocked")
+	}
+	// The session only goes in; no request gets it back out
+	resp, err := callAgent(agentRequest{Op: "session"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Code != exitUsage {
+		t.Errorf("expected the session op to be unknown, got %+v", resp)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := runAgentControl("lock", "", &stdout, &stderr); code != exitOK {
+		t.Fatalf("expected exit 0, got %d: %s", code, stderr.String())
+	}
+	if !agentLocked(t) {
+		t.Error("expected the agent to be locked")
+	}
+	if code := runAgentControl("status", "", &stdout, &stderr); code != exitProviderLocked {
+		t.Errorf("expected status of a locked agent to exit %d, got %d", exitProviderLocked, code)
+	}
+
+	if code := runAgentControl("stop", "", &stdout, &stderr); code != exitOK {
+		t.Fatalf("expected exit 0, got %d: %s", code, stderr.String())
+	}
+	<-a.done
+	if _, err := os.Stat(a.path); !os.IsNotExist(err) {
+		t.Error("expected socket to be removed on stop")
+	}
+	if _, err := callAgent(agentRequest{Op: agentOpStatus}); !errors.Is(err, errAgentNotRunning) {
+		t.Errorf("expected errAgentNotRunning, got %v", err)
+	}
+}
+
+func TestListenAgent(t *testing.T) {
+	a := startTestAgent(t, "", 0)
+	if _, err := listenAgent(a.path); err == nil || !strings.Contains(err.Error(), "already listening") {
+		t.Errorf("expected a live agent to be refused, got %v", err)
+	}
+
+	// A socket left behind by an agent that died is replaced
+	stale := filepath.Join(t.TempDir(), "stale.sock")
+	l, err := net.Listen("unix", stale)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+	l, err = listenAgent(stale)
+	if err != nil {
+		t.Fatalf("expected stale socket to be replaced, got %v", err)
+	}
+	l.Close()
+}
+
+func TestAgentIdleLock(t *testing.T) {
+	a := startTestAgent(t, "sess", time.Hour)
+	seedAgent(a, TokenResult{Token: TokenResponse{AccessToken: "tok", ExpiresIn: 300}, Client: Client{Name: "c"}, FetchedAt: time.Now()})
+
+	a.checkIdle(time.Now().Add(30 * time.Minute))
+	if agentLocked(t) {
+		t.Fatal("expected agent to stay unlocked before the idle period")
+	}
+	a.checkIdle(time.Now().Add(2 * time.Hour))
+	if !agentLocked(t) {
+		t.Error("expected agent to lock after the idle period")
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.cache) != 0 {
+		t.Error("expected idle lock to drop cached tokens")
+	}
+}
+
+func TestAgentShortIdle(t *testing.T) {
+	// A tiny idle period must not make the idle ticker panic
+	startTestAgent(t, "sess", time.Nanosecond)
+
+	for _, idle := range []string{"1ns", "500ms", "-1m"} {
+		var stdout, stderr bytes.Buffer
+		if code := runAgentCmd([]string{"--idle", idle}, "", &stdout, &stderr); code != exitUsage {
+			t.Errorf("--idle %s: expected exit %d, got %d", idle, exitUsage, code)
+		}
+	}
+}
+
+func TestAgentToken(t *testing.T) {
+	client := Client{Name: "kc-dev", ItemID: "item", Issuer: "https://auth.example.com"}
+	interactive := Client{Name: "browser", ItemID: "item", Issuer: "https://auth.example.com", GrantType: grantAuthorizationCode}
+	useConfigHome(t, []Client{client, interactive})
+	a := startTestAgent(t, "", 0)
+	seedAgent(a, TokenResult{Token: TokenResponse{AccessToken: "from-agent", ExpiresIn: 300}, Client: client, FetchedAt: time.Now()})
+
+	// The cache serves even while locked
+	result, err := agentToken("kc-dev", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result == nil || result.Token.AccessToken != "from-agent" {
+		t.Fatalf("expected cached token, got %+v", result)
+	}
+
+	if _, err := agentToken("kc-dev", true); exitCodeFor(err) != exitProviderLocked {
+		t.Errorf("expected forced token from a locked agent to exit %d, got %v", exitProviderLocked, err)
+	}
+	if _, err := agentToken("missing", false); exitCodeFor(err) != exitClientNotFound {
+		t.Errorf("expected unknown client to exit %d, got %v", exitClientNotFound, err)
+	}
+
+	if _, err := callAgent(agentRequest{Op: agentOpUnlock, Session: "sess"}); err != nil {
+		t.Fatal(err)
+	}
+	result, err = agentToken("browser", false)
+	if err != nil || result != nil {
+		t.Errorf("expected interactive grant to be left to the caller, got %+v, %v", result, err)
+	}
+}
+
+func TestAgentTokenWithoutBitwarden(t *testing.T) {
+	useFakeProvider(t, fakeProvider{name: "fake", items: map[string]map[string]string{
+		"api": {"id": "my-client", "secret": "s3cret"},
+	}})
+	server := newFakeIssuer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token": "fake-token", "token_type": "Bearer", "expires_in": 300}`))
+	})
+	fake := Client{Name: "fake-api", Provider: "fake", ItemID: "api", Issuer: server.URL}
+	bw := Client{Name: "bw", ItemID: "item", Issuer: server.URL}
+	exchange := Client{Name: "exchange", Provider: "fake", ItemID: "api", Issuer: server.URL, GrantType: grantTokenExchange, SubjectClient: "bw"}
+	useConfigHome(t, []Client{fake, bw, exchange})
+	startTestAgent(t, "", 0)
+
+	// Other providers resolve with the caller's environment, not the agent's
+	for _, name := range []string{"fake-api", "exchange"} {
+		if result, err := agentToken(name, true); err != nil || result != nil {
+			t.Errorf("expected %s to be left to the caller, got %+v, %v", name, result, err)
+		}
+	}
+	var stdout, stderr bytes.Buffer
+	if code := runTokenCmd([]string{"fake-api"}, "", &stdout, &stderr); code != exitOK {
+		t.Fatalf("expected exit 0, got %d: %s", code, stderr.String())
+	}
+	if strings.TrimSpace(stdout.String()) != "fake-token" {
+		t.Errorf("expected the fake provider's token, got %q", stdout.String())
+	}
+	if _, err := agentToken("bw", true); exitCodeFor(err) != exitProviderLocked || !strings.Contains(err.Error(), "tkz agent unlock") {
+		t.Errorf("expected bw to need the agent unlocked, got %v", err)
+	}
+}
+
+func TestRunTokenCmdKeepsAgentLocked(t *testing.T) {
+	client := Client{Name: "kc-dev", ItemID: "item", Issuer: "https://auth.example.com"}
+	useConfigHome(t, []Client{client})
+	startTestAgent(t, "", 0)
+	t.Setenv("PATH", t.TempDir())
+
+	// Our session serves this call only; the agent stays locked
+	var stdout, stderr bytes.Buffer
+	runTokenCmd([]string{"kc-dev"}, "sess", &stdout, &stderr)
+	if !agentLocked(t) {
+		t.Error("expected BW_SESSION not to unlock the agent")
+	}
+	if !strings.Contains(stderr.String(), "bw CLI not found") {
+		t.Errorf("expected the token to be fetched locally, got %q", stderr.String())
+	}
+}
+
+func TestAgentLeavesDPoPToCaller(t *testing.T) {
+	useFakeProvider(t, fakeProvider{name: "fake", items: map[string]map[string]string{
+		"api": {"id": "my-client", "secret": "s3cret"},
+	}})
+	server := newFakeIssuer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("DPoP") == "" {
+			t.Error("expected a DPoP proof from tkz token itself")
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token": "local-dpop", "token_type": "DPoP", "expires_in": 300}`))
+	})
+	dpop := Client{Name: "dpop", Provider: "fake", ItemID: "api", Issuer: server.URL, DPoP: true}
+	exchange := Client{Name: "exchange", Provider: "fake", ItemID: "api", Issuer: server.URL, GrantType: grantTokenExchange, SubjectClient: "dpop"}
+	useConfigHome(t, []Client{dpop, exchange})
+	a := startTestAgent(t, "sess", 0)
+	// A token the agent bound to its own key must never be handed out
+	seedAgent(a, TokenResult{Token: TokenResponse{AccessToken: "agent-dpop", TokenType: "DPoP", ExpiresIn: 300}, Client: dpop, FetchedAt: time.Now()})
+
+	for _, name := range []string{"dpop", "exchange"} {
+		result, err := agentToken(name, false)
+		if err != nil || result != nil {
+			t.Errorf("expected %s to be left to the caller, got %+v, %v", name, result, err)
+		}
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := runTokenCmd([]string{"dpop"}, "", &stdout, &stderr); code != exitOK {
+		t.Fatalf("expected exit 0, got %d: %s", code, stderr.String())
+	}
+	if strings.TrimSpace(stdout.String()) != "local-dpop" {
+		t.Errorf("expected a token fetched in this process, got %q", stdout.String())
+	}
+}
+
+func TestRunTokenCmdUsesAgent(t *testing.T) {
+	client := Client{Name: "kc-dev", ItemID: "item", Issuer: "https://auth.example.com"}
+	useConfigHome(t, []Client{client})
+	a := startTestAgent(t, "", 0)
+	seedAgent(a, TokenResult{Token: TokenResponse{AccessToken: "from-agent", ExpiresIn: 300}, Client: client, FetchedAt: time.Now()})
+
+	// No session and no bw: the agent alone answers
+	var stdout, stderr bytes.Buffer
+	if code := runTokenCmd([]string{"kc-dev"}, "", &stdout, &stderr); code != exitOK {
+		t.Fatalf("expected exit 0, got %d: %s", code, stderr.String())
+	}
+	if strings.TrimSpace(stdout.String()) != "from-agent" {
+		t.Errorf("expected token from the agent, got %q", stdout.String())
+	}
+}
+
+func TestNeedsUser(t *testing.T) {
+	clients := []Client{
+		{Name: "cc"},
+		{Name: "browser", GrantType: grantAuthorizationCode},
+		{Name: "device", GrantType: grantDeviceCode},
+		{Name: "exchange-cc", GrantType: grantTokenExchange, SubjectClient: "cc"},
+		{Name: "exchange-browser", GrantType: grantTokenExchange, SubjectClient: "browser"},
+		{Name: "exchange-chain", GrantType: grantTokenExchange, SubjectClient: "exchange-browser"},
+		{Name: "loop-a", GrantType: grantTokenExchange, SubjectClient: "loop-b"},
+		{Name: "loop-b", GrantType: grantTokenExchange, SubjectClient: "loop-a"},
+	}
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"cc", false},
+		{"browser", true},
+		{"device", true},
+		{"exchange-cc", false},
+		{"exchange-browser", true},
+		{"exchange-chain", true},
+		{"loop-a", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := findClientByName(clients, tt.name)
+			if got := needsUser(c, clients); got != tt.want {
+				t.Errorf("needsUser(%s) = %v, want %v", tt.name, got, tt.want)
+			}
+		})
+	}
+}
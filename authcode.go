@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// authCodeTimeout bounds how long tkz waits for the browser redirect
+const authCodeTimeout = 5 * time.Minute
+
+// authCodeFlow is an in-progress authorization_code + PKCE (S256) flow.
+// It owns a temporary HTTP listener on 127.0.0.1 that receives the redirect.
+type authCodeFlow struct {
+	AuthURL     string
+	RedirectURI string
+	verifier    string
+	state       string
+	server      *http.Server
+	result      chan authCodeCallback
+}
+
+type authCodeCallback struct {
+	code string
+	err  error
+}
+
+// randomURLString returns n random bytes encoded as unpadded base64url
+func randomURLString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// pkceChallenge derives the S256 code_challenge for a code_verifier
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// startAuthCodeFlow starts the loopback listener and builds the authorize URL
+func startAuthCodeFlow(authEndpoint, clientID, scopes string) (*authCodeFlow, error) {
+	if !strings.HasPrefix(authEndpoint, "https://") {
+		return nil, fmt.Errorf("authorization endpoint must use HTTPS: %s", authEndpoint)
+	}
+	authURL, err := url.Parse(authEndpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid authorization endpoint: %w", err)
+	}
+
+	verifier, err := randomURLString(32)
+	if err != nil {
+		return nil, fmt.Errorf("generate code_verifier: %w", err)
+	}
+	state, err := randomURLString(16)
+	if err != nil {
+		return nil, fmt.Errorf("generate state: %w", err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("start redirect listener: %w", err)
+	}
+
+	f := &authCodeFlow{
+		RedirectURI: fmt.Sprintf("http://%s/callback", listener.Addr().String()),
+		verifier:    verifier,
+		state:       state,
+		result:      make(chan authCodeCallback, 1),
+	}
+
+	q := authURL.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", clientID)
+	q.Set("redirect_uri", f.RedirectURI)
+	q.Set("state", state)
+	q.Set("code_challenge", pkceChallenge(verifier))
+	q.Set("code_challenge_method", "S256")
+	if scopes != "" {
+		q.Set("scope", scopes)
+	}
+	authURL.RawQuery = q.Encode()
+	f.AuthURL = authURL.String()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/callback", f.handleCallback)
+	f.server = &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go f.server.Serve(listener)
+
+	return f, nil
+}
+
+func (f *authCodeFlow) handleCallback(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	// Anything on the machine can reach the listener; a response without our
+	// state, error or not, must not end the flow
+	if subtle.ConstantTimeCompare([]byte(q.Get("state")), []byte(f.state)) != 1 {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, "tkz: authorization response state mismatch")
+		return
+	}
+
+	var cb authCodeCallback
+	switch {
+	case q.Get("error") != "":
+		cb.err = fmt.Errorf("authorization denied: %s %s", q.Get("error"), q.Get("error_description"))
+	case q.Get("code") == "":
+		cb.err = fmt.Errorf("authorization response missing code")
+	default:
+		cb.code = q.Get("code")
+	}
+
+	if cb.err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "tkz: %v\n", cb.err)
+	} else {
+		fmt.Fprintln(w, "tkz: authorization complete. You can close this window.")
+	}
+
+	// Only the first matching callback counts
+	select {
+	case f.result <- cb:
+	default:
+	}
+}
+
+// Wait blocks until the redirect arrives, the context ends, or authCodeTimeout
+// passes, then shuts the listener down and returns the authorization code.
+func (f *authCodeFlow) Wait(ctx context.Context) (string, error) {
+	defer f.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, authCodeTimeout)
+	defer cancel()
+
+	select {
+	case cb := <-f.result:
+		return cb.code, cb.err
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return "", fmt.Errorf("timed out waiting for browser authorization")
+		}
+		return "", ctx.Err()
+	}
+}
+
+// Close stops the loopback listener
+func (f *authCodeFlow) Close() {
+	f.server.Close()
+}
+
+// Exchange trades the authorization code for tokens, sending the PKCE verifier
+func (f *authCodeFlow) Exchange(tokenEndpoint string, creds clientCredentials, code string) (*TokenResponse, error) {
+	data := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {f.RedirectURI},
+		"code_verifier": {f.verifier},
+	}
+	return postTokenForm(tokenEndpoint, creds, data)
+}
+
+// authorizationCodeGrant runs the whole browser flow: listener, prompt, wait, exchange
+func authorizationCodeGrant(ctx context.Context, oidc *OIDCConfig, creds clientCredentials, scopes string, prompt flowPrompter) (*TokenResponse, error) {
+	flow, err := startAuthCodeFlow(oidc.AuthorizationEndpoint, creds.ClientID, scopes)
+	if err != nil {
+		return nil, err
+	}
+	prompt.authorizeURL(flow.AuthURL)
+
+	code, err := flow.Wait(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return flow.Exchange(oidc.TokenEndpoint, creds, code)
+}
+
+// openBrowser opens a URL in the user's default browser
+func openBrowser(u string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", u)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", u)
+	default:
+		cmd = exec.Command("xdg-open", u)
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go cmd.Wait()
+	return nil
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeAuthServer is an in-process authorization server for the
+// authorization_code flow. /authorize immediately redirects back with a code,
+// /token checks the PKCE verifier against the challenge it saw.
+type fakeAuthServer struct {
+	*httptest.Server
+	mu        sync.Mutex
+	challenge string
+	code      string
+}
+
+func newFakeAuthServer(t *testing.T) *fakeAuthServer {
+	t.Helper()
+	f := &fakeAuthServer{code: "auth-code-123"}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("response_type") != "code" {
+			t.Errorf("expected response_type=code, got %q", q.Get("response_type"))
+		}
+		if q.Get("code_challenge_method") != "S256" {
+			t.Errorf("expected code_challenge_method=S256, got %q", q.Get("code_challenge_method"))
+		}
+		if !strings.HasPrefix(q.Get("redirect_uri"), "http://127.0.0.1:") {
+			t.Errorf("expected loopback redirect_uri, got %q", q.Get("redirect_uri"))
+		}
+		f.mu.Lock()
+		f.challenge = q.Get("code_challenge")
+		f.mu.Unlock()
+
+		redirect, _ := url.Parse(q.Get("redirect_uri"))
+		rq := redirect.Query()
+		rq.Set("code", f.code)
+		rq.Set("state", q.Get("state"))
+		redirect.RawQuery = rq.Encode()
+		http.Redirect(w, r, redirect.String(), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		f.mu.Lock()
+		challenge := f.challenge
+		f.mu.Unlock()
+		if r.FormValue("grant_type") != "authorization_code" {
+			t.Errorf("expected grant_type authorization_code, got %q", r.FormValue("grant_type"))
+		}
+		if r.FormValue("code") != f.code {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+		if pkceChallenge(r.FormValue("code_verifier")) != challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant","error_description":"PKCE verification failed"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "user-token",
+			"token_type":   "Bearer",
+			"expires_in":   300,
+		})
+	})
+	f.Server = httptest.NewTLSServer(mux)
+	t.Cleanup(f.Close)
+	useTLSServer(t, f.Server)
+	return f
+}
+
+// browserPrompter plays the user's browser: it follows the authorize URL
+type browserPrompter struct {
+	t      *testing.T
+	client *http.Client
+}
+
+func (p browserPrompter) authorizeURL(u string) {
+	go func() {
+		resp, err := p.client.Get(u)
+		if err != nil {
+			p.t.Errorf("browser request failed: %v", err)
+			return
+		}
+		resp.Body.Close()
+	}()
+}
+
+func (p browserPrompter) deviceCode(DeviceAuthResponse) {}
+
+func TestPKCEChallenge(t *testing.T) {
+	// Test vector from RFC 7636 Appendix B
+	got := pkceChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk")
+	if got != "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM" {
+		t.Errorf("unexpected challenge: %s", got)
+	}
+}
+
+func TestAuthorizationCodeGrant(t *testing.T) {
+	srv := newFakeAuthServer(t)
+	oidc := &OIDCConfig{
+		AuthorizationEndpoint: srv.URL + "/authorize",
+		TokenEndpoint:         srv.URL + "/token",
+	}
+
+	token, err := authorizationCodeGrant(context.Background(), oidc, clientCredentials{ClientID: "public-client"}, "openid",
+		browserPrompter{t: t, client: srv.Client()})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if token.AccessToken != "user-token" {
+		t.Errorf("expected access token 'user-token', got %q", token.AccessToken)
+	}
+}
+
+func TestStartAuthCodeFlowURL(t *testing.T) {
+	flow, err := startAuthCodeFlow("https://auth.example.com/authorize?kc_idp_hint=x", "my-client", "openid email")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer flow.Close()
+
+	u, err := url.Parse(flow.AuthURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("kc_idp_hint") != "x" {
+		t.Error("expected existing query parameters to be preserved")
+	}
+	if q.Get("client_id") != "my-client" || q.Get("scope") != "openid email" {
+		t.Errorf("unexpected client_id/scope: %v", q)
+	}
+	if q.Get("redirect_uri") != flow.RedirectURI {
+		t.Errorf("expected redirect_uri %q, got %q", flow.RedirectURI, q.Get("redirect_uri"))
+	}
+	if q.Get("state") == "" || q.Get("code_challenge") == "" {
+		t.Error("expected state and code_challenge to be set")
+	}
+}
+
+func TestAuthCodeFlowStateMismatch(t *testing.T) {
+	flow, err := startAuthCodeFlow("https://auth.example.com/authorize", "my-client", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, query := range []string{"?code=abc&state=forged", "?code=abc", "?error=access_denied"} {
+		resp, err := http.Get(flow.RedirectURI + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: expected 400 without our state, got %d", query, resp.StatusCode)
+		}
+	}
+
+	// None of them ended the flow; the real redirect still does
+	resp, err := http.Get(flow.RedirectURI + "?code=real&state=" + flow.state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if code, err := flow.Wait(context.Background()); err != nil || code != "real" {
+		t.Errorf("expected the matching callback's code, got %q, %v", code, err)
+	}
+}
+
+func TestAuthCodeFlowDenied(t *testing.T) {
+	flow, err := startAuthCodeFlow("https://auth.example.com/authorize", "my-client", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	resp, err := http.Get(flow.RedirectURI + "?error=access_denied&error_description=nope&state=" + flow.state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if _, err := flow.Wait(context.Background()); err == nil || !strings.Contains(err.Error(), "access_denied") {
+		t.Errorf("expected access_denied error, got %v", err)
+	}
+}
+
+func TestAuthCodeFlowCancelled(t *testing.T) {
+	flow, err := startAuthCodeFlow("https://auth.example.com/authorize", "my-client", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := flow.Wait(ctx); err == nil {
+		t.Fatal("expected error for cancelled context")
+	}
+}
+
+func TestStartAuthCodeFlowRejectsHTTP(t *testing.T) {
+	_, err := startAuthCodeFlow("http://auth.example.com/authorize", "id", "")
+	if err == nil || !strings.Contains(err.Error(), "HTTPS") {
+		t.Errorf("expected HTTPS error, got %v", err)
+	}
+}
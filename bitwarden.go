@@ -96,6 +96,19 @@ func fetchBWRawItem(session string, itemID string) ([]byte, error) {
 	return output, nil
 }
 
+// fetchBWAttachment streams an attachment's contents from bw's stdout,
+// so the file never touches disk
+func fetchBWAttachment(session string, itemID string, attachmentID string) ([]byte, error) {
+	cmd := exec.Command("bw", "get", "attachment", attachmentID, "--itemid", itemID, "--raw", "--session", session)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("bw get attachment: %s", strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
 // --- JSON parsing functions (tested independently) ---
 
 func parseBWStatusDetail(data []byte) (string, error) {
@@ -143,6 +156,17 @@ func parseBWFullItem(data []byte) (*BWFullItem, error) {
 	return &item, nil
 }
 
+// findBWAttachment returns the attachment referenced by an attachments.<file name> path
+func findBWAttachment(item *BWFullItem, fieldPath string) (*BWAttachment, error) {
+	fileName := strings.TrimPrefix(fieldPath, "attachments.")
+	for i, a := range item.Attachments {
+		if a.FileName == fileName {
+			return &item.Attachments[i], nil
+		}
+	}
+	return nil, fmt.Errorf("attachment %q not found in bitwarden item", fileName)
+}
+
 // ResolveBWField resolves a field path to a value from a Bitwarden item.
 // Supported paths: login.username, login.password, fields.<name>, notes, or empty string.
 func ResolveBWField(item *BWFullItem, fieldPath string) (string, error) {
@@ -179,3 +203,84 @@ func ResolveBWField(item *BWFullItem, fieldPath string) (string, error) {
 		return "", fmt.Errorf("unsupported field path: %s (use login.username, login.password, fields.<name>, or notes)", fieldPath)
 	}
 }
+
+// bitwardenProvider resolves secrets with the bw CLI. Item IDs are
+// Bitwarden item UUIDs.
+type bitwardenProvider struct{}
+
+func (bitwardenProvider) info() providerInfo {
+	return providerInfo{
+		name:          providerBitwarden,
+		label:         "Bitwarden",
+		command:       "bw",
+		install:       "brew install bitwarden-cli",
+		login:         "bw login",
+		unlock:        "export BW_SESSION=$(bw unlock --raw)",
+		prompt:        "Master password",
+		fields:        "login.username, login.password, fields.<name>, notes, attachments.<file>",
+		idField:       "login.username",
+		secretField:   "login.password",
+		userField:     "login.username",
+		passwordField: "login.password",
+	}
+}
+
+func (bitwardenProvider) status(session string) (bool, string) {
+	if !CheckBWInstalled() {
+		return false, "unauthenticated"
+	}
+	return true, CheckBWStatusDetail(session)
+}
+
+func (bitwardenProvider) unlock(password string) (string, error) {
+	return UnlockBWVault(password)
+}
+
+func (bitwardenProvider) listItems(session string) ([]secretRef, error) {
+	items, err := FetchBWItems(session, "")
+	if err != nil {
+		return nil, err
+	}
+	refs := make([]secretRef, len(items))
+	for i, item := range items {
+		refs[i] = secretRef{ID: item.ID, Name: item.Name, Detail: item.Login.Username}
+		if len(item.Login.URIs) > 0 {
+			refs[i].URL = item.Login.URIs[0].URI
+		}
+	}
+	return refs, nil
+}
+
+func (bitwardenProvider) item(session, id string) (secretItem, error) {
+	raw, err := fetchBWRawItem(session, id)
+	if err != nil {
+		return nil, err
+	}
+	item, err := parseBWFullItem(raw)
+	if err != nil {
+		return nil, fmt.Errorf("parse: %w", err)
+	}
+	return bwSecretItem{session: session, item: item}, nil
+}
+
+// bwSecretItem resolves field paths like ResolveBWField, additionally
+// supporting attachments.<file name>, which are read via bw into memory
+type bwSecretItem struct {
+	session string
+	item    *BWFullItem
+}
+
+func (i bwSecretItem) field(path string) (string, error) {
+	if !strings.HasPrefix(path, "attachments.") {
+		return ResolveBWField(i.item, path)
+	}
+	att, err := findBWAttachment(i.item, path)
+	if err != nil {
+		return "", err
+	}
+	data, err := fetchBWAttachment(i.session, i.item.ID, att.ID)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
@@ -292,3 +292,21 @@ func TestResolveBWField(t *testing.T) {
 		})
 	}
 }
+
+func TestFindBWAttachment(t *testing.T) {
+	item := &BWFullItem{Attachments: []BWAttachment{
+		{ID: "att-1", FileName: "client.pem"},
+		{ID: "att-2", FileName: "readme.txt"},
+	}}
+
+	att, err := findBWAttachment(item, "attachments.client.pem")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if att.ID != "att-1" {
+		t.Errorf("expected att-1, got %q", att.ID)
+	}
+	if _, err := findBWAttachment(item, "attachments.missing.pem"); err == nil {
+		t.Error("expected error for missing attachment")
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"time"
+)
+
+// defaultCacheSkew is how long before expiry a cached token stops being
+// handed out, so callers don't receive a token that dies in flight
+const defaultCacheSkew = 30 * time.Second
+
+// tokenCache holds fetched tokens in memory, keyed by cacheKey
+type tokenCache map[string]TokenResult
+
+// cacheKey identifies a token: the same client name against the same issuer
+// with the same scopes. Renaming a client or changing its scopes misses.
+func cacheKey(c Client) string {
+	return c.Name + "\x00" + c.Issuer + "\x00" + c.Scopes
+}
+
+// cacheSkew returns the client's configured skew, or defaultCacheSkew
+func (c Client) cacheSkew() time.Duration {
+	if c.CacheSkew > 0 {
+		return time.Duration(c.CacheSkew) * time.Second
+	}
+	return defaultCacheSkew
+}
+
+// staleAt returns when a token stops being served from the cache. Tokens
+// without a known expiry are never cached.
+func (r TokenResult) staleAt() time.Time {
+	exp := r.ExpiresAt()
+	if exp.IsZero() {
+		return time.Time{}
+	}
+	return exp.Add(-r.Client.cacheSkew())
+}
+
+// put stores a token if it has a known expiry
+func (tc tokenCache) put(r TokenResult) {
+	if r.staleAt().IsZero() {
+		return
+	}
+	tc[cacheKey(r.Client)] = r
+}
+
+// get returns the cached token for a client if it is still fresh
+func (tc tokenCache) get(c Client, now time.Time) (TokenResult, bool) {
+	r, ok := tc[cacheKey(c)]
+	if !ok {
+		return TokenResult{}, false
+	}
+	// The skew may have been changed since the token was cached
+	r.Client.CacheSkew = c.CacheSkew
+	if !now.Before(r.staleAt()) {
+		delete(tc, cacheKey(c))
+		return TokenResult{}, false
+	}
+	return r, true
+}
+
+// forget drops every cached copy of a token, matching its access or refresh
+// token, and reports whether there was one
+func (tc tokenCache) forget(token string) bool {
+	found := false
+	for k, r := range tc {
+		if token != "" && (r.Token.AccessToken == token || r.Token.RefreshToken == token) {
+			delete(tc, k)
+			found = true
+		}
+	}
+	return found
+}
+
+// freshUntil returns when the client's cached token goes stale, or the zero
+// time if there is none
+func (tc tokenCache) freshUntil(c Client) time.Time {
+	r, ok := tc[cacheKey(c)]
+	if !ok {
+		return time.Time{}
+	}
+	r.Client.CacheSkew = c.CacheSkew
+	return r.staleAt()
+}
+
+// formatRemaining renders a time left like "4m12s"
+func formatRemaining(d time.Duration) string {
+	if d < time.Second {
+		return "0s"
+	}
+	return d.Truncate(time.Second).String()
+}
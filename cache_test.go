@@ -0,0 +1,120 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+func TestTokenCache(t *testing.T) {
+	now := time.Now()
+	client := Client{Name: "kc-dev", Issuer: "https://auth.example.com", Scopes: "openid"}
+	result := TokenResult{
+		Token:     TokenResponse{AccessToken: "tok", ExpiresIn: 300},
+		Client:    client,
+		FetchedAt: now,
+	}
+
+	tc := make(tokenCache)
+	tc.put(result)
+
+	tests := []struct {
+		name   string
+		client Client
+		at     time.Time
+		want   bool
+	}{
+		{"fresh", client, now.Add(time.Minute), true},
+		{"within default skew", client, now.Add(300*time.Second - defaultCacheSkew), false},
+		{"just before skew", client, now.Add(300*time.Second - defaultCacheSkew - time.Second), true},
+		{"other scopes", Client{Name: "kc-dev", Issuer: "https://auth.example.com", Scopes: "email"}, now, false},
+		{"other issuer", Client{Name: "kc-dev", Issuer: "https://other.example.com", Scopes: "openid"}, now, false},
+		{"larger configured skew", Client{Name: "kc-dev", Issuer: "https://auth.example.com", Scopes: "openid", CacheSkew: 250}, now.Add(time.Minute), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := make(tokenCache)
+			c.put(result)
+			got, ok := c.get(tt.client, tt.at)
+			if ok != tt.want {
+				t.Fatalf("expected cache hit %v, got %v", tt.want, ok)
+			}
+			if ok && got.Token.AccessToken != "tok" {
+				t.Errorf("unexpected cached token %q", got.Token.AccessToken)
+			}
+		})
+	}
+
+	if _, ok := tc.get(client, now.Add(time.Hour)); ok {
+		t.Error("expected expired token to miss")
+	}
+	if _, ok := tc[cacheKey(client)]; ok {
+		t.Error("expected expired token to be evicted")
+	}
+}
+
+func TestTokenCacheSkipsUnknownExpiry(t *testing.T) {
+	tc := make(tokenCache)
+	client := Client{Name: "opaque"}
+	tc.put(TokenResult{Token: TokenResponse{AccessToken: "tok"}, Client: client, FetchedAt: time.Now()})
+	if _, ok := tc.get(client, time.Now()); ok {
+		t.Error("expected tokens without expires_in not to be cached")
+	}
+}
+
+func TestClientDescriptionShowsCachedToken(t *testing.T) {
+	c := Client{Name: "kc-dev", Issuer: "https://auth.example.com"}
+	if strings.Contains(c.Description(), "cached") {
+		t.Error("expected no cache note without a cached token")
+	}
+	c.cachedUntil = time.Now().Add(4*time.Minute + 30*time.Second)
+	if !strings.Contains(c.Description(), "cached, 4m") {
+		t.Errorf("expected cache countdown, got %q", c.Description())
+	}
+}
+
+func TestEnterServesCachedToken(t *testing.T) {
+	client := Client{Name: "kc-dev", Issuer: "https://auth.example.com"}
+	m := initialModel("")
+	m.clients = []Client{client}
+	m.updateList()
+	m.providerChecking = false
+
+	// A token response fills the cache and the list shows it
+	m.mode = tokenView
+	m.tokenLoading = true
+	result, _ := m.Update(tokenResponseMsg{result: TokenResult{
+		Token:     TokenResponse{AccessToken: "cached-tok", ExpiresIn: 300},
+		Client:    client,
+		FetchedAt: time.Now(),
+	}})
+	m = result.(model)
+	if item := m.list.Items()[0].(Client); item.cachedUntil.IsZero() {
+		t.Error("expected list item to show the cached token")
+	}
+
+	// Enter serves it without the vault being unlocked
+	m.mode = listView
+	m.tokenResult = nil
+	result, cmd := m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	m = result.(model)
+	if cmd != nil || m.mode != tokenView || !m.tokenCached {
+		t.Fatalf("expected cached token view, got mode %v cached %v", m.mode, m.tokenCached)
+	}
+	if m.tokenResult.Token.AccessToken != "cached-tok" {
+		t.Errorf("expected cached token, got %q", m.tokenResult.Token.AccessToken)
+	}
+	if !strings.Contains(m.View(), "Cached:") {
+		t.Error("expected token view to mark the token as cached")
+	}
+
+	// f forces a new token, which needs the vault
+	result, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'f'}})
+	m = result.(model)
+	if m.pendingAction != "token" {
+		t.Errorf("expected force to request a new token after unlock, got %q", m.pendingAction)
+	}
+}
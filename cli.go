@@ -0,0 +1,439 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/x/term"
+)
+
+// Exit codes for headless commands, so scripts can tell failures apart
+const (
+	exitOK             = 0
+	exitError          = 1 // Anything not covered below
+	exitUsage          = 2 // Bad flags or arguments
+	exitProviderLocked = 3 // Secret provider CLI missing, logged out, or locked
+	exitClientNotFound = 4 // No client with that name in clients.json
+	exitDiscovery      = 5 // OIDC discovery failed
+	exitTokenRejected  = 6 // Token endpoint rejected the request
+	exitTokenInvalid   = 7 // tkz verify failed, or tkz introspect found the token inactive
+	exitItemFailed     = 8 // The client's item could not be fetched or parsed
+)
+
+// cliError carries the exit code a headless command should terminate with
+type cliError struct {
+	code int
+	err  error
+}
+
+func (e *cliError) Error() string { return e.err.Error() }
+
+func (e *cliError) Unwrap() error { return e.err }
+
+// exitCodeFor maps an error from a headless command to its exit code
+func exitCodeFor(err error) int {
+	if err == nil {
+		return exitOK
+	}
+	var ce *cliError
+	if errors.As(err, &ce) {
+		return ce.code
+	}
+	var te *tokenError
+	if errors.As(err, &te) {
+		switch te.stage {
+		case stageProvider:
+			return exitItemFailed
+		case stageDiscovery:
+			return exitDiscovery
+		case stageToken:
+			return exitTokenRejected
+		}
+	}
+	return exitError
+}
+
+// parseArgs parses flags that may appear before or after positional arguments
+// (the flag package stops at the first non-flag argument on its own).
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		if args[0] == "--" {
+			return append(positional, args[1:]...), nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// findClient looks up a configured client by its display name
+func findClient(clients []Client, name string) (Client, error) {
+	if c, ok := findClientByName(clients, name); ok {
+		return c, nil
+	}
+	return Client{}, &cliError{code: exitClientNotFound, err: fmt.Errorf("client %q not found in %s", name, getClientsPath())}
+}
+
+// isProviderLocked reports whether err means the secret provider (or the agent) is locked
+func isProviderLocked(err error) bool {
+	return err != nil && exitCodeFor(err) == exitProviderLocked
+}
+
+// runTokenCmd implements `tkz token <client>`: it prints an access token
+// for the named client without starting the TUI.
+func runTokenCmd(args []string, session string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("token", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	output := fs.String("output", "token", "output format: token, json, env, header")
+	fs.StringVar(output, "o", "token", "shorthand for --output")
+	force := fs.Bool("force", false, "fetch a new token even if the disk cache has a fresh one")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: tkz token <client> [--output token|json|env|header] [--force]")
+		fmt.Fprintln(stderr)
+		fmt.Fprintln(stderr, "Print an access token for the named client to stdout.")
+		fmt.Fprintln(stderr)
+		fs.PrintDefaults()
+	}
+
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if len(positional) != 1 {
+		fs.Usage()
+		return exitUsage
+	}
+	if !validOutputFormat(*output) {
+		fmt.Fprintf(stderr, "tkz: unknown output format %q (use token, json, env, or header)\n", *output)
+		return exitUsage
+	}
+
+	result, err := headlessToken(positional[0], session, *force, stderr)
+	if err != nil {
+		fmt.Fprintf(stderr, "tkz: %v\n", err)
+		return exitCodeFor(err)
+	}
+
+	if err := writeToken(stdout, result, *output); err != nil {
+		fmt.Fprintf(stderr, "tkz: %v\n", err)
+		return exitError
+	}
+	return exitOK
+}
+
+func validOutputFormat(format string) bool {
+	switch format {
+	case "token", "json", "env", "header":
+		return true
+	}
+	return false
+}
+
+// writeToken prints a token result in one of the headless output formats:
+//   - token:  the bare access token
+//   - json:   the full TokenResult, including a computed expires_at
+//   - env:    shell assignments (TKZ_TOKEN, ...) suitable for eval
+//   - header: a ready-to-use Authorization header line
+func writeToken(w io.Writer, result *TokenResult, format string) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(result)
+	case "env":
+		vars := [][2]string{
+			{"TKZ_TOKEN", result.Token.AccessToken},
+			{"TKZ_TOKEN_TYPE", result.Token.TokenType},
+			{"TKZ_SCOPE", result.Token.Scope},
+		}
+		if exp := result.ExpiresAt(); !exp.IsZero() {
+			vars = append(vars, [2]string{"TKZ_EXPIRES_AT", exp.UTC().Format(time.RFC3339)})
+		}
+		for _, v := range vars {
+			if _, err := fmt.Fprintf(w, "export %s=%s\n", v[0], shellQuote(v[1])); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "header":
+		_, err := fmt.Fprintln(w, "Authorization: "+result.AuthorizationHeader())
+		return err
+	default:
+		_, err := fmt.Fprintln(w, result.Token.AccessToken)
+		return err
+	}
+}
+
+// shellQuote wraps s in single quotes for POSIX shells
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// runRefreshCmd implements `tkz refresh <client>`: it redeems a refresh token
+// from --refresh-token or stdin (raw, or the JSON printed by `tkz token -o json`).
+func runRefreshCmd(args []string, session string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("refresh", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	output := fs.String("output", "token", "output format: token, json, env, header")
+	fs.StringVar(output, "o", "token", "shorthand for --output")
+	refresh := fs.String("refresh-token", "", "refresh token to redeem (default: stdin, then the disk cache)")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: tkz refresh <client> [--refresh-token <token>] [--output token|json|env|header]")
+		fmt.Fprintln(stderr)
+		fmt.Fprintln(stderr, "Redeem a refresh token for the named client. Without --refresh-token,")
+		fmt.Fprintln(stderr, "the token (or the JSON from `tkz token -o json`) is read from stdin, or")
+		fmt.Fprintln(stderr, "else taken from the disk cache. The new token replaces the cached one.")
+		fmt.Fprintln(stderr)
+		fs.PrintDefaults()
+	}
+
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if len(positional) != 1 {
+		fs.Usage()
+		return exitUsage
+	}
+	if !validOutputFormat(*output) {
+		fmt.Fprintf(stderr, "tkz: unknown output format %q (use token, json, env, or header)\n", *output)
+		return exitUsage
+	}
+
+	rt := *refresh
+	if stdinFile, ok := stdin.(*os.File); rt == "" && !(ok && term.IsTerminal(stdinFile.Fd())) {
+		data, err := io.ReadAll(stdin)
+		if err != nil {
+			fmt.Fprintf(stderr, "tkz: read stdin: %v\n", err)
+			return exitError
+		}
+		rt = parseRefreshInput(data)
+	}
+
+	f, err := loadClientsFile(getClientsPath())
+	if err != nil {
+		fmt.Fprintf(stderr, "tkz: load clients: %v\n", err)
+		return exitError
+	}
+	client, err := findClient(f.Clients, positional[0])
+	if err != nil {
+		fmt.Fprintf(stderr, "tkz: %v\n", err)
+		return exitCodeFor(err)
+	}
+
+	// The disk cache is best effort, as for tkz token
+	cache, err := openDiskCache(f.Settings, session)
+	if err != nil && !quietCacheErr(err, client, f.Clients) {
+		fmt.Fprintf(stderr, "tkz: warning: %v\n", err)
+	}
+	if rt == "" && cache != nil {
+		if cached, ok := cache.get(client); ok {
+			rt = cached.Token.RefreshToken
+		}
+	}
+	if rt == "" {
+		fmt.Fprintln(stderr, "tkz: no refresh token given (use --refresh-token, stdin, or the disk cache)")
+		return exitUsage
+	}
+
+	if client.needsProvider() {
+		err = requireProvider(session, client)
+	}
+	var result *TokenResult
+	if err == nil {
+		result, err = fetchRefreshedToken(session, client, rt)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "tkz: %v\n", err)
+		return exitCodeFor(err)
+	}
+
+	// Replace the cached token so tkz token does not keep serving the old one
+	if cache != nil {
+		if err := cache.put(*result); err != nil {
+			fmt.Fprintf(stderr, "tkz: warning: disk cache: %v\n", err)
+		}
+	}
+	if _, err := callAgent(agentRequest{Op: agentOpForget, Token: rt}); err != nil && !errors.Is(err, errAgentNotRunning) {
+		fmt.Fprintf(stderr, "tkz: warning: agent: %v\n", err)
+	}
+
+	if err := writeToken(stdout, result, *output); err != nil {
+		fmt.Fprintf(stderr, "tkz: %v\n", err)
+		return exitError
+	}
+	return exitOK
+}
+
+// parseRefreshInput extracts a refresh token from stdin: either the JSON
+// printed by `tkz token -o json` or the bare token
+func parseRefreshInput(data []byte) string {
+	trimmed := strings.TrimSpace(string(data))
+	if strings.HasPrefix(trimmed, "{") {
+		var v struct {
+			RefreshToken string `json:"refresh_token"`
+		}
+		if json.Unmarshal(data, &v) == nil {
+			return v.RefreshToken
+		}
+	}
+	return trimmed
+}
+
+// headlessToken loads the named client and runs the token pipeline for it
+func headlessToken(name string, session string, force bool, stderr io.Writer) (*TokenResult, error) {
+	f, err := loadClientsFile(getClientsPath())
+	if err != nil {
+		return nil, fmt.Errorf("load clients: %w", err)
+	}
+	client, err := findClient(f.Clients, name)
+	if err != nil {
+		return nil, err
+	}
+
+	// The disk cache is best effort: problems are reported, not fatal
+	cache, err := openDiskCache(f.Settings, session)
+	if err != nil && !quietCacheErr(err, client, f.Clients) {
+		fmt.Fprintf(stderr, "tkz: warning: %v\n", err)
+	}
+	if cache != nil && !force {
+		if cached, ok := cache.get(client); ok {
+			return &cached, nil
+		}
+	}
+
+	// A running agent serves Bitwarden clients from the cache it shares with
+	// other shells; everything it cannot serve runs here (see agentServes)
+	var result *TokenResult
+	err = errAgentNotRunning
+	if agentServes(client, f.Clients) {
+		result, err = agentToken(name, force)
+	}
+	switch {
+	case errors.Is(err, errAgentNotRunning):
+	case isProviderLocked(err) && session != "":
+		// Use our own session for this call; only tkz agent unlock (or
+		// unlocking in the TUI) unlocks the agent
+	case err != nil:
+		return nil, err
+	case result != nil:
+		if cache != nil {
+			if err := cache.put(*result); err != nil {
+				fmt.Fprintf(stderr, "tkz: warning: disk cache: %v\n", err)
+			}
+		}
+		return result, nil
+	}
+
+	result, err = localToken(session, f.Clients, client, stderrPrompter{w: stderr})
+	if err != nil {
+		return nil, err
+	}
+	if cache != nil {
+		if err := cache.put(*result); err != nil {
+			fmt.Fprintf(stderr, "tkz: warning: disk cache: %v\n", err)
+		}
+	}
+	return result, nil
+}
+
+// localToken runs the token pipeline in this process
+func localToken(session string, clients []Client, client Client, prompt flowPrompter) (*TokenResult, error) {
+	if err := requireChainProvider(session, client, clients); err != nil {
+		return nil, err
+	}
+	p := tokenPipeline{session: session, clients: clients, prompt: prompt}
+	return p.fetchToken(context.Background(), client)
+}
+
+// runCacheCmd implements `tkz cache clear`
+func runCacheCmd(args []string, stdout, stderr io.Writer) int {
+	if len(args) != 1 || args[0] != "clear" {
+		fmt.Fprintln(stderr, "Usage: tkz cache clear")
+		fmt.Fprintln(stderr)
+		fmt.Fprintln(stderr, "Delete the encrypted on-disk token cache.")
+		return exitUsage
+	}
+	if err := clearDiskCache(); err != nil {
+		fmt.Fprintf(stderr, "tkz: %v\n", err)
+		return exitError
+	}
+	fmt.Fprintln(stdout, "Token cache cleared")
+	return exitOK
+}
+
+// stderrPrompter prints interactive grant instructions to stderr so that
+// stdout stays clean for the token itself
+type stderrPrompter struct {
+	w io.Writer
+}
+
+func (p stderrPrompter) authorizeURL(u string) {
+	fmt.Fprintln(p.w, "Open this URL in your browser to authorize tkz:")
+	fmt.Fprintln(p.w)
+	fmt.Fprintln(p.w, "  "+u)
+	fmt.Fprintln(p.w)
+	openBrowser(u)
+}
+
+func (p stderrPrompter) deviceCode(auth DeviceAuthResponse) {
+	fmt.Fprintln(p.w, "To authorize tkz, visit:")
+	fmt.Fprintln(p.w)
+	fmt.Fprintln(p.w, "  "+auth.VerificationURI)
+	fmt.Fprintln(p.w)
+	fmt.Fprintln(p.w, "and enter the code: "+auth.UserCode)
+	if auth.VerificationURIComplete != "" {
+		fmt.Fprintln(p.w)
+		fmt.Fprintln(p.w, "Or open: "+auth.VerificationURIComplete)
+	}
+	fmt.Fprintln(p.w)
+	fmt.Fprintln(p.w, "Waiting for authorization...")
+}
+
+func runCLI(cmd string, args []string) int {
+	session := os.Getenv("BW_SESSION")
+	os.Unsetenv("BW_SESSION")
+
+	switch cmd {
+	case "token":
+		return runTokenCmd(args, session, os.Stdout, os.Stderr)
+	case "refresh":
+		return runRefreshCmd(args, session, os.Stdin, os.Stdout, os.Stderr)
+	case "cache":
+		return runCacheCmd(args, os.Stdout, os.Stderr)
+	case "exec":
+		return runExecCmd(args, session, os.Stdin, os.Stdout, os.Stderr)
+	case "proxy":
+		return runProxyCmd(args, session, os.Stdout, os.Stderr)
+	case "agent":
+		return runAgentCmd(args, session, os.Stdout, os.Stderr)
+	case "introspect":
+		return runIntrospectCmd(args, session, os.Stdin, os.Stdout, os.Stderr)
+	case "revoke":
+		return runRevokeCmd(args, session, os.Stdin, os.Stdout, os.Stderr)
+	case "verify":
+		return runVerifyCmd(args, os.Stdin, os.Stdout, os.Stderr)
+	}
+	fmt.Fprintf(os.Stderr, "tkz: unknown command %q\n", cmd)
+	return exitUsage
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useConfigHome points getConfigDir at a temp dir seeded with the given clients
+func useConfigHome(t *testing.T, clients []Client) {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	if clients != nil {
+		path := filepath.Join(home, ".config", "tkz", "clients.json")
+		if err := saveClientsTo(path, clients); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestExitCodeFor(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"nil", nil, exitOK},
+		{"plain error", fmt.Errorf("boom"), exitError},
+		{"cli error", &cliError{code: exitClientNotFound, err: fmt.Errorf("nope")}, exitClientNotFound},
+		{"vault locked", &cliError{code: exitProviderLocked, err: fmt.Errorf("vault locked")}, exitProviderLocked},
+		{"item not found", stageErr(stageProvider, "bitwarden: %w", fmt.Errorf("Not found.")), exitItemFailed},
+		{"resolve stage", stageErr(stageResolve, "resolve client_id: %w", fmt.Errorf("missing")), exitError},
+		{"discovery stage", stageErr(stageDiscovery, "oidc discovery: %w", fmt.Errorf("404")), exitDiscovery},
+		{"token stage", stageErr(stageToken, "token request: %w", fmt.Errorf("401")), exitTokenRejected},
+		{"wrapped stage", fmt.Errorf("outer: %w", stageErr(stageToken, "token request")), exitTokenRejected},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exitCodeFor(tt.err); got != tt.want {
+				t.Errorf("expected exit code %d, got %d", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestTokenErrorKeepsMessage(t *testing.T) {
+	inner := fmt.Errorf("vault is locked")
+	err := stageErr(stageProvider, "bitwarden: %w", inner)
+	if err.Error() != "bitwarden: vault is locked" {
+		t.Errorf("unexpected message: %q", err.Error())
+	}
+	if !errors.Is(err, inner) {
+		t.Error("expected tokenError to unwrap to the inner error")
+	}
+}
+
+func TestParseArgs(t *testing.T) {
+	t.Run("flags after positional", func(t *testing.T) {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		verbose := fs.Bool("verbose", false, "")
+		pos, err := parseArgs(fs, []string{"my-client", "--verbose"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(pos) != 1 || pos[0] != "my-client" {
+			t.Errorf("expected [my-client], got %v", pos)
+		}
+		if !*verbose {
+			t.Error("expected --verbose to be parsed after positional")
+		}
+	})
+
+	t.Run("double dash stops parsing", func(t *testing.T) {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.Bool("verbose", false, "")
+		pos, err := parseArgs(fs, []string{"a", "--", "--verbose", "b"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(pos) != 3 || pos[1] != "--verbose" {
+			t.Errorf("expected [a --verbose b], got %v", pos)
+		}
+	})
+
+	t.Run("unknown flag", func(t *testing.T) {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.SetOutput(&bytes.Buffer{})
+		if _, err := parseArgs(fs, []string{"--nope"}); err == nil {
+			t.Fatal("expected error for unknown flag")
+		}
+	})
+}
+
+func TestFindClient(t *testing.T) {
+	clients := []Client{{Name: "alpha"}, {Name: "beta", Issuer: "https://b.example.com"}}
+
+	c, err := findClient(clients, "beta")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c.Issuer != "https://b.example.com" {
+		t.Errorf("expected beta's issuer, got %q", c.Issuer)
+	}
+
+	_, err = findClient(clients, "gamma")
+	if exitCodeFor(err) != exitClientNotFound {
+		t.Errorf("expected exit code %d for missing client, got %d", exitClientNotFound, exitCodeFor(err))
+	}
+}
+
+func TestRunTokenCmdUsage(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if code := runTokenCmd(nil, "", &stdout, &stderr); code != exitUsage {
+		t.Errorf("expected exit code %d without a client name, got %d", exitUsage, code)
+	}
+	if stdout.Len() != 0 {
+		t.Errorf("expected nothing on stdout, got %q", stdout.String())
+	}
+}
+
+func TestRunTokenCmdClientNotFound(t *testing.T) {
+	useConfigHome(t, []Client{{Name: "exists"}})
+
+	var stdout, stderr bytes.Buffer
+	if code := runTokenCmd([]string{"missing"}, "", &stdout, &stderr); code != exitClientNotFound {
+		t.Errorf("expected exit code %d, got %d (stderr: %s)", exitClientNotFound, code, stderr.String())
+	}
+}
+
+func TestRunTokenCmdPublicClientWithoutProvider(t *testing.T) {
+	server := newFakeIssuer(t, func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.FormValue("client_id") != "public-app" {
+			t.Errorf("expected client_id 'public-app', got %q", r.FormValue("client_id"))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token": "public-token", "token_type": "Bearer", "expires_in": 300}`))
+	})
+	public := Client{Name: "public", ClientID: "public-app", AuthMethod: authNone, Issuer: server.URL}
+	useConfigHome(t, []Client{public})
+	// No bw (or any other provider CLI) on the PATH
+	t.Setenv("PATH", t.TempDir())
+
+	var stdout, stderr bytes.Buffer
+	if code := runTokenCmd([]string{"public"}, "", &stdout, &stderr); code != exitOK {
+		t.Fatalf("expected exit 0, got %d: %s", code, stderr.String())
+	}
+	if strings.TrimSpace(stdout.String()) != "public-token" {
+		t.Errorf("expected the public client's token, got %q", stdout.String())
+	}
+}
+
+func TestWriteToken(t *testing.T) {
+	fetched := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	result := &TokenResult{
+		Token:     TokenResponse{AccessToken: "tok-123", TokenType: "Bearer", ExpiresIn: 300, Scope: "openid"},
+		Client:    Client{Name: "kc-dev", Issuer: "https://auth.example.com/realms/dev"},
+		FetchedAt: fetched,
+	}
+
+	t.Run("token", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := writeToken(&buf, result, "token"); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != "tok-123\n" {
+			t.Errorf("unexpected output: %q", buf.String())
+		}
+	})
+
+	t.Run("header", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := writeToken(&buf, result, "header"); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != "Authorization: Bearer tok-123\n" {
+			t.Errorf("unexpected output: %q", buf.String())
+		}
+	})
+
+	t.Run("DPoP header", func(t *testing.T) {
+		dpop := *result
+		dpop.Token.TokenType = "DPoP"
+		var buf bytes.Buffer
+		if err := writeToken(&buf, &dpop, "header"); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != "Authorization: DPoP tok-123\n" {
+			t.Errorf("unexpected output: %q", buf.String())
+		}
+	})
+
+	t.Run("json", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := writeToken(&buf, result, "json"); err != nil {
+			t.Fatal(err)
+		}
+		var got map[string]any
+		if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+			t.Fatalf("output is not JSON: %v", err)
+		}
+		want := map[string]any{
+			"access_token": "tok-123",
+			"token_type":   "Bearer",
+			"expires_in":   float64(300),
+			"scope":        "openid",
+			"client":       "kc-dev",
+			"issuer":       "https://auth.example.com/realms/dev",
+			"fetched_at":   "2026-03-01T12:00:00Z",
+			"expires_at":   "2026-03-01T12:05:00Z",
+		}
+		for k, v := range want {
+			if got[k] != v {
+				t.Errorf("expected %s=%v, got %v", k, v, got[k])
+			}
+		}
+	})
+
+	t.Run("env", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := writeToken(&buf, result, "env"); err != nil {
+			t.Fatal(err)
+		}
+		out := buf.String()
+		for _, line := range []string{
+			"export TKZ_TOKEN='tok-123'",
+			"export TKZ_TOKEN_TYPE='Bearer'",
+			"export TKZ_EXPIRES_AT='2026-03-01T12:05:00Z'",
+		} {
+			if !strings.Contains(out, line+"\n") {
+				t.Errorf("expected line %q in output:\n%s", line, out)
+			}
+		}
+	})
+}
+
+func TestTokenResultJSONOmitsUnknownExpiry(t *testing.T) {
+	data, err := json.Marshal(TokenResult{Token: TokenResponse{AccessToken: "tok"}, FetchedAt: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "expires_at") {
+		t.Errorf("expected no expires_at without expires_in, got %s", data)
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	if got := shellQuote("it's"); got != `'it'\''s'` {
+		t.Errorf("unexpected quoting: %s", got)
+	}
+}
+
+func TestRunTokenCmdRejectsUnknownOutput(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if code := runTokenCmd([]string{"client", "--output", "yaml"}, "", &stdout, &stderr); code != exitUsage {
+		t.Errorf("expected exit code %d, got %d", exitUsage, code)
+	}
+}
+
+func TestParseRefreshInput(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"bare token", "rt-abc\n", "rt-abc"},
+		{"tkz json", `{"access_token": "at", "refresh_token": "rt-json"}`, "rt-json"},
+		{"json without refresh token", `{"access_token": "at"}`, ""},
+		{"empty", "  \n", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseRefreshInput([]byte(tt.in)); got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRunRefreshCmdRequiresToken(t *testing.T) {
+	useConfigHome(t, []Client{{Name: "client"}})
+	var stdout, stderr bytes.Buffer
+	code := runRefreshCmd([]string{"client"}, "", strings.NewReader(""), &stdout, &stderr)
+	if code != exitUsage {
+		t.Errorf("expected exit code %d without a refresh token, got %d", exitUsage, code)
+	}
+}
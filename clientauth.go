@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Token endpoint client authentication methods (OIDC Core §9, RFC 8705 §2.1)
+const (
+	authClientSecretBasic = "client_secret_basic"
+	authClientSecretPost  = "client_secret_post"
+	authNone              = "none"
+	authPrivateKeyJWT     = "private_key_jwt"
+	authTLSClientAuth     = "tls_client_auth"
+)
+
+// authMethods lists the supported token_endpoint_auth_method values
+var authMethods = []string{authClientSecretBasic, authClientSecretPost, authNone, authPrivateKeyJWT, authTLSClientAuth}
+
+// clientAssertionType is the client_assertion_type for private_key_jwt (RFC 7523 §2.2)
+const clientAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+// clientAssertionLifetime is how long a signed client assertion stays valid
+const clientAssertionLifetime = 60 * time.Second
+
+// clientCredentials holds everything needed to authenticate a client to the
+// authorization server. Secrets and keys live only in memory.
+type clientCredentials struct {
+	ClientID     string
+	ClientSecret string
+
+	// token_endpoint_auth_method; empty infers it from the credentials
+	authMethod string
+
+	// private_key_jwt: sign a client assertion instead of sending a secret
+	signingKey crypto.Signer
+	signingAlg string
+	keyID      string
+	audience   string // The canonical token endpoint; empty uses the endpoint posted to
+
+	// Mutual TLS client certificate, presented on every authorization
+	// server request when set
+	certificate *tls.Certificate
+
+	// DPoP key that token requests prove possession of
+	dpop *dpopKey
+}
+
+// method returns the auth method in effect. Without an explicit one it
+// follows the credentials, sending the secret in the form as tkz always has.
+func (c clientCredentials) method() string {
+	switch {
+	case c.authMethod != "":
+		return c.authMethod
+	case c.signingKey != nil:
+		return authPrivateKeyJWT
+	case c.ClientSecret != "":
+		return authClientSecretPost
+	default:
+		return authNone
+	}
+}
+
+// withAuthMethod settles the auth method for a token endpoint. A configured
+// method is checked against the credentials; otherwise the method is picked
+// from the server's token_endpoint_auth_methods_supported, preferring
+// client_secret_basic, the RFC 6749 default.
+func (c clientCredentials) withAuthMethod(configured string, supported []string) (clientCredentials, error) {
+	if configured == "" {
+		c.authMethod = c.method()
+		if c.authMethod == authClientSecretPost && slices.Contains(supported, authClientSecretBasic) {
+			c.authMethod = authClientSecretBasic
+		}
+		return c, nil
+	}
+
+	switch configured {
+	case authClientSecretBasic, authClientSecretPost:
+		if c.ClientSecret == "" {
+			return c, fmt.Errorf("%s requires a client secret", configured)
+		}
+	case authPrivateKeyJWT:
+		if c.signingKey == nil {
+			return c, fmt.Errorf("%s requires a private key field", configured)
+		}
+	case authTLSClientAuth:
+		if c.certificate == nil {
+			return c, fmt.Errorf("%s requires a TLS client certificate field", configured)
+		}
+	case authNone:
+	default:
+		return c, fmt.Errorf("unsupported token_endpoint_auth_method %q (use %s)", configured, strings.Join(authMethods, ", "))
+	}
+	c.authMethod = configured
+	return c, nil
+}
+
+// authenticate adds client authentication to a request posted to endpoint:
+// form parameters go into data, HTTP Basic credentials into header
+func (c clientCredentials) authenticate(header http.Header, data url.Values, endpoint string) error {
+	switch c.method() {
+	case authClientSecretBasic:
+		// RFC 6749 §2.3.1: both parts are form-urlencoded before base64
+		header.Set("Authorization", "Basic "+basicAuth(url.QueryEscape(c.ClientID), url.QueryEscape(c.ClientSecret)))
+	case authClientSecretPost:
+		data.Set("client_id", c.ClientID)
+		data.Set("client_secret", c.ClientSecret)
+	case authPrivateKeyJWT:
+		// Servers expect their token endpoint as the audience, also on the
+		// introspection, revocation and mTLS alias endpoints
+		audience := c.audience
+		if audience == "" {
+			audience = endpoint
+		}
+		assertion, err := c.clientAssertion(audience)
+		if err != nil {
+			return err
+		}
+		data.Set("client_id", c.ClientID)
+		data.Set("client_assertion_type", clientAssertionType)
+		data.Set("client_assertion", assertion)
+	default:
+		// none and tls_client_auth only identify the client
+		data.Set("client_id", c.ClientID)
+	}
+	return nil
+}
+
+func basicAuth(user, password string) string {
+	return base64.StdEncoding.EncodeToString([]byte(user + ":" + password))
+}
+
+// clientAssertion signs a private_key_jwt assertion for the given audience
+func (c clientCredentials) clientAssertion(audience string) (string, error) {
+	jti, err := randomURLString(16)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	claims := map[string]any{
+		"iss": c.ClientID,
+		"sub": c.ClientID,
+		"aud": audience,
+		"jti": jti,
+		"iat": now.Unix(),
+		"exp": now.Add(clientAssertionLifetime).Unix(),
+	}
+	header := map[string]any{}
+	if c.keyID != "" {
+		header["kid"] = c.keyID
+	}
+	assertion, err := signJWT(c.signingKey, c.signingAlg, header, claims)
+	if err != nil {
+		return "", fmt.Errorf("sign client assertion: %w", err)
+	}
+	return assertion, nil
+}
+
+// postAuthenticatedForm POSTs a form to an authorization server endpoint
+// with the client's authentication added. DPoP clients retry once when the
+// server demands a nonce.
+func postAuthenticatedForm(endpoint string, creds clientCredentials, data url.Values) (*http.Response, error) {
+	resp, err := creds.post(endpoint, data)
+	if err != nil || creds.dpop == nil {
+		return resp, err
+	}
+
+	nonce := resp.Header.Get("DPoP-Nonce")
+	if nonce == "" {
+		return resp, nil
+	}
+	creds.dpop.setNonce(endpoint, nonce)
+	if resp.StatusCode != http.StatusBadRequest {
+		return resp, nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if !isDPoPNonceError(body) {
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		return resp, nil
+	}
+	return creds.post(endpoint, data)
+}
+
+// post sends one authenticated form request. Assertions and DPoP proofs are
+// single-use, so each attempt signs fresh ones.
+func (c clientCredentials) post(endpoint string, data url.Values) (*http.Response, error) {
+	header := http.Header{"Content-Type": {"application/x-www-form-urlencoded"}}
+	if err := c.authenticate(header, data, endpoint); err != nil {
+		return nil, fmt.Errorf("client authentication: %w", err)
+	}
+	if c.dpop != nil {
+		proof, err := c.dpop.proof(http.MethodPost, endpoint, "")
+		if err != nil {
+			return nil, fmt.Errorf("DPoP proof: %w", err)
+		}
+		header.Set("DPoP", proof)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(data.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header = header
+
+	client := httpClient
+	if c.certificate != nil {
+		if client, err = mtlsHTTPClient(*c.certificate); err != nil {
+			return nil, err
+		}
+	}
+	return client.Do(req)
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestAuthenticate(t *testing.T) {
+	tests := []struct {
+		name       string
+		creds      clientCredentials
+		wantForm   url.Values
+		wantHeader string
+	}{
+		{"inferred post", clientCredentials{ClientID: "id", ClientSecret: "secret"},
+			url.Values{"client_id": {"id"}, "client_secret": {"secret"}}, ""},
+		{"inferred none", clientCredentials{ClientID: "id"},
+			url.Values{"client_id": {"id"}}, ""},
+		{"basic", clientCredentials{ClientID: "id", ClientSecret: "secret", authMethod: authClientSecretBasic},
+			url.Values{}, "Basic aWQ6c2VjcmV0"},
+		{"tls_client_auth", clientCredentials{ClientID: "id", authMethod: authTLSClientAuth},
+			url.Values{"client_id": {"id"}}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header, data := http.Header{}, url.Values{}
+			if err := tt.creds.authenticate(header, data, "https://auth.example.com/token"); err != nil {
+				t.Fatal(err)
+			}
+			if data.Encode() != tt.wantForm.Encode() {
+				t.Errorf("expected form %q, got %q", tt.wantForm.Encode(), data.Encode())
+			}
+			if header.Get("Authorization") != tt.wantHeader {
+				t.Errorf("expected Authorization %q, got %q", tt.wantHeader, header.Get("Authorization"))
+			}
+		})
+	}
+}
+
+func TestWithAuthMethod(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	secret := clientCredentials{ClientID: "id", ClientSecret: "secret"}
+	public := clientCredentials{ClientID: "id"}
+	signed := clientCredentials{ClientID: "id", signingKey: key}
+
+	tests := []struct {
+		name       string
+		creds      clientCredentials
+		configured string
+		supported  []string
+		want       string
+		wantErr    bool
+	}{
+		{"basic advertised", secret, "", []string{authClientSecretPost, authClientSecretBasic}, authClientSecretBasic, false},
+		{"only post advertised", secret, "", []string{authClientSecretPost}, authClientSecretPost, false},
+		{"nothing advertised keeps post", secret, "", nil, authClientSecretPost, false},
+		{"public client", public, "", []string{authClientSecretBasic}, authNone, false},
+		{"private key", signed, "", []string{authClientSecretBasic, authPrivateKeyJWT}, authPrivateKeyJWT, false},
+		{"configured overrides discovery", secret, authClientSecretPost, []string{authClientSecretBasic}, authClientSecretPost, false},
+		{"configured none", public, authNone, nil, authNone, false},
+		{"basic without secret", public, authClientSecretBasic, nil, "", true},
+		{"private_key_jwt without key", secret, authPrivateKeyJWT, nil, "", true},
+		{"unknown method", secret, "client_secret_jwt", nil, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.creds.withAuthMethod(tt.configured, tt.supported)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got.method() != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got.method())
+			}
+		})
+	}
+}
+
+func TestClientSecretBasicEncodesCredentials(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok {
+			t.Error("expected HTTP Basic credentials")
+		}
+		// RFC 6749 §2.3.1: credentials are form-urlencoded inside Basic
+		if user != "my+client%3A1" || pass != "p%40ss+w%3Ard" {
+			t.Errorf("unexpected encoded credentials %q / %q", user, pass)
+		}
+		r.ParseForm()
+		if r.PostFormValue("client_secret") != "" || r.PostFormValue("client_id") != "" {
+			t.Error("expected credentials only in the Authorization header")
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(TokenResponse{AccessToken: "basic-token", TokenType: "Bearer"})
+	}))
+	defer server.Close()
+	useTLSServer(t, server)
+
+	creds := clientCredentials{ClientID: "my client:1", ClientSecret: "p@ss w:rd", authMethod: authClientSecretBasic}
+	token, err := requestClientCredentials(server.URL+"/token", creds, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if token.AccessToken != "basic-token" {
+		t.Errorf("expected basic-token, got %q", token.AccessToken)
+	}
+}
+
+func TestPrivateKeyJWTClientAssertion(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	var jtis []string
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.FormValue("client_secret") != "" {
+			t.Error("expected no client_secret with private_key_jwt")
+		}
+		if r.FormValue("client_assertion_type") != clientAssertionType {
+			t.Errorf("unexpected client_assertion_type %q", r.FormValue("client_assertion_type"))
+		}
+		header, claims := verifyTestJWT(t, r.FormValue("client_assertion"), key.Public())
+		if header["kid"] != "key-1" {
+			t.Errorf("expected kid key-1, got %v", header["kid"])
+		}
+		for _, c := range []string{"iss", "sub"} {
+			if claims[c] != "jwt-client" {
+				t.Errorf("expected %s jwt-client, got %v", c, claims[c])
+			}
+		}
+		if claims["aud"] != "https://"+r.Host+"/token" {
+			t.Errorf("expected aud to be the token endpoint, got %v", claims["aud"])
+		}
+		exp := time.Unix(int64(claims["exp"].(float64)), 0)
+		if time.Until(exp) <= 0 || time.Until(exp) > clientAssertionLifetime+time.Second {
+			t.Errorf("unexpected exp %v", exp)
+		}
+		jtis = append(jtis, claims["jti"].(string))
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(TokenResponse{AccessToken: "jwt-token", TokenType: "Bearer"})
+	}))
+	defer server.Close()
+	useTLSServer(t, server)
+
+	creds := clientCredentials{ClientID: "jwt-client", signingKey: key, keyID: "key-1"}
+	for range 2 {
+		token, err := requestClientCredentials(server.URL+"/token", creds, "")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if token.AccessToken != "jwt-token" {
+			t.Errorf("expected jwt-token, got %q", token.AccessToken)
+		}
+	}
+	if len(jtis) != 2 || jtis[0] == jtis[1] {
+		t.Errorf("expected a fresh jti per request, got %v", jtis)
+	}
+}
+
+func TestClientAssertionAudience(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	for _, member := range []string{"introspection_endpoint", "revocation_endpoint"} {
+		t.Run(member, func(t *testing.T) {
+			var server *httptest.Server
+			server = newFakeEndpointIssuer(t, member, func(w http.ResponseWriter, r *http.Request) {
+				r.ParseForm()
+				_, claims := verifyTestJWT(t, r.FormValue("client_assertion"), key.Public())
+				if claims["aud"] != server.URL+"/token" {
+					t.Errorf("expected the token endpoint as aud, got %v", claims["aud"])
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(`{"active": true}`))
+			})
+			result := testIntrospectionResult(server.URL)
+			result.creds = clientCredentials{ClientID: "jwt-client", signingKey: key, authMethod: authPrivateKeyJWT}
+			var err error
+			if member == "introspection_endpoint" {
+				_, err = introspect("", result, "tok", hintAccessToken)
+			} else {
+				_, err = revoke("", result, []revocation{{"tok", hintAccessToken}})
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestSettleCredentialsKeepsCanonicalAudience(t *testing.T) {
+	cert, err := parseClientCertificate(newTestClientCert(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	oidc := &OIDCConfig{
+		TokenEndpoint:       "https://auth.example.com/token",
+		MTLSEndpointAliases: &mtlsEndpointAliases{TokenEndpoint: "https://mtls.auth.example.com/token"},
+	}
+	creds, oidc, err := settleCredentials(Client{}, clientCredentials{ClientID: "c", certificate: &cert}, oidc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if oidc.TokenEndpoint != "https://mtls.auth.example.com/token" || creds.audience != "https://auth.example.com/token" {
+		t.Errorf("expected the alias endpoint with the canonical audience, got %s / %s", oidc.TokenEndpoint, creds.audience)
+	}
+}
@@ -1,89 +1,223 @@
 package main
 
 import (
-	"fmt"
+	"context"
+	"errors"
 	"time"
 
 	"github.com/atotto/clipboard"
 	tea "github.com/charmbracelet/bubbletea"
 )
 
-func checkBWStatus(session string) tea.Cmd {
+func checkProviderStatus(p secretProvider, session string) tea.Cmd {
 	return func() tea.Msg {
-		installed := CheckBWInstalled()
-		if !installed {
-			return bwStatusMsg{installed: false, status: "unauthenticated"}
-		}
-		status := CheckBWStatusDetail(session)
-		return bwStatusMsg{installed: installed, status: status, session: session}
+		installed, status := p.status(session)
+		return providerStatusMsg{provider: p.info().name, installed: installed, status: status, session: session}
 	}
 }
 
-func unlockBWVault(password string) tea.Cmd {
+func unlockProvider(p secretProvider, password string) tea.Cmd {
 	return func() tea.Msg {
-		session, err := UnlockBWVault(password)
-		return bwUnlockResultMsg{session: session, err: err}
+		session, err := p.unlock(password)
+		return providerUnlockResultMsg{provider: p.info().name, session: session, err: err}
 	}
 }
 
-func fetchBWItems(session string, search string) tea.Cmd {
+func fetchProviderItems(p secretProvider, session string) tea.Cmd {
 	return func() tea.Msg {
-		items, err := FetchBWItems(session, search)
-		return bwItemsFetchedMsg{items: items, err: err}
+		items, err := p.listItems(session)
+		return providerItemsFetchedMsg{provider: p.info().name, items: items, err: err}
 	}
 }
 
-func requestToken(session string, client Client) tea.Cmd {
+// fetchClientItems lists items with a client's own provider settings, such
+// as the Vault server, namespace or mount it overrides
+func fetchClientItems(client Client, session string) tea.Cmd {
 	return func() tea.Msg {
-		raw, err := fetchBWRawItem(session, client.BitwardenItemID)
+		p, err := clientProvider(client)
 		if err != nil {
-			return tokenResponseMsg{err: fmt.Errorf("bitwarden: %w", err)}
+			return clientItemsFetchedMsg{client: client.Name, err: err}
 		}
+		items, err := p.listItems(session)
+		return clientItemsFetchedMsg{client: client.Name, items: items, err: err}
+	}
+}
+
+// tuiPrompter forwards interactive grant instructions to the Update loop
+type tuiPrompter chan tea.Msg
 
-		fullItem, err := parseBWFullItem(raw)
+func (p tuiPrompter) authorizeURL(u string) { p.send(authURLMsg{url: u}) }
+
+func (p tuiPrompter) deviceCode(auth DeviceAuthResponse) { p.send(deviceCodeMsg{auth: auth}) }
+
+func (p tuiPrompter) send(msg tea.Msg) {
+	select {
+	case p <- msg:
+	default:
+	}
+}
+
+// requestToken runs the token pipeline, or asks a running agent for the token
+// of a client it serves (see agentServes). Interactive grants run here so
+// their prompts reach the TUI, and DPoP tokens so their key stays available
+// for proofs.
+func requestToken(ctx context.Context, session string, clients []Client, client Client, force bool, prompts tuiPrompter) tea.Cmd {
+	return func() tea.Msg {
+		defer close(prompts)
+		if agentServes(client, clients) {
+			result, err := agentToken(client.Name, force)
+			switch {
+			case errors.Is(err, errAgentNotRunning), isProviderLocked(err):
+				// Fall back to our own session
+			case err != nil:
+				return tokenResponseMsg{err: err}
+			case result != nil:
+				return tokenResponseMsg{result: *result}
+			}
+		}
+		p := tokenPipeline{session: session, clients: clients, prompt: prompts}
+		result, err := p.fetchToken(ctx, client)
+		if ctx.Err() != nil {
+			// Cancelled from the token view; nobody is waiting for the result
+			return nil
+		}
 		if err != nil {
-			return tokenResponseMsg{err: fmt.Errorf("bitwarden parse: %w", err)}
+			return tokenResponseMsg{err: err}
 		}
+		return tokenResponseMsg{result: *result}
+	}
+}
 
-		// Resolve client_id: manual override takes precedence
-		clientID := client.ClientID
-		if clientID == "" {
-			fieldPath := client.ClientIDField
-			if fieldPath == "" {
-				fieldPath = "login.username"
-			}
-			clientID, err = ResolveBWField(fullItem, fieldPath)
-			if err != nil {
-				return tokenResponseMsg{err: fmt.Errorf("resolve client_id (%s): %w", fieldPath, err)}
-			}
+// loadDiskCacheCmd reads the encrypted disk cache, if enabled and its key
+// is available
+func loadDiskCacheCmd(settings Settings, session string) tea.Cmd {
+	return func() tea.Msg {
+		cache, err := openDiskCache(settings, session)
+		if err != nil || cache == nil {
+			return diskCacheMsg{err: err}
 		}
+		tc, err := cache.load()
+		return diskCacheMsg{cache: tc, err: err}
+	}
+}
 
-		// Resolve client_secret: always from Bitwarden
-		secretFieldPath := client.ClientSecretField
-		if secretFieldPath == "" {
-			secretFieldPath = "login.password"
+// persistTokenCmd writes a token to the disk cache, if enabled
+func persistTokenCmd(settings Settings, session string, result TokenResult) tea.Cmd {
+	return func() tea.Msg {
+		cache, err := openDiskCache(settings, session)
+		if err == nil && cache != nil {
+			err = cache.put(result)
 		}
-		clientSecret, err := ResolveBWField(fullItem, secretFieldPath)
-		if err != nil {
-			return tokenResponseMsg{err: fmt.Errorf("resolve client_secret (%s): %w", secretFieldPath, err)}
+		return diskCacheMsg{err: err}
+	}
+}
+
+// shareSessionCmd hands a freshly unlocked session to a running agent
+func shareSessionCmd(session string) tea.Cmd {
+	return func() tea.Msg {
+		callAgent(agentRequest{Op: agentOpUnlock, Session: session})
+		return nil
+	}
+}
+
+// verifyTokenCmd checks the access token against the issuer's JWKS. The
+// audience is only checked for clients that request a specific one.
+func verifyTokenCmd(result TokenResult) tea.Cmd {
+	token := result.Token.AccessToken
+	return func() tea.Msg {
+		return tokenVerifiedMsg{token: token, verification: verifyJWT(token, result.Client.Issuer, result.Client.Audience, time.Now())}
+	}
+}
+
+// introspectCmd introspects the access token of result with its client's
+// credentials
+func introspectCmd(session string, result TokenResult) tea.Cmd {
+	token := result.Token.AccessToken
+	return func() tea.Msg {
+		claims, err := introspect(session, result, token, hintAccessToken)
+		return introspectionMsg{token: token, claims: claims, err: err}
+	}
+}
+
+// userInfoCmd calls the UserInfo endpoint with the access token of result
+func userInfoCmd(result TokenResult) tea.Cmd {
+	token := result.Token.AccessToken
+	return func() tea.Msg {
+		claims, err := userInfo(result)
+		return userInfoMsg{token: token, claims: claims, err: err}
+	}
+}
+
+// revokeCmd revokes tokens of result and drops them from the disk cache
+// and the agent
+func revokeCmd(settings Settings, session string, result TokenResult, tokens []revocation) tea.Cmd {
+	return func() tea.Msg {
+		revoked, err := revoke(session, result, tokens)
+		msg := revokedMsg{tokens: revoked, err: err}
+		if len(revoked) > 0 {
+			msg.cacheErr = forgetRevoked(settings, session, revoked)
 		}
+		return msg
+	}
+}
+
+// cacheTick schedules the next countdown refresh for cached tokens
+func cacheTick() tea.Cmd {
+	return tea.Tick(time.Second, func(time.Time) tea.Msg { return cacheTickMsg{} })
+}
 
-		oidc, err := DiscoverOIDC(client.Issuer)
+// refreshTokenCmd redeems the refresh token of prev. Tokens loaded from the
+// disk cache carry no credentials, so those are resolved again first.
+func refreshTokenCmd(ctx context.Context, session string, prev TokenResult) tea.Cmd {
+	return func() tea.Msg {
+		var result *TokenResult
+		var err error
+		if prev.creds.ClientID == "" {
+			result, err = fetchRefreshedToken(session, prev.Client, prev.Token.RefreshToken)
+		} else {
+			result, err = refreshToken(prev)
+		}
+		if ctx.Err() != nil {
+			// Left the token view or opened another token meanwhile
+			return nil
+		}
 		if err != nil {
-			return tokenResponseMsg{err: fmt.Errorf("oidc discovery: %w", err)}
+			return tokenResponseMsg{err: err}
 		}
+		return tokenResponseMsg{result: *result}
+	}
+}
 
-		token, err := RequestToken(oidc.TokenEndpoint, clientID, clientSecret, client.Scopes)
-		if err != nil {
-			return tokenResponseMsg{err: fmt.Errorf("token request: %w", err)}
+// waitForPrompt delivers the next message from a running token pipeline
+func waitForPrompt(prompts tuiPrompter) tea.Cmd {
+	return func() tea.Msg {
+		msg, ok := <-prompts
+		if !ok {
+			return nil
 		}
+		return msg
+	}
+}
 
-		return tokenResponseMsg{
-			result: TokenResult{Token: *token, Client: client, FetchedAt: time.Now()},
+// waitForProxyLog delivers the next log line of a running proxy
+func waitForProxyLog(p *tuiProxy) tea.Cmd {
+	return func() tea.Msg {
+		select {
+		case line := <-p.logs:
+			return proxyLogMsg{line: line}
+		case <-p.done:
+			return nil
 		}
 	}
 }
 
+func openBrowserCmd(u string) tea.Cmd {
+	return func() tea.Msg {
+		openBrowser(u)
+		return nil
+	}
+}
+
 func copyToClipboard(text string, what string) tea.Cmd {
 	return func() tea.Msg {
 		err := clipboard.WriteAll(text)
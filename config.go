@@ -7,7 +7,16 @@ import (
 )
 
 type clientsFile struct {
-	Clients []Client `json:"clients"`
+	Settings Settings `json:"settings,omitzero"`
+	Clients  []Client `json:"clients"`
+}
+
+// Settings are global options, edited by hand in clients.json
+type Settings struct {
+	// DiskCache persists tokens encrypted on disk: "" (off), "session"
+	// (key derived from the Bitwarden session) or "keyring" (key in the
+	// OS keyring)
+	DiskCache string `json:"disk_cache,omitempty"`
 }
 
 func getConfigDir() string {
@@ -28,26 +37,37 @@ func saveClients(clients []Client) error {
 }
 
 func loadClientsFrom(path string) ([]Client, error) {
+	f, err := loadClientsFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return f.Clients, nil
+}
+
+func loadClientsFile(path string) (clientsFile, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return []Client{}, nil
+			return clientsFile{Clients: []Client{}}, nil
 		}
-		return nil, err
+		return clientsFile{}, err
 	}
 
 	var f clientsFile
 	if err := json.Unmarshal(data, &f); err != nil {
-		return nil, err
+		return clientsFile{}, err
 	}
-	return f.Clients, nil
+	return f, nil
 }
 
+// saveClientsTo writes the clients, keeping the settings already in the file
 func saveClientsTo(path string, clients []Client) error {
 	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
 		return err
 	}
-	data, err := json.MarshalIndent(clientsFile{Clients: clients}, "", "  ")
+	// An unreadable file is overwritten, as before settings existed
+	existing, _ := loadClientsFile(path)
+	data, err := json.MarshalIndent(clientsFile{Settings: existing.Settings, Clients: clients}, "", "  ")
 	if err != nil {
 		return err
 	}
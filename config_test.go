@@ -23,16 +23,16 @@ func TestSaveAndLoadClients(t *testing.T) {
 
 	clients := []Client{
 		{
-			Name:            "test-client",
-			BitwardenItemID: "abc-123",
-			Issuer:          "https://auth.example.com",
-			Scopes:          "openid profile",
+			Name:   "test-client",
+			ItemID: "abc-123",
+			Issuer: "https://auth.example.com",
+			Scopes: "openid profile",
 		},
 		{
-			Name:            "another-client",
-			BitwardenItemID: "def-456",
-			Issuer:          "https://other.example.com/realms/dev",
-			Scopes:          "email",
+			Name:   "another-client",
+			ItemID: "def-456",
+			Issuer: "https://other.example.com/realms/dev",
+			Scopes: "email",
 		},
 	}
 
@@ -53,8 +53,8 @@ func TestSaveAndLoadClients(t *testing.T) {
 	if loaded[0].Name != "test-client" {
 		t.Errorf("expected name 'test-client', got '%s'", loaded[0].Name)
 	}
-	if loaded[0].BitwardenItemID != "abc-123" {
-		t.Errorf("expected BW item ID 'abc-123', got '%s'", loaded[0].BitwardenItemID)
+	if loaded[0].ItemID != "abc-123" {
+		t.Errorf("expected BW item ID 'abc-123', got '%s'", loaded[0].ItemID)
 	}
 	if loaded[0].Issuer != "https://auth.example.com" {
 		t.Errorf("expected issuer 'https://auth.example.com', got '%s'", loaded[0].Issuer)
@@ -104,7 +104,7 @@ func TestSaveAndLoadClientsWithFieldMapping(t *testing.T) {
 	clients := []Client{
 		{
 			Name:              "custom-fields",
-			BitwardenItemID:   "bw-123",
+			ItemID:            "bw-123",
 			Issuer:            "https://auth.example.com",
 			Scopes:            "openid",
 			ClientID:          "manual-id",
@@ -112,10 +112,10 @@ func TestSaveAndLoadClientsWithFieldMapping(t *testing.T) {
 			ClientSecretField: "fields.api_key",
 		},
 		{
-			Name:            "defaults",
-			BitwardenItemID: "bw-456",
-			Issuer:          "https://other.example.com",
-			Scopes:          "profile",
+			Name:   "defaults",
+			ItemID: "bw-456",
+			Issuer: "https://other.example.com",
+			Scopes: "profile",
 		},
 	}
 
@@ -206,3 +206,73 @@ func TestSaveOverwritesExisting(t *testing.T) {
 		t.Errorf("expected 'only', got '%s'", loaded[0].Name)
 	}
 }
+
+func TestSaveAndLoadGrantSettings(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clients.json")
+	clients := []Client{{
+		Name:       "legacy-realm",
+		ItemID:     "bw-client",
+		Issuer:     "https://auth.example.com",
+		GrantType:  grantPassword,
+		UserItemID: "bw-user",
+	}}
+	if err := saveClientsTo(path, clients); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := loadClientsFrom(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded[0].GrantType != grantPassword || loaded[0].UserItemID != "bw-user" {
+		t.Errorf("expected password grant with user item, got %+v", loaded[0])
+	}
+	if (Client{}).grant() != grantClientCredentials {
+		t.Error("expected empty grant type to default to client_credentials")
+	}
+}
+
+func TestSaveAndLoadPrivateKeySettings(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clients.json")
+	clients := []Client{{
+		Name:            "jwt-client",
+		ItemID:          "bw-client",
+		Issuer:          "https://auth.example.com",
+		AuthMethod:      authPrivateKeyJWT,
+		PrivateKeyField: "attachments.client.pem",
+		SigningAlg:      "PS256",
+		KeyID:           "key-1",
+		TLSCertField:    "attachments.client.crt",
+		TLSKeyField:     "fields.tls_key",
+	}}
+	if err := saveClientsTo(path, clients); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := loadClientsFrom(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := loaded[0]
+	if got.AuthMethod != authPrivateKeyJWT || got.PrivateKeyField != "attachments.client.pem" || got.SigningAlg != "PS256" || got.KeyID != "key-1" {
+		t.Errorf("private key settings not preserved: %+v", got)
+	}
+	if got.TLSCertField != "attachments.client.crt" || got.TLSKeyField != "fields.tls_key" {
+		t.Errorf("TLS certificate settings not preserved: %+v", got)
+	}
+}
+
+func TestSaveClientsKeepsSettings(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clients.json")
+	if err := os.WriteFile(path, []byte(`{"settings":{"disk_cache":"keyring"},"clients":[]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := saveClientsTo(path, []Client{{Name: "a"}}); err != nil {
+		t.Fatal(err)
+	}
+	f, err := loadClientsFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Settings.DiskCache != diskCacheKeyring || len(f.Clients) != 1 {
+		t.Errorf("expected settings to survive saving clients, got %+v", f)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// deviceGrantType is the grant_type used when polling for a device code (RFC 8628 §3.4)
+const deviceGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+// devicePollUnit is the unit for polling intervals; tests shrink it
+var devicePollUnit = time.Second
+
+// RequestDeviceCode starts a device authorization flow (RFC 8628 §3.1)
+func RequestDeviceCode(endpoint string, creds clientCredentials, scopes string) (*DeviceAuthResponse, error) {
+	if !strings.HasPrefix(endpoint, "https://") {
+		return nil, fmt.Errorf("device authorization endpoint must use HTTPS: %s", endpoint)
+	}
+	data := url.Values{}
+	if scopes != "" {
+		data.Set("scope", scopes)
+	}
+
+	resp, err := postAuthenticatedForm(endpoint, creds, data)
+	if err != nil {
+		return nil, fmt.Errorf("device authorization request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read device authorization response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("device authorization endpoint returned status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var auth DeviceAuthResponse
+	if err := json.Unmarshal(body, &auth); err != nil {
+		return nil, fmt.Errorf("failed to parse device authorization response: %w", err)
+	}
+	if auth.DeviceCode == "" || auth.UserCode == "" || auth.VerificationURI == "" {
+		return nil, fmt.Errorf("device authorization response missing device_code, user_code or verification_uri")
+	}
+	return &auth, nil
+}
+
+// PollDeviceToken polls the token endpoint until the user approves the device,
+// honoring interval, authorization_pending and slow_down (RFC 8628 §3.5).
+func PollDeviceToken(ctx context.Context, tokenEndpoint string, creds clientCredentials, auth *DeviceAuthResponse) (*TokenResponse, error) {
+	interval := time.Duration(auth.Interval) * devicePollUnit
+	if interval <= 0 {
+		interval = 5 * devicePollUnit
+	}
+	if auth.ExpiresIn > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(auth.ExpiresIn)*devicePollUnit)
+		defer cancel()
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return nil, fmt.Errorf("device code expired before authorization")
+			}
+			return nil, ctx.Err()
+		case <-time.After(interval):
+		}
+
+		// Fresh form per poll: private_key_jwt assertions are single-use
+		data := url.Values{
+			"grant_type":  {deviceGrantType},
+			"device_code": {auth.DeviceCode},
+		}
+		token, err := postTokenForm(tokenEndpoint, creds, data)
+		if err == nil {
+			return token, nil
+		}
+		var oauthErr *OAuthError
+		if !errors.As(err, &oauthErr) {
+			return nil, err
+		}
+		switch oauthErr.Code {
+		case "authorization_pending":
+		case "slow_down":
+			interval += 5 * devicePollUnit
+		case "access_denied":
+			return nil, fmt.Errorf("authorization denied by user")
+		case "expired_token":
+			return nil, fmt.Errorf("device code expired before authorization")
+		default:
+			return nil, err
+		}
+	}
+}
+
+// deviceCodeGrant runs the whole device flow: request code, prompt, poll
+func deviceCodeGrant(ctx context.Context, oidc *OIDCConfig, creds clientCredentials, scopes string, prompt flowPrompter) (*TokenResponse, error) {
+	auth, err := RequestDeviceCode(oidc.DeviceAuthorizationEndpoint, creds, scopes)
+	if err != nil {
+		return nil, err
+	}
+	prompt.deviceCode(*auth)
+	return PollDeviceToken(ctx, oidc.TokenEndpoint, creds, auth)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fastDevicePolling shrinks the device flow polling unit for the test
+func fastDevicePolling(t *testing.T) {
+	t.Helper()
+	orig := devicePollUnit
+	devicePollUnit = time.Millisecond
+	t.Cleanup(func() { devicePollUnit = orig })
+}
+
+// recordingPrompter remembers the device code it was shown
+type recordingPrompter struct {
+	device *DeviceAuthResponse
+}
+
+func (p *recordingPrompter) authorizeURL(string) {}
+
+func (p *recordingPrompter) deviceCode(auth DeviceAuthResponse) { p.device = &auth }
+
+func writeOAuthError(w http.ResponseWriter, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]string{"error": code})
+}
+
+func TestDeviceCodeGrant(t *testing.T) {
+	fastDevicePolling(t)
+	var polls atomic.Int32
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/device", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.FormValue("client_id") != "cli-app" {
+			t.Errorf("expected client_id 'cli-app', got %q", r.FormValue("client_id"))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{
+			"device_code": "dev-123",
+			"user_code": "ABCD-EFGH",
+			"verification_uri": "https://auth.example.com/device",
+			"expires_in": 600,
+			"interval": 1
+		}`))
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.FormValue("grant_type") != deviceGrantType {
+			t.Errorf("expected device grant type, got %q", r.FormValue("grant_type"))
+		}
+		if r.FormValue("device_code") != "dev-123" {
+			t.Errorf("expected device_code 'dev-123', got %q", r.FormValue("device_code"))
+		}
+		switch polls.Add(1) {
+		case 1:
+			writeOAuthError(w, "authorization_pending")
+		case 2:
+			writeOAuthError(w, "slow_down")
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"access_token": "device-token", "token_type": "Bearer", "expires_in": 300}`))
+		}
+	})
+	server := httptest.NewTLSServer(mux)
+	defer server.Close()
+	useTLSServer(t, server)
+
+	oidc := &OIDCConfig{DeviceAuthorizationEndpoint: server.URL + "/device", TokenEndpoint: server.URL + "/token"}
+	prompt := &recordingPrompter{}
+
+	token, err := deviceCodeGrant(context.Background(), oidc, clientCredentials{ClientID: "cli-app"}, "openid", prompt)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if token.AccessToken != "device-token" {
+		t.Errorf("expected 'device-token', got %q", token.AccessToken)
+	}
+	if polls.Load() != 3 {
+		t.Errorf("expected 3 polls, got %d", polls.Load())
+	}
+	if prompt.device == nil || prompt.device.UserCode != "ABCD-EFGH" {
+		t.Errorf("expected user code to be shown, got %+v", prompt.device)
+	}
+}
+
+func TestPollDeviceTokenDenied(t *testing.T) {
+	fastDevicePolling(t)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeOAuthError(w, "access_denied")
+	}))
+	defer server.Close()
+	useTLSServer(t, server)
+
+	_, err := PollDeviceToken(context.Background(), server.URL, clientCredentials{ClientID: "id"}, &DeviceAuthResponse{DeviceCode: "d", Interval: 1})
+	if err == nil || !strings.Contains(err.Error(), "denied") {
+		t.Errorf("expected denied error, got %v", err)
+	}
+}
+
+func TestPollDeviceTokenExpires(t *testing.T) {
+	fastDevicePolling(t)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeOAuthError(w, "authorization_pending")
+	}))
+	defer server.Close()
+	useTLSServer(t, server)
+
+	_, err := PollDeviceToken(context.Background(), server.URL, clientCredentials{ClientID: "id"}, &DeviceAuthResponse{DeviceCode: "d", Interval: 1, ExpiresIn: 20})
+	if err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Errorf("expected expiry error, got %v", err)
+	}
+}
+
+func TestPollDeviceTokenUnexpectedError(t *testing.T) {
+	fastDevicePolling(t)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeOAuthError(w, "invalid_client")
+	}))
+	defer server.Close()
+	useTLSServer(t, server)
+
+	_, err := PollDeviceToken(context.Background(), server.URL, clientCredentials{ClientID: "id"}, &DeviceAuthResponse{DeviceCode: "d", Interval: 1})
+	if err == nil || !strings.Contains(err.Error(), "invalid_client") {
+		t.Errorf("expected invalid_client error, got %v", err)
+	}
+}
+
+func TestRequestDeviceCodeRejectsHTTP(t *testing.T) {
+	_, err := RequestDeviceCode("http://auth.example.com/device", clientCredentials{ClientID: "id"}, "")
+	if err == nil || !strings.Contains(err.Error(), "HTTPS") {
+		t.Errorf("expected HTTPS error, got %v", err)
+	}
+}
+
+func TestOAuthErrorParsesCode(t *testing.T) {
+	e := newOAuthError(400, []byte(`{"error":"slow_down","error_description":"too fast"}`))
+	if e.Code != "slow_down" || e.Description != "too fast" || e.StatusCode != 400 {
+		t.Errorf("unexpected parsed error: %+v", e)
+	}
+	if !strings.Contains(e.Error(), "status 400") {
+		t.Errorf("expected status in message, got %q", e.Error())
+	}
+}
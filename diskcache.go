@@ -0,0 +1,244 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Disk cache key sources (Settings.DiskCache)
+const (
+	diskCacheOff     = ""
+	diskCacheSession = "session"
+	diskCacheKeyring = "keyring"
+)
+
+// diskCacheInfo is the HKDF info and AEAD additional data; bumping it
+// invalidates every existing cache file
+const diskCacheInfo = "tkz token cache v1"
+
+func getTokenCachePath() string {
+	return filepath.Join(getConfigDir(), "tokens.cache")
+}
+
+// errNoCacheSession means disk_cache "session" has no key: the Bitwarden
+// vault is locked, or the provider has no session to derive one from
+var errNoCacheSession = errors.New(`disk cache: disk_cache "session" needs an unlocked Bitwarden session (use "keyring" with other providers)`)
+
+// diskCache is the encrypted token cache file shared by TUI and CLI runs.
+// Entries that cannot be decrypted (another session's key) read as empty.
+type diskCache struct {
+	path string
+	aead cipher.AEAD
+}
+
+// diskCacheFile is the on-disk envelope; everything but the nonce is sealed
+type diskCacheFile struct {
+	Nonce []byte `json:"nonce"`
+	Data  []byte `json:"data"`
+}
+
+// tokenRecord is the part of a TokenResult that can leave the process, for
+// the disk cache and the agent. Credentials never do, so a refresh resolves
+// them again.
+type tokenRecord struct {
+	Token         TokenResponse `json:"token"`
+	Client        Client        `json:"client"`
+	FetchedAt     time.Time     `json:"fetched_at"`
+	TokenEndpoint string        `json:"token_endpoint"`
+}
+
+func recordOf(r TokenResult) tokenRecord {
+	return tokenRecord{Token: r.Token, Client: r.Client, FetchedAt: r.FetchedAt, TokenEndpoint: r.TokenEndpoint}
+}
+
+func (e tokenRecord) result() TokenResult {
+	return TokenResult{Token: e.Token, Client: e.Client, FetchedAt: e.FetchedAt, TokenEndpoint: e.TokenEndpoint}
+}
+
+// openDiskCache returns the disk cache for the configured key source, or
+// nil if the cache is off. Without a session, session mode fails with
+// errNoCacheSession.
+func openDiskCache(settings Settings, session string) (*diskCache, error) {
+	var key []byte
+	switch settings.DiskCache {
+	case diskCacheOff:
+		return nil, nil
+	case diskCacheSession:
+		if session == "" {
+			return nil, errNoCacheSession
+		}
+		var err error
+		if key, err = hkdf.Key(sha256.New, []byte(session), nil, diskCacheInfo, 32); err != nil {
+			return nil, fmt.Errorf("disk cache: derive key: %w", err)
+		}
+	case diskCacheKeyring:
+		var err error
+		if key, err = keyringCacheKey(); err != nil {
+			return nil, fmt.Errorf("disk cache: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("disk cache: unknown disk_cache setting %q (use session or keyring)", settings.DiskCache)
+	}
+	return newDiskCache(getTokenCachePath(), key)
+}
+
+func newDiskCache(path string, key []byte) (*diskCache, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("disk cache: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("disk cache: %w", err)
+	}
+	return &diskCache{path: path, aead: aead}, nil
+}
+
+// load decrypts the cache file into a tokenCache, leaving out expired tokens
+func (d *diskCache) load() (tokenCache, error) {
+	tc := make(tokenCache)
+	data, err := os.ReadFile(d.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return tc, nil
+		}
+		return nil, err
+	}
+
+	var f diskCacheFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return tc, nil
+	}
+	plain, err := d.aead.Open(nil, f.Nonce, f.Data, []byte(diskCacheInfo))
+	if err != nil {
+		// Written under a different key; it is replaced on the next put
+		return tc, nil
+	}
+
+	var entries map[string]tokenRecord
+	if err := json.Unmarshal(plain, &entries); err != nil {
+		return tc, nil
+	}
+	now := time.Now()
+	for k, e := range entries {
+		if r := e.result(); now.Before(r.ExpiresAt()) {
+			tc[k] = r
+		}
+	}
+	return tc, nil
+}
+
+// get returns a fresh cached token for the client
+func (d *diskCache) get(c Client) (TokenResult, bool) {
+	tc, err := d.load()
+	if err != nil {
+		return TokenResult{}, false
+	}
+	return tc.get(c, time.Now())
+}
+
+// put stores a token, evicting expired ones. DPoP-bound tokens are skipped:
+// their key dies with the process that requested them. Records from the
+// agent or the cache carry no credentials, so the client and token type
+// count as well.
+func (d *diskCache) put(r TokenResult) error {
+	if r.dpopBound() || r.staleAt().IsZero() {
+		return nil
+	}
+	return d.update(func(tc tokenCache) bool {
+		tc.put(r)
+		return true
+	})
+}
+
+// forget removes cached copies of a token (access or refresh token)
+func (d *diskCache) forget(token string) error {
+	return d.update(func(tc tokenCache) bool {
+		return tc.forget(token)
+	})
+}
+
+// update applies change to the cache and saves it if change reports true.
+// The TUI, CLI runs and the agent share the file, so the read-modify-write
+// holds a lock file to not drop each other's entries.
+func (d *diskCache) update(change func(tokenCache) bool) error {
+	if err := os.MkdirAll(filepath.Dir(d.path), 0700); err != nil {
+		return err
+	}
+	unlock, err := lockFile(d.path + ".lock")
+	if err != nil {
+		return fmt.Errorf("lock %s: %w", d.path, err)
+	}
+	defer unlock()
+
+	tc, err := d.load()
+	if err != nil {
+		return err
+	}
+	if !change(tc) {
+		return nil
+	}
+	return d.save(tc)
+}
+
+// quietCacheErr reports whether a disk cache error need not be shown: a
+// Bitwarden client without a session stops at the locked vault anyway
+func quietCacheErr(err error, client Client, clients []Client) bool {
+	return errors.Is(err, errNoCacheSession) && usesBitwarden(client, clients)
+}
+
+// save encrypts the cache and atomically replaces the file with a 0600 one
+func (d *diskCache) save(tc tokenCache) error {
+	entries := make(map[string]tokenRecord, len(tc))
+	for k, r := range tc {
+		entries[k] = recordOf(r)
+	}
+	plain, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, d.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	data, err := json.Marshal(diskCacheFile{Nonce: nonce, Data: d.aead.Seal(nil, nonce, plain, []byte(diskCacheInfo))})
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(d.path), 0700); err != nil {
+		return err
+	}
+	// CreateTemp opens files with 0600
+	tmp, err := os.CreateTemp(filepath.Dir(d.path), ".tokens-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), d.path)
+}
+
+// clearDiskCache deletes the cache file
+func clearDiskCache() error {
+	err := os.Remove(getTokenCachePath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func testDiskCache(t *testing.T, path string, key byte) *diskCache {
+	t.Helper()
+	d, err := newDiskCache(path, bytes.Repeat([]byte{key}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestDiskCacheRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.cache")
+	client := Client{Name: "kc-dev", Issuer: "https://auth.example.com", Scopes: "openid"}
+	d := testDiskCache(t, path, 1)
+
+	err := d.put(TokenResult{
+		Token:         TokenResponse{AccessToken: "secret-access-token", ExpiresIn: 300, RefreshToken: "rt"},
+		Client:        client,
+		FetchedAt:     time.Now(),
+		TokenEndpoint: "https://auth.example.com/token",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm() != 0600 {
+		t.Errorf("expected 0600 permissions, got %o", info.Mode().Perm())
+	}
+	data, _ := os.ReadFile(path)
+	if bytes.Contains(data, []byte("secret-access-token")) || bytes.Contains(data, []byte("kc-dev")) {
+		t.Error("expected cache file contents to be encrypted")
+	}
+
+	got, ok := testDiskCache(t, path, 1).get(client)
+	if !ok {
+		t.Fatal("expected cache hit")
+	}
+	if got.Token.AccessToken != "secret-access-token" || got.Token.RefreshToken != "rt" || got.TokenEndpoint != "https://auth.example.com/token" {
+		t.Errorf("unexpected cached result: %+v", got)
+	}
+
+	// Another key (e.g. a new Bitwarden session) cannot read it
+	other := testDiskCache(t, path, 2)
+	if _, ok := other.get(client); ok {
+		t.Error("expected miss with a different key")
+	}
+	if err := other.put(TokenResult{Token: TokenResponse{AccessToken: "new", ExpiresIn: 300}, Client: client, FetchedAt: time.Now()}); err != nil {
+		t.Fatalf("expected unreadable cache to be replaced, got %v", err)
+	}
+	if got, ok := other.get(client); !ok || got.Token.AccessToken != "new" {
+		t.Error("expected the replaced cache to be readable with the new key")
+	}
+}
+
+func TestDiskCacheEvictsExpired(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.cache")
+	d := testDiskCache(t, path, 1)
+	old := Client{Name: "old"}
+	if err := d.save(tokenCache{cacheKey(old): {
+		Token:     TokenResponse{AccessToken: "old-token", ExpiresIn: 60},
+		Client:    old,
+		FetchedAt: time.Now().Add(-time.Hour),
+	}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.put(TokenResult{Token: TokenResponse{AccessToken: "tok", ExpiresIn: 300}, Client: Client{Name: "fresh"}, FetchedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	tc, err := d.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := tc[cacheKey(old)]; ok || len(tc) != 1 {
+		t.Errorf("expected only the fresh token to remain, got %d entries", len(tc))
+	}
+}
+
+func TestDiskCacheConcurrentWriters(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.cache")
+	// Each writer opens the file on its own, like separate processes
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := TokenResult{Token: TokenResponse{AccessToken: "tok", ExpiresIn: 300}, Client: Client{Name: fmt.Sprint("c", i)}, FetchedAt: time.Now()}
+			if err := testDiskCache(t, path, 1).put(r); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	tc, err := testDiskCache(t, path, 1).load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tc) != 20 {
+		t.Errorf("expected every writer's token to be kept, got %d", len(tc))
+	}
+}
+
+func TestDiskCacheSkipsDPoPTokens(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.cache")
+	k, _ := newDPoPKey()
+	d := testDiskCache(t, path, 1)
+	err := d.put(TokenResult{
+		Token:     TokenResponse{AccessToken: "tok", TokenType: "DPoP", ExpiresIn: 300},
+		Client:    Client{Name: "dpop"},
+		FetchedAt: time.Now(),
+		creds:     clientCredentials{dpop: k},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("expected DPoP-bound token not to be written")
+	}
+
+	// Records served by the agent or loaded from a cache carry no creds
+	err = d.put(TokenResult{
+		Token:     TokenResponse{AccessToken: "tok", ExpiresIn: 300},
+		Client:    Client{Name: "dpop", DPoP: true},
+		FetchedAt: time.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("expected a DPoP client's token without creds not to be written")
+	}
+}
+
+func TestOpenDiskCache(t *testing.T) {
+	useConfigHome(t, nil)
+
+	if d, err := openDiskCache(Settings{}, "session"); d != nil || err != nil {
+		t.Errorf("expected cache off by default, got %v / %v", d, err)
+	}
+	if d, err := openDiskCache(Settings{DiskCache: diskCacheSession}, ""); d != nil || !errors.Is(err, errNoCacheSession) {
+		t.Errorf("expected session mode to say it needs a session, got %v / %v", d, err)
+	}
+	bw := Client{Name: "bw"}
+	pass := Client{Name: "pass", Provider: providerPass}
+	if !quietCacheErr(errNoCacheSession, bw, nil) || quietCacheErr(errNoCacheSession, pass, nil) {
+		t.Error("expected the missing session to be reported for non-Bitwarden clients only")
+	}
+	if _, err := openDiskCache(Settings{DiskCache: "plaintext"}, "session"); err == nil {
+		t.Error("expected error for unknown disk_cache setting")
+	}
+
+	a, err := openDiskCache(Settings{DiskCache: diskCacheSession}, "session-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := Client{Name: "kc"}
+	if err := a.put(TokenResult{Token: TokenResponse{AccessToken: "tok", ExpiresIn: 300}, Client: client, FetchedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	same, _ := openDiskCache(Settings{DiskCache: diskCacheSession}, "session-a")
+	if _, ok := same.get(client); !ok {
+		t.Error("expected the same session to derive the same key")
+	}
+	other, _ := openDiskCache(Settings{DiskCache: diskCacheSession}, "session-b")
+	if _, ok := other.get(client); ok {
+		t.Error("expected a new session not to read the old cache")
+	}
+}
+
+// fakeSecretTool puts a secret-tool script on PATH that keeps its one
+// secret in a file
+func fakeSecretTool(t *testing.T) string {
+	t.Helper()
+	if runtime.GOOS != "linux" {
+		t.Skip("secret-tool keyring backend is Linux only")
+	}
+	dir := t.TempDir()
+	store := filepath.Join(dir, "secret")
+	script := `#!/bin/sh
+case "$1" in
+lookup)
+	if [ -f "` + store + `.locked" ]; then
+		echo "secret-tool: Cannot create an item in a locked collection" >&2
+		exit 1
+	fi
+	[ -f "` + store + `" ] && cat "` + store + `" || exit 1 ;;
+store) cat > "` + store + `" ;;
+esac
+`
+	if err := os.WriteFile(filepath.Join(dir, "secret-tool"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return store
+}
+
+func TestKeyringCacheKey(t *testing.T) {
+	store := fakeSecretTool(t)
+
+	key, err := keyringCacheKey()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(key) != 32 {
+		t.Fatalf("expected 32-byte key, got %d", len(key))
+	}
+	if _, err := os.Stat(store); err != nil {
+		t.Fatal("expected key to be stored in the keyring")
+	}
+
+	again, err := keyringCacheKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(key, again) {
+		t.Error("expected the stored key to be reused")
+	}
+
+	// A keyring that fails to answer must not get a new key
+	if err := os.WriteFile(store+".locked", nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keyringCacheKey(); err == nil || !strings.Contains(err.Error(), "locked collection") {
+		t.Errorf("expected the keyring error, got %v", err)
+	}
+	if stored, _ := os.ReadFile(store); string(stored) != base64.StdEncoding.EncodeToString(key) {
+		t.Error("expected the stored key to be kept")
+	}
+}
+
+func TestRunTokenCmdServesDiskCache(t *testing.T) {
+	useConfigHome(t, []Client{})
+	client := Client{Name: "kc-dev", ItemID: "item", Issuer: "https://auth.example.com"}
+	data, _ := json.Marshal(clientsFile{Settings: Settings{DiskCache: diskCacheSession}, Clients: []Client{client}})
+	if err := os.WriteFile(getClientsPath(), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := openDiskCache(Settings{DiskCache: diskCacheSession}, "sess")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.put(TokenResult{Token: TokenResponse{AccessToken: "from-disk", ExpiresIn: 300}, Client: client, FetchedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A hit never reaches bw or the issuer
+	var stdout, stderr bytes.Buffer
+	if code := runTokenCmd([]string{"kc-dev"}, "sess", &stdout, &stderr); code != exitOK {
+		t.Fatalf("expected exit 0, got %d: %s", code, stderr.String())
+	}
+	if strings.TrimSpace(stdout.String()) != "from-disk" {
+		t.Errorf("expected cached token, got %q", stdout.String())
+	}
+}
+
+func TestRunRefreshCmdUsesDiskCache(t *testing.T) {
+	useFakeProvider(t, fakeProvider{name: "fake", items: map[string]map[string]string{
+		"api": {"id": "app", "secret": "s3cret"},
+	}})
+	server := newFakeIssuer(t, func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.FormValue("refresh_token") != "rt-1" {
+			t.Errorf("expected the cached refresh token, got %q", r.FormValue("refresh_token"))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token": "at-2", "token_type": "Bearer", "expires_in": 300, "refresh_token": "rt-2"}`))
+	})
+	useConfigHome(t, []Client{})
+	client := Client{Name: "kc", Provider: "fake", ItemID: "api", Issuer: server.URL}
+	settings := Settings{DiskCache: diskCacheSession}
+	data, _ := json.Marshal(clientsFile{Settings: settings, Clients: []Client{client}})
+	if err := os.WriteFile(getClientsPath(), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	d, err := openDiskCache(settings, "sess")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.put(TokenResult{Token: TokenResponse{AccessToken: "at-1", RefreshToken: "rt-1", ExpiresIn: 300}, Client: client, FetchedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := runRefreshCmd([]string{"kc"}, "sess", strings.NewReader(""), &stdout, &stderr); code != exitOK {
+		t.Fatalf("expected exit 0, got %d: %s", code, stderr.String())
+	}
+	if strings.TrimSpace(stdout.String()) != "at-2" {
+		t.Errorf("expected the refreshed token, got %q", stdout.String())
+	}
+
+	// tkz token now serves the refreshed token, not the old one
+	stdout.Reset()
+	if code := runTokenCmd([]string{"kc"}, "sess", &stdout, &stderr); code != exitOK {
+		t.Fatalf("expected exit 0, got %d: %s", code, stderr.String())
+	}
+	if strings.TrimSpace(stdout.String()) != "at-2" {
+		t.Errorf("expected the refreshed token from the cache, got %q", stdout.String())
+	}
+}
+
+func TestRunCacheCmd(t *testing.T) {
+	useConfigHome(t, []Client{})
+	if err := os.WriteFile(getTokenCachePath(), []byte("{}"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := runCacheCmd([]string{"clear"}, &stdout, &stderr); code != exitOK {
+		t.Fatalf("expected exit 0, got %d: %s", code, stderr.String())
+	}
+	if _, err := os.Stat(getTokenCachePath()); !os.IsNotExist(err) {
+		t.Error("expected cache file to be removed")
+	}
+	if code := runCacheCmd([]string{"clear"}, &stdout, &stderr); code != exitOK {
+		t.Error("expected clearing a missing cache to succeed")
+	}
+	if code := runCacheCmd(nil, &stdout, &stderr); code != exitUsage {
+		t.Errorf("expected usage error, got %d", code)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dpopKey is the ephemeral key pair DPoP proofs are signed with (RFC 9449).
+// It lives only as long as the tkz process, so DPoP-bound tokens cannot be
+// used once tkz exits.
+type dpopKey struct {
+	key *ecdsa.PrivateKey
+	jwk map[string]string
+
+	mu     sync.Mutex
+	nonces map[string]string // Last DPoP-Nonce per server origin
+}
+
+// sessionDPoPKey returns the key shared by all DPoP clients in this session
+var sessionDPoPKey = sync.OnceValues(newDPoPKey)
+
+func newDPoPKey() (*dpopKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generate DPoP key: %w", err)
+	}
+	pub, err := key.PublicKey.ECDH()
+	if err != nil {
+		return nil, fmt.Errorf("generate DPoP key: %w", err)
+	}
+	// Uncompressed point: 0x04 || x || y
+	point := pub.Bytes()
+	return &dpopKey{
+		key: key,
+		jwk: map[string]string{
+			"kty": "EC",
+			"crv": "P-256",
+			"x":   base64.RawURLEncoding.EncodeToString(point[1:33]),
+			"y":   base64.RawURLEncoding.EncodeToString(point[33:]),
+		},
+		nonces: make(map[string]string),
+	}, nil
+}
+
+// thumbprint returns the RFC 7638 JWK thumbprint, which DPoP-bound access
+// tokens carry as cnf.jkt
+func (k *dpopKey) thumbprint() string {
+	// Required members in lexicographic order, no whitespace
+	canonical := fmt.Sprintf(`{"crv":%q,"kty":%q,"x":%q,"y":%q}`, k.jwk["crv"], k.jwk["kty"], k.jwk["x"], k.jwk["y"])
+	sum := sha256.Sum256([]byte(canonical))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// proof signs a DPoP proof JWT for an HTTP request. accessToken is set for
+// requests to resource servers and binds the proof to it via ath.
+func (k *dpopKey) proof(method, target, accessToken string) (string, error) {
+	htu, err := dpopHTU(target)
+	if err != nil {
+		return "", err
+	}
+	jti, err := randomURLString(16)
+	if err != nil {
+		return "", err
+	}
+	claims := map[string]any{
+		"jti": jti,
+		"htm": strings.ToUpper(method),
+		"htu": htu,
+		"iat": time.Now().Unix(),
+	}
+	if nonce := k.nonce(target); nonce != "" {
+		claims["nonce"] = nonce
+	}
+	if accessToken != "" {
+		sum := sha256.Sum256([]byte(accessToken))
+		claims["ath"] = base64.RawURLEncoding.EncodeToString(sum[:])
+	}
+	header := map[string]any{"typ": "dpop+jwt", "jwk": k.jwk}
+	return signJWT(k.key, "ES256", header, claims)
+}
+
+// setNonce remembers a DPoP-Nonce a server handed out for later proofs
+func (k *dpopKey) setNonce(target, nonce string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.nonces[dpopOrigin(target)] = nonce
+}
+
+func (k *dpopKey) nonce(target string) string {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.nonces[dpopOrigin(target)]
+}
+
+// dpopHTU returns the htu claim: the target URI without query and fragment
+func dpopHTU(target string) (string, error) {
+	u, err := url.Parse(target)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return "", fmt.Errorf("DPoP target must be an absolute URL: %s", target)
+	}
+	u.RawQuery = ""
+	u.Fragment = ""
+	return u.String(), nil
+}
+
+func dpopOrigin(target string) string {
+	u, err := url.Parse(target)
+	if err != nil {
+		return target
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// isDPoPNonceError reports whether a response body is the use_dpop_nonce
+// error a server returns to demand a nonce (RFC 9449 §8)
+func isDPoPNonceError(body []byte) bool {
+	var e OAuthError
+	return json.Unmarshal(body, &e) == nil && e.Code == "use_dpop_nonce"
+}
+
+// parseProofTarget parses user input like "POST https://api.example.com/x"
+// into a method and URL; a bare URL means GET
+func parseProofTarget(input string) (method, target string, err error) {
+	fields := strings.Fields(input)
+	switch len(fields) {
+	case 1:
+		method, target = "GET", fields[0]
+	case 2:
+		method, target = strings.ToUpper(fields[0]), fields[1]
+	default:
+		return "", "", fmt.Errorf("enter an HTTP method and URL, e.g. GET https://api.example.com/resource")
+	}
+	if _, err := dpopHTU(target); err != nil {
+		return "", "", err
+	}
+	return method, target, nil
+}
+
+// dpopThumbprints returns the key thumbprint the access token is bound to
+// (cnf.jkt) and the one of the session DPoP key used to request it
+func (r TokenResult) dpopThumbprints() (bound, presented string) {
+	if claims, err := decodeJWTClaims(r.Token.AccessToken); err == nil {
+		cnf, _ := claims["cnf"].(map[string]any)
+		bound, _ = cnf["jkt"].(string)
+	}
+	if r.creds.dpop != nil {
+		presented = r.creds.dpop.thumbprint()
+	}
+	return bound, presented
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestDPoPProof(t *testing.T) {
+	k, err := newDPoPKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.setNonce("https://api.example.com/other", "server-nonce")
+
+	proof, err := k.proof("post", "https://api.example.com/orders?page=2#top", "access-tok")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	header, claims := verifyTestJWT(t, proof, k.key.Public())
+
+	if header["typ"] != "dpop+jwt" || header["alg"] != "ES256" {
+		t.Errorf("unexpected header: %v", header)
+	}
+	jwk, _ := header["jwk"].(map[string]any)
+	if jwk["kty"] != "EC" || jwk["crv"] != "P-256" || jwk["x"] == "" || jwk["d"] != nil {
+		t.Errorf("expected public EC JWK in header, got %v", jwk)
+	}
+	if claims["htm"] != "POST" || claims["htu"] != "https://api.example.com/orders" {
+		t.Errorf("unexpected htm/htu: %v %v", claims["htm"], claims["htu"])
+	}
+	sum := sha256.Sum256([]byte("access-tok"))
+	if claims["ath"] != base64.RawURLEncoding.EncodeToString(sum[:]) {
+		t.Errorf("unexpected ath: %v", claims["ath"])
+	}
+	if claims["nonce"] != "server-nonce" {
+		t.Errorf("expected nonce for the same origin, got %v", claims["nonce"])
+	}
+	if claims["jti"] == "" || claims["iat"] == nil {
+		t.Errorf("expected jti and iat, got %v", claims)
+	}
+}
+
+func TestDPoPThumbprint(t *testing.T) {
+	k, err := newDPoPKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// encoding/json sorts map keys, which matches the RFC 7638 canonical form
+	canonical, _ := json.Marshal(k.jwk)
+	sum := sha256.Sum256(canonical)
+	if got, want := k.thumbprint(), base64.RawURLEncoding.EncodeToString(sum[:]); got != want {
+		t.Errorf("expected thumbprint %s, got %s", want, got)
+	}
+}
+
+func TestDPoPNonceRetry(t *testing.T) {
+	k, err := newDPoPKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var nonces []any
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proof := r.Header.Get("DPoP")
+		if proof == "" {
+			t.Error("expected DPoP header on token request")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_, claims := verifyTestJWT(t, proof, k.key.Public())
+		mu.Lock()
+		nonces = append(nonces, claims["nonce"])
+		mu.Unlock()
+
+		w.Header().Set("DPoP-Nonce", "n-1")
+		if claims["nonce"] != "n-1" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"use_dpop_nonce","error_description":"Authorization server requires nonce in DPoP proof"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(TokenResponse{AccessToken: "dpop-token", TokenType: "DPoP"})
+	}))
+	defer server.Close()
+	useTLSServer(t, server)
+
+	creds := clientCredentials{ClientID: "id", ClientSecret: "secret", dpop: k}
+	token, err := requestClientCredentials(server.URL+"/token", creds, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if token.TokenType != "DPoP" {
+		t.Errorf("expected DPoP token type, got %q", token.TokenType)
+	}
+	if len(nonces) != 2 || nonces[0] != nil || nonces[1] != "n-1" {
+		t.Errorf("expected a retry with the server nonce, got %v", nonces)
+	}
+
+	// The nonce is remembered for the next request
+	if _, err := requestClientCredentials(server.URL+"/token", creds, ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(nonces) != 3 {
+		t.Errorf("expected no retry once the nonce is known, got %v", nonces)
+	}
+}
+
+func TestDPoPOtherErrorsAreKept(t *testing.T) {
+	k, _ := newDPoPKey()
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("DPoP-Nonce", "n-1")
+		writeOAuthError(w, "invalid_client")
+	}))
+	defer server.Close()
+	useTLSServer(t, server)
+
+	_, err := requestClientCredentials(server.URL+"/token", clientCredentials{ClientID: "id", dpop: k}, "")
+	oauthErr, ok := err.(*OAuthError)
+	if !ok || oauthErr.Code != "invalid_client" {
+		t.Errorf("expected invalid_client OAuthError, got %v", err)
+	}
+}
+
+func TestParseProofTarget(t *testing.T) {
+	tests := []struct {
+		input      string
+		wantMethod string
+		wantTarget string
+		wantErr    bool
+	}{
+		{"GET https://api.example.com/a", "GET", "https://api.example.com/a", false},
+		{"  post   https://api.example.com/a?x=1 ", "POST", "https://api.example.com/a?x=1", false},
+		{"https://api.example.com/a", "GET", "https://api.example.com/a", false},
+		{"GET /relative", "", "", true},
+		{"", "", "", true},
+		{"GET https://a b", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			method, target, err := parseProofTarget(tt.input)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if method != tt.wantMethod || target != tt.wantTarget {
+				t.Errorf("expected %s %s, got %s %s", tt.wantMethod, tt.wantTarget, method, target)
+			}
+		})
+	}
+}
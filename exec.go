@@ -0,0 +1,219 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"regexp"
+	"strings"
+	"syscall"
+)
+
+// defaultExecVar is the variable a single client's token is exported as
+const defaultExecVar = "TKZ_TOKEN"
+
+var envVarName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// execBinding maps a client to the variables its token is exported as
+type execBinding struct {
+	client    string
+	tokenVar  string
+	headerVar string // Optional; holds the Authorization header value
+}
+
+// repeatedFlag collects every value of a flag given more than once
+type repeatedFlag []string
+
+func (f *repeatedFlag) String() string { return strings.Join(*f, ",") }
+
+func (f *repeatedFlag) Set(v string) error {
+	*f = append(*f, v)
+	return nil
+}
+
+// runExecCmd implements `tkz exec <client> -- <command> [args...]`: it runs
+// the command with fresh tokens in its environment and exits with its code.
+func runExecCmd(args []string, session string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("exec", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var clientFlags, headerFlags repeatedFlag
+	fs.Var(&clientFlags, "client", "client to fetch a token for, as name or name=VAR (repeatable)")
+	tokenVar := fs.String("var", defaultExecVar, "variable for the token of a single client")
+	fs.Var(&headerFlags, "header-var", "variable for the Authorization header value, as VAR or name=VAR (repeatable)")
+	force := fs.Bool("force", false, "fetch new tokens even if cached ones are fresh")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: tkz exec <client> [--var NAME] [--header-var NAME] -- <command> [args...]")
+		fmt.Fprintln(stderr, "       tkz exec --client <name>[=VAR] [--client ...] [--header-var <name>=VAR] -- <command> [args...]")
+		fmt.Fprintln(stderr)
+		fmt.Fprintln(stderr, "Run a command with access tokens in its environment (default "+defaultExecVar+").")
+		fmt.Fprintln(stderr, "tkz exits with the command's exit code.")
+		fmt.Fprintln(stderr)
+		fs.PrintDefaults()
+	}
+
+	// Everything after -- belongs to the command, flags included
+	split := -1
+	for i, a := range args {
+		if a == "--" {
+			split = i
+			break
+		}
+	}
+	if split < 0 {
+		if len(args) == 1 && (args[0] == "-h" || args[0] == "--help") {
+			fs.Usage()
+			return exitOK
+		}
+		fmt.Fprintln(stderr, "tkz: missing -- before the command")
+		fs.Usage()
+		return exitUsage
+	}
+	command := args[split+1:]
+
+	positional, err := parseArgs(fs, args[:split])
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if len(command) == 0 {
+		fmt.Fprintln(stderr, "tkz: no command given")
+		return exitUsage
+	}
+
+	bindings, err := execBindings(positional, clientFlags, *tokenVar, headerFlags)
+	if err != nil {
+		fmt.Fprintf(stderr, "tkz: %v\n", err)
+		return exitUsage
+	}
+
+	env := os.Environ()
+	for _, b := range bindings {
+		result, err := headlessToken(b.client, session, *force, stderr)
+		if err != nil {
+			fmt.Fprintf(stderr, "tkz: %s: %v\n", b.client, err)
+			return exitCodeFor(err)
+		}
+		env = append(env, b.tokenVar+"="+result.Token.AccessToken)
+		if b.headerVar != "" {
+			env = append(env, b.headerVar+"="+result.AuthorizationHeader())
+		}
+	}
+
+	return runChild(command, env, stdin, stdout, stderr)
+}
+
+// execBindings combines the positional client and --client flags with the
+// variable flags, rejecting invalid or clashing variable names
+func execBindings(positional, clientFlags []string, tokenVar string, headerFlags []string) ([]execBinding, error) {
+	var bindings []execBinding
+	for _, name := range positional {
+		bindings = append(bindings, execBinding{client: name, tokenVar: tokenVar})
+	}
+	for _, f := range clientFlags {
+		name, v, ok := strings.Cut(f, "=")
+		if !ok {
+			v = tokenVar
+		}
+		bindings = append(bindings, execBinding{client: name, tokenVar: v})
+	}
+	if len(bindings) == 0 {
+		return nil, errors.New("no client given")
+	}
+
+	for _, f := range headerFlags {
+		name, v, ok := strings.Cut(f, "=")
+		if !ok {
+			if len(bindings) > 1 {
+				return nil, fmt.Errorf("--header-var %s: use <client>=%s with several clients", f, f)
+			}
+			name, v = bindings[0].client, f
+		}
+		found := false
+		for i := range bindings {
+			if bindings[i].client == name {
+				bindings[i].headerVar = v
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("--header-var %s: client %q is not among the --client flags", f, name)
+		}
+	}
+
+	seen := map[string]string{}
+	for _, b := range bindings {
+		for _, v := range []string{b.tokenVar, b.headerVar} {
+			if v == "" {
+				continue
+			}
+			if !envVarName.MatchString(v) {
+				return nil, fmt.Errorf("invalid variable name %q", v)
+			}
+			if other, ok := seen[v]; ok {
+				return nil, fmt.Errorf("variable %s is used for both %s and %s (map clients with --client <name>=VAR)", v, other, b.client)
+			}
+			seen[v] = b.client
+		}
+	}
+	return bindings, nil
+}
+
+// runChild runs the command, forwarding SIGTERM and SIGHUP sent to tkz, and
+// returns its exit code. A command killed by a signal yields 128+signal like
+// a shell; one that cannot be started yields 127.
+func runChild(command []string, env []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Env = env
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	// The terminal sends Ctrl-C and Ctrl-\ to the child itself, as it shares
+	// our process group; a second copy makes many CLIs quit without cleaning
+	// up. tkz only has to outlive them. Not signal.Ignore: the child would
+	// inherit it.
+	terminal := make(chan os.Signal, 1)
+	signal.Notify(terminal, os.Interrupt, syscall.SIGQUIT)
+	defer signal.Stop(terminal)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintf(stderr, "tkz: %v\n", err)
+		return 127
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case sig := <-signals:
+				cmd.Process.Signal(sig)
+			case <-terminal:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	err := cmd.Wait()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			return 128 + int(status.Signal())
+		}
+		return exitErr.ExitCode()
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "tkz: %v\n", err)
+		return exitError
+	}
+	return exitOK
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useExecTokens serves tokens for the given clients from a test agent
+func useExecTokens(t *testing.T, tokens map[string]string) {
+	t.Helper()
+	var clients []Client
+	for name := range tokens {
+		clients = append(clients, Client{Name: name, ItemID: "item", Issuer: "https://auth.example.com"})
+	}
+	useConfigHome(t, clients)
+	a := startTestAgent(t, "", 0)
+	for _, c := range clients {
+		seedAgent(a, TokenResult{Token: TokenResponse{AccessToken: tokens[c.Name], TokenType: "Bearer", ExpiresIn: 300}, Client: c, FetchedAt: time.Now()})
+	}
+}
+
+func TestRunExecCmd(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+	useExecTokens(t, map[string]string{"kc-dev": "tok-dev", "kc-admin": "tok-admin"})
+
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"default variable", []string{"kc-dev", "--", "sh", "-c", `printf %s "$TKZ_TOKEN"`}, "tok-dev"},
+		{"custom variable", []string{"kc-dev", "--var", "API_TOKEN", "--", "sh", "-c", `printf %s "$API_TOKEN"`}, "tok-dev"},
+		{"header variable", []string{"kc-dev", "--header-var", "AUTH", "--", "sh", "-c", `printf %s "$AUTH"`}, "Bearer tok-dev"},
+		{"several clients", []string{"--client", "kc-dev=DEV", "--client", "kc-admin=ADMIN", "--header-var", "kc-admin=ADMIN_AUTH", "--", "sh", "-c", `printf '%s %s %s' "$DEV" "$ADMIN" "$ADMIN_AUTH"`}, "tok-dev tok-admin Bearer tok-admin"},
+		{"command flags untouched", []string{"kc-dev", "--", "sh", "-c", `printf %s "$1"`, "sh", "--var"}, "--var"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			if code := runExecCmd(tt.args, "", strings.NewReader(""), &stdout, &stderr); code != exitOK {
+				t.Fatalf("expected exit 0, got %d: %s", code, stderr.String())
+			}
+			if stdout.String() != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, stdout.String())
+			}
+		})
+	}
+}
+
+func TestRunExecCmdExitCode(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+	useExecTokens(t, map[string]string{"kc-dev": "tok-dev"})
+
+	tests := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{"exit code", []string{"kc-dev", "--", "sh", "-c", "exit 7"}, 7},
+		{"killed by signal", []string{"kc-dev", "--", "sh", "-c", "kill -TERM $$"}, 128 + 15},
+		// The child signals tkz, which forwards the signal back to it
+		{"forwards signals", []string{"kc-dev", "--", "sh", "-c", `trap "exit 42" TERM; kill -TERM $PPID; while :; do sleep 0.1; done`}, 42},
+		// The terminal already delivers Ctrl-C to the child; tkz must not repeat it
+		{"keeps SIGINT to itself", []string{"kc-dev", "--", "sh", "-c", `trap "exit 43" INT; kill -INT $PPID; sleep 0.3; exit 0`}, 0},
+		{"command not found", []string{"kc-dev", "--", "tkz-no-such-command"}, 127},
+		{"unknown client", []string{"missing", "--", "sh", "-c", "exit 0"}, exitClientNotFound},
+		{"missing separator", []string{"kc-dev", "sh"}, exitUsage},
+		{"no command", []string{"kc-dev", "--"}, exitUsage},
+		{"no client", []string{"--", "sh"}, exitUsage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			if code := runExecCmd(tt.args, "", strings.NewReader(""), &stdout, &stderr); code != tt.want {
+				t.Errorf("expected exit %d, got %d: %s", tt.want, code, stderr.String())
+			}
+		})
+	}
+}
+
+func TestExecBindings(t *testing.T) {
+	tests := []struct {
+		name       string
+		positional []string
+		clients    []string
+		header     []string
+		want       []execBinding
+		wantErr    string
+	}{
+		{
+			name:       "positional client",
+			positional: []string{"kc-dev"},
+			want:       []execBinding{{client: "kc-dev", tokenVar: "TKZ_TOKEN"}},
+		},
+		{
+			name:       "header for the only client",
+			positional: []string{"kc-dev"},
+			header:     []string{"AUTH"},
+			want:       []execBinding{{client: "kc-dev", tokenVar: "TKZ_TOKEN", headerVar: "AUTH"}},
+		},
+		{
+			name:    "mapped clients",
+			clients: []string{"a=A", "b=B"},
+			header:  []string{"b=B_AUTH"},
+			want:    []execBinding{{client: "a", tokenVar: "A"}, {client: "b", tokenVar: "B", headerVar: "B_AUTH"}},
+		},
+		{name: "no client", wantErr: "no client"},
+		{name: "clashing variables", clients: []string{"a", "b"}, wantErr: "used for both"},
+		{name: "ambiguous header", clients: []string{"a=A", "b=B"}, header: []string{"AUTH"}, wantErr: "use <client>=AUTH"},
+		{name: "header for unknown client", clients: []string{"a=A"}, header: []string{"b=AUTH"}, wantErr: "not among"},
+		{name: "invalid name", clients: []string{"a=1TOKEN"}, wantErr: "invalid variable name"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := execBindings(tt.positional, tt.clients, defaultExecVar, tt.header)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("expected %d bindings, got %+v", len(tt.want), got)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("binding %d: expected %+v, got %+v", i, tt.want[i], got[i])
+				}
+			}
+		})
+	}
+}
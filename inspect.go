@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Token formats told apart by tokenFormat
+const (
+	formatJWT    = "JWT"
+	formatJWE    = "JWE"
+	formatOpaque = "opaque"
+)
+
+// highlightedClaims are the claims worth spotting first when debugging
+// authorization: who issued the token, for whom, and what it grants
+var highlightedClaims = map[string]bool{
+	"iss":          true,
+	"aud":          true,
+	"sub":          true,
+	"scope":        true,
+	"scp":          true,
+	"azp":          true,
+	"roles":        true,
+	"realm_access": true,
+}
+
+// timeClaims are NumericDate claims shown as local times
+var timeClaims = []string{"iat", "nbf", "exp"}
+
+// tokenFormat tells JWTs (JWS compact form) from encrypted JWTs and opaque
+// tokens, which tkz cannot look into
+func tokenFormat(token string) string {
+	if _, err := decodeJWTClaims(token); err == nil {
+		return formatJWT
+	}
+	if strings.Count(token, ".") == 4 {
+		return formatJWE
+	}
+	return formatOpaque
+}
+
+// describeTokenFormat is the one-line format summary for the token view,
+// e.g. "JWT (RS256, kid abc)" or "opaque"
+func describeTokenFormat(token string) string {
+	switch format := tokenFormat(token); format {
+	case formatJWT:
+		header, err := decodeJWTHeader(token)
+		if err != nil {
+			return format
+		}
+		details := []string{}
+		if alg, ok := header["alg"].(string); ok {
+			details = append(details, alg)
+		}
+		if kid, ok := header["kid"].(string); ok {
+			details = append(details, "kid "+kid)
+		}
+		if len(details) == 0 {
+			return format
+		}
+		return format + " (" + strings.Join(details, ", ") + ")"
+	case formatJWE:
+		return "encrypted JWT (JWE)"
+	default:
+		return format
+	}
+}
+
+// inspectToken renders the decoded header and claims of a JWT, or explains
+// why a token cannot be decoded
+func inspectToken(token string, now time.Time) string {
+	var b strings.Builder
+	switch tokenFormat(token) {
+	case formatJWE:
+		b.WriteString(warningStyle.Render("Encrypted JWT (JWE)"))
+		b.WriteString("\n\nThe payload is encrypted for the resource server and cannot be decoded here.")
+		return b.String()
+	case formatOpaque:
+		b.WriteString(warningStyle.Render("Opaque token"))
+		b.WriteString(fmt.Sprintf("\n\nThis token is not a JWT (%d characters). Only the issuer can tell what it\ngrants, e.g. through token introspection.", len(token)))
+		return b.String()
+	}
+
+	header, _ := decodeJWTHeader(token)
+	claims, _ := decodeJWTClaims(token)
+
+	b.WriteString(accentStyle.Render("Header"))
+	b.WriteString("\n")
+	b.WriteString(prettyClaims(header, nil))
+	b.WriteString("\n\n")
+
+	b.WriteString(accentStyle.Render("Claims"))
+	b.WriteString("\n")
+	b.WriteString(prettyClaims(claims, highlightedClaims))
+
+	if times := claimTimes(claims, now); times != "" {
+		b.WriteString("\n\n")
+		b.WriteString(accentStyle.Render("Times"))
+		b.WriteString("\n")
+		b.WriteString(times)
+	}
+	return b.String()
+}
+
+// prettyClaims pretty-prints a JSON object, highlighting the lines of the
+// given top-level members
+func prettyClaims(v map[string]any, highlight map[string]bool) string {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err.Error()
+	}
+	lines := strings.Split(string(data), "\n")
+	inHighlight := false
+	for i, line := range lines {
+		// Top-level members start at exactly two spaces of indentation; deeper
+		// lines and the closing bracket belong to the member above
+		switch {
+		case strings.HasPrefix(line, `  "`):
+			key, _, _ := strings.Cut(strings.TrimPrefix(line, `  "`), `"`)
+			inHighlight = highlight[key]
+		case !strings.HasPrefix(line, "  "):
+			inHighlight = false
+		}
+		if inHighlight {
+			lines[i] = tokenStyle.Render(line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// claimTimes renders iat, nbf and exp as local times with how long ago or
+// from now they are
+func claimTimes(claims map[string]any, now time.Time) string {
+	var lines []string
+	for _, name := range timeClaims {
+		v, ok := claims[name].(float64)
+		if !ok {
+			continue
+		}
+		t := time.Unix(int64(v), 0)
+		rel := formatRelative(t, now)
+		switch {
+		case name == "exp" && !now.Before(t):
+			rel = errorStyle.Render("expired " + rel)
+		case name == "nbf" && now.Before(t):
+			rel = warningStyle.Render("not yet valid, " + rel)
+		default:
+			rel = dimStyle.Render(rel)
+		}
+		lines = append(lines, fmt.Sprintf("  %-4s %s  %s", name, t.Local().Format("2006-01-02 15:04:05 MST"), rel))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// formatRelative renders t relative to now, like "in 4m12s" or "2d3h ago"
+func formatRelative(t, now time.Time) string {
+	d := t.Sub(now)
+	if d >= 0 {
+		return "in " + formatAge(d)
+	}
+	return formatAge(-d) + " ago"
+}
+
+// formatAge renders a duration in seconds, or in days and hours past a day
+func formatAge(d time.Duration) string {
+	if d >= 24*time.Hour {
+		days := d / (24 * time.Hour)
+		return fmt.Sprintf("%dd%dh", days, (d-days*24*time.Hour)/time.Hour)
+	}
+	return formatRemaining(d)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"strings"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// newTestJWT signs claims with a throwaway ES256 key
+func newTestJWT(t *testing.T, claims map[string]any) string {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := signJWT(key, "ES256", map[string]any{"kid": "key-1", "typ": "at+jwt"}, claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestTokenFormat(t *testing.T) {
+	jwt := newTestJWT(t, map[string]any{"sub": "alice"})
+	tests := []struct {
+		name     string
+		token    string
+		format   string
+		describe string
+	}{
+		{"jwt", jwt, formatJWT, "JWT (ES256, kid key-1)"},
+		{"jwe", "eyJhbGciOiJSU0EtT0FFUCJ9.a.b.c.d", formatJWE, "encrypted JWT (JWE)"},
+		{"opaque", "2YotnFZFEjr1zCsicMWpAA", formatOpaque, "opaque"},
+		{"three parts but not JSON", "abc.def.ghi", formatOpaque, "opaque"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tokenFormat(tt.token); got != tt.format {
+				t.Errorf("tokenFormat = %q, want %q", got, tt.format)
+			}
+			if got := describeTokenFormat(tt.token); got != tt.describe {
+				t.Errorf("describeTokenFormat = %q, want %q", got, tt.describe)
+			}
+		})
+	}
+}
+
+func TestInspectToken(t *testing.T) {
+	now := time.Now()
+	token := newTestJWT(t, map[string]any{
+		"iss":          "https://auth.example.com",
+		"aud":          []string{"api", "billing"},
+		"sub":          "alice",
+		"realm_access": map[string]any{"roles": []string{"admin"}},
+		"iat":          now.Add(-5 * time.Minute).Unix(),
+		"nbf":          now.Add(-5 * time.Minute).Unix(),
+		"exp":          now.Add(-time.Minute).Unix(),
+	})
+
+	out := inspectToken(token, now)
+	for _, want := range []string{
+		`"alg": "ES256"`,
+		`"typ": "at+jwt"`,
+		`"iss": "https://auth.example.com"`,
+		`"billing"`,
+		`"admin"`,
+		"iat",
+		"5m0s ago",
+		"expired 1m0s ago",
+		now.Add(-time.Minute).Local().Format("2006-01-02 15:04:05"),
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in inspection:\n%s", want, out)
+		}
+	}
+
+	if out := inspectToken("2YotnFZFEjr1zCsicMWpAA", now); !strings.Contains(out, "Opaque token") {
+		t.Errorf("expected opaque label, got %q", out)
+	}
+}
+
+func TestFormatRelative(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		at   time.Time
+		want string
+	}{
+		{now.Add(4*time.Minute + 12*time.Second), "in 4m12s"},
+		{now.Add(-90 * time.Second), "1m30s ago"},
+		{now.Add(-(51 * time.Hour)), "2d3h ago"},
+		{now, "in 0s"},
+	}
+	for _, tt := range tests {
+		if got := formatRelative(tt.at, now); got != tt.want {
+			t.Errorf("formatRelative = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestInspectKey(t *testing.T) {
+	m := initialModel("")
+	m.mode = tokenView
+	m.tokenResult = &TokenResult{Token: TokenResponse{AccessToken: newTestJWT(t, map[string]any{"sub": "alice"})}}
+
+	result, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'j'}})
+	m = result.(model)
+	if m.mode != inspectView {
+		t.Fatalf("expected inspectView, got %v", m.mode)
+	}
+	if !strings.Contains(m.View(), `"sub": "alice"`) {
+		t.Error("expected decoded claims in the inspection view")
+	}
+
+	result, _ = m.Update(tea.KeyMsg{Type: tea.KeyEsc})
+	if result.(model).mode != tokenView {
+		t.Error("expected esc to return to the token view")
+	}
+}
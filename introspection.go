@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Token type hints for introspection and revocation (RFC 7662 §2.1, RFC 7009 §2.1)
+const (
+	hintAccessToken  = "access_token"
+	hintRefreshToken = "refresh_token"
+)
+
+// introspectionFields are the members shown first, in this order; anything
+// else the server returns is listed as an extension claim
+var introspectionFields = []struct{ name, label string }{
+	{"scope", "Scope"},
+	{"client_id", "Client ID"},
+	{"sub", "Subject"},
+}
+
+// clientEndpoints resolves what a follow-up call about a token needs: the
+// discovery document and settled client credentials. Tokens fetched in this
+// process reuse their credentials; cached ones resolve them again.
+func clientEndpoints(session string, result TokenResult) (clientCredentials, *OIDCConfig, error) {
+	creds := result.creds
+	if creds.ClientID == "" {
+		var err error
+		if creds, err = resolveClientCredentials(session, result.Client); err != nil {
+			return creds, nil, err
+		}
+	}
+	oidc, err := DiscoverOIDC(result.Client.Issuer)
+	if err != nil {
+		return creds, nil, stageErr(stageDiscovery, "oidc discovery: %w", err)
+	}
+	return settleCredentials(result.Client, creds, oidc)
+}
+
+// introspectToken asks the authorization server about a token (RFC 7662)
+// and returns the response members as they are
+func introspectToken(endpoint string, creds clientCredentials, token, hint string) (map[string]any, error) {
+	if !strings.HasPrefix(endpoint, "https://") {
+		return nil, fmt.Errorf("introspection endpoint must use HTTPS: %s", endpoint)
+	}
+	data := url.Values{"token": {token}}
+	if hint != "" {
+		data.Set("token_type_hint", hint)
+	}
+
+	resp, err := postAuthenticatedForm(endpoint, creds, data)
+	if err != nil {
+		return nil, fmt.Errorf("introspection request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read introspection response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("introspection endpoint returned status %d: %s", resp.StatusCode, body)
+	}
+
+	var claims map[string]any
+	if err := json.Unmarshal(body, &claims); err != nil {
+		return nil, fmt.Errorf("failed to parse introspection response: %w", err)
+	}
+	if _, ok := claims["active"].(bool); !ok {
+		return nil, fmt.Errorf("introspection response missing active")
+	}
+	return claims, nil
+}
+
+// introspect runs an introspection for the client the token belongs to
+func introspect(session string, result TokenResult, token, hint string) (map[string]any, error) {
+	creds, oidc, err := clientEndpoints(session, result)
+	if err != nil {
+		return nil, err
+	}
+	if oidc.IntrospectionEndpoint == "" {
+		return nil, stageErr(stageDiscovery, "oidc discovery: response missing introspection_endpoint")
+	}
+	claims, err := introspectToken(oidc.IntrospectionEndpoint, creds, token, hint)
+	if err != nil {
+		return nil, stageErr(stageToken, "%w", err)
+	}
+	return claims, nil
+}
+
+// formatIntrospection renders an introspection response: whether the token
+// is active, the standard members, its times and any extension claims
+func formatIntrospection(claims map[string]any, now time.Time) string {
+	var b strings.Builder
+	if active, _ := claims["active"].(bool); active {
+		b.WriteString(successStyle.Render("✓ active"))
+	} else {
+		b.WriteString(errorStyle.Render("✗ inactive"))
+		b.WriteString(dimStyle.Render(" (expired, revoked, or not issued by this server)"))
+	}
+	b.WriteString("\n")
+
+	shown := map[string]bool{"active": true}
+	for _, f := range introspectionFields {
+		shown[f.name] = true
+		if v, ok := claims[f.name]; ok {
+			b.WriteString(fmt.Sprintf("\n%s %v", accentStyle.Render(fmt.Sprintf("%-10s", f.label+":")), v))
+		}
+	}
+
+	if times := claimTimes(claims, now); times != "" {
+		b.WriteString("\n\n")
+		b.WriteString(accentStyle.Render("Times"))
+		b.WriteString("\n")
+		b.WriteString(times)
+	}
+	for _, name := range timeClaims {
+		shown[name] = true
+	}
+
+	other := map[string]any{}
+	for k, v := range claims {
+		if !shown[k] {
+			other[k] = v
+		}
+	}
+	if len(other) > 0 {
+		b.WriteString("\n\n")
+		b.WriteString(accentStyle.Render("Other claims"))
+		b.WriteString("\n")
+		b.WriteString(prettyClaims(other, highlightedClaims))
+	}
+	return b.String()
+}
+
+// runIntrospectCmd implements `tkz introspect <client>`: it reads a token
+// from stdin and asks the client's authorization server about it
+func runIntrospectCmd(args []string, session string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("introspect", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	output := fs.String("output", "text", "output format: text, json")
+	fs.StringVar(output, "o", "text", "shorthand for --output")
+	hint := fs.String("hint", "", "token_type_hint: access_token or refresh_token")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: tkz introspect <client> [--hint access_token|refresh_token] [--output text|json] < token")
+		fmt.Fprintln(stderr)
+		fmt.Fprintln(stderr, "Introspect a token from stdin (raw, or the JSON from `tkz token -o json`)")
+		fmt.Fprintln(stderr, "with the named client's credentials. Exits 7 if the token is not active.")
+		fmt.Fprintln(stderr)
+		fs.PrintDefaults()
+	}
+
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if len(positional) != 1 {
+		fs.Usage()
+		return exitUsage
+	}
+	if *output != "text" && *output != "json" {
+		fmt.Fprintf(stderr, "tkz: unknown output format %q (use text or json)\n", *output)
+		return exitUsage
+	}
+	if *hint != "" && *hint != hintAccessToken && *hint != hintRefreshToken {
+		fmt.Fprintf(stderr, "tkz: unknown token type hint %q (use %s or %s)\n", *hint, hintAccessToken, hintRefreshToken)
+		return exitUsage
+	}
+
+	data, err := io.ReadAll(stdin)
+	if err != nil {
+		fmt.Fprintf(stderr, "tkz: read stdin: %v\n", err)
+		return exitError
+	}
+	token := parseTokenInput(data)
+	if token == "" {
+		fmt.Fprintln(stderr, "tkz: no token given on stdin")
+		return exitUsage
+	}
+
+	clients, err := loadClients()
+	if err != nil {
+		fmt.Fprintf(stderr, "tkz: load clients: %v\n", err)
+		return exitError
+	}
+	client, err := findClient(clients, positional[0])
+	if err == nil && client.needsProvider() {
+		err = requireProvider(session, client)
+	}
+	var claims map[string]any
+	if err == nil {
+		claims, err = introspect(session, TokenResult{Client: client}, token, *hint)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "tkz: %v\n", err)
+		return exitCodeFor(err)
+	}
+
+	if *output == "json" {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(claims); err != nil {
+			fmt.Fprintf(stderr, "tkz: %v\n", err)
+			return exitError
+		}
+	} else {
+		fmt.Fprintln(stdout, formatIntrospection(claims, time.Now()))
+	}
+	if active, _ := claims["active"].(bool); !active {
+		return exitTokenInvalid
+	}
+	return exitOK
+}
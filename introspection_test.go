@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// newFakeEndpointIssuer serves discovery advertising one endpoint besides
+// the token endpoint (e.g. "introspection_endpoint"), handled by handler
+func newFakeEndpointIssuer(t *testing.T, member string, handler http.HandlerFunc) *httptest.Server {
+	t.Helper()
+	var server *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":         server.URL,
+			"token_endpoint": server.URL + "/token",
+			member:           server.URL + "/endpoint",
+		})
+	})
+	mux.HandleFunc("/endpoint", handler)
+	server = httptest.NewTLSServer(mux)
+	t.Cleanup(server.Close)
+	useTLSServer(t, server)
+	return server
+}
+
+// introspectionHandler answers for "tok" as active and any other token as
+// inactive, requiring client_secret_basic credentials
+func introspectionHandler(t *testing.T) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if id, secret, ok := r.BasicAuth(); !ok || id != "my-client" || secret != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			t.Fatal(err)
+		}
+		if hint := r.PostForm.Get("token_type_hint"); hint != "" && hint != hintAccessToken {
+			t.Errorf("unexpected token_type_hint %q", hint)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if r.PostForm.Get("token") != "tok" {
+			json.NewEncoder(w).Encode(map[string]any{"active": false})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"active":    true,
+			"scope":     "orders:read",
+			"client_id": "my-client",
+			"sub":       "alice",
+			"exp":       time.Now().Add(time.Hour).Unix(),
+			"tenant":    "acme",
+		})
+	}
+}
+
+func testIntrospectionResult(issuer string) TokenResult {
+	return TokenResult{
+		Token:  TokenResponse{AccessToken: "tok"},
+		Client: Client{Name: "api", Issuer: issuer},
+		creds:  clientCredentials{ClientID: "my-client", ClientSecret: "s3cret", authMethod: authClientSecretBasic},
+	}
+}
+
+func TestIntrospect(t *testing.T) {
+	server := newFakeEndpointIssuer(t, "introspection_endpoint", introspectionHandler(t))
+	result := testIntrospectionResult(server.URL)
+
+	claims, err := introspect("", result, "tok", hintAccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims["active"] != true || claims["sub"] != "alice" || claims["tenant"] != "acme" {
+		t.Errorf("unexpected claims %v", claims)
+	}
+
+	claims, err = introspect("", result, "other", "")
+	if err != nil || claims["active"] != false {
+		t.Errorf("expected an inactive token, got %v, %v", claims, err)
+	}
+
+	result.creds.ClientSecret = "wrong"
+	if _, err := introspect("", result, "tok", ""); exitCodeFor(err) != exitTokenRejected {
+		t.Errorf("expected rejected credentials to map to exit %d, got %v", exitTokenRejected, err)
+	}
+}
+
+func TestIntrospectErrors(t *testing.T) {
+	server := newFakeIssuer(t, func(w http.ResponseWriter, r *http.Request) {})
+	if _, err := introspect("", testIntrospectionResult(server.URL), "tok", ""); err == nil || !strings.Contains(err.Error(), "introspection_endpoint") {
+		t.Errorf("expected a missing endpoint error, got %v", err)
+	}
+
+	server = newFakeEndpointIssuer(t, "introspection_endpoint", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"sub":"alice"}`))
+	})
+	if _, err := introspect("", testIntrospectionResult(server.URL), "tok", ""); err == nil || !strings.Contains(err.Error(), "missing active") {
+		t.Errorf("expected a malformed response error, got %v", err)
+	}
+
+	if _, err := introspectToken("http://auth.example.com/introspect", clientCredentials{}, "tok", ""); err == nil {
+		t.Error("expected plain HTTP to be refused")
+	}
+}
+
+func TestFormatIntrospection(t *testing.T) {
+	now := time.Now()
+	out := formatIntrospection(map[string]any{
+		"active":    true,
+		"scope":     "orders:read",
+		"client_id": "my-client",
+		"exp":       float64(now.Add(time.Hour).Unix()),
+		"tenant":    "acme",
+	}, now)
+	for _, want := range []string{"✓ active", "Scope:", "orders:read", "Client ID:", "in 59m", "Other claims", `"tenant": "acme"`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, `"exp"`) {
+		t.Error("expected exp under Times, not among the other claims")
+	}
+
+	if out := formatIntrospection(map[string]any{"active": false}, now); !strings.Contains(out, "✗ inactive") || strings.Contains(out, "Other claims") {
+		t.Errorf("unexpected inactive rendering:\n%s", out)
+	}
+}
+
+func TestIntrospectKey(t *testing.T) {
+	server := newFakeEndpointIssuer(t, "introspection_endpoint", introspectionHandler(t))
+	result := testIntrospectionResult(server.URL)
+
+	m := initialModel("")
+	m.mode = tokenView
+	m.tokenResult = &result
+
+	updated, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'i'}})
+	m = updated.(model)
+	if cmd == nil || !strings.Contains(m.View(), "Introspecting") {
+		t.Fatal("expected an introspection to start")
+	}
+
+	updated, _ = m.Update(cmd())
+	m = updated.(model)
+	if m.mode != inspectView {
+		t.Fatalf("expected inspectView, got %v", m.mode)
+	}
+	if view := m.View(); !strings.Contains(view, "Introspection") || !strings.Contains(view, "✓ active") {
+		t.Errorf("expected the introspection response in the view:\n%s", view)
+	}
+
+	updated, _ = m.Update(tea.KeyMsg{Type: tea.KeyEsc})
+	if updated.(model).mode != tokenView {
+		t.Error("expected esc to return to the token view")
+	}
+}
+
+func TestIntrospectKeyNeedsProviderForCachedTokens(t *testing.T) {
+	m := initialModel("")
+	m.mode = tokenView
+	m.tokenResult = &TokenResult{Token: TokenResponse{AccessToken: "tok"}, Client: Client{Name: "api"}}
+
+	updated, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'i'}})
+	if m = updated.(model); m.pendingAction != "introspect" {
+		t.Errorf("expected the introspection to wait for the vault, got %q", m.pendingAction)
+	}
+}
+
+func TestRunIntrospectCmd(t *testing.T) {
+	useConfigHome(t, []Client{{Name: "api", Issuer: "https://auth.example.com"}})
+	tests := []struct {
+		name  string
+		args  []string
+		stdin string
+		want  int
+	}{
+		{"no client", nil, "tok", exitUsage},
+		{"bad output", []string{"api", "-o", "yaml"}, "tok", exitUsage},
+		{"bad hint", []string{"api", "--hint", "id_token"}, "tok", exitUsage},
+		{"empty stdin", []string{"api"}, "", exitUsage},
+		{"unknown client", []string{"nope"}, "tok", exitClientNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			if code := runIntrospectCmd(tt.args, "", strings.NewReader(tt.stdin), &stdout, &stderr); code != tt.want {
+				t.Errorf("expected exit %d, got %d: %s", tt.want, code, stderr.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,539 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Signature verdicts of verifyJWT
+const (
+	sigValid      = "valid"
+	sigInvalid    = "invalid"
+	sigUnknownKid = "unknown kid"
+	sigUnverified = "not verified" // The keys could not be fetched or used
+)
+
+// jwk is a public JSON Web Key (RFC 7517) as published in a JWKS
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// jwkSet is the document served at an issuer's jwks_uri
+type jwkSet struct {
+	Keys []jwk `json:"keys"`
+}
+
+// fetchJWKS downloads the issuer's signing keys
+func fetchJWKS(uri string) (*jwkSet, error) {
+	if !strings.HasPrefix(uri, "https://") {
+		return nil, fmt.Errorf("jwks_uri must use HTTPS: %s", uri)
+	}
+	resp, err := httpClient.Get(uri)
+	if err != nil {
+		return nil, fmt.Errorf("JWKS request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("JWKS endpoint returned status %d", resp.StatusCode)
+	}
+	var set jwkSet
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("failed to parse JWKS: %w", err)
+	}
+	return &set, nil
+}
+
+// candidates returns the signature keys that may have signed a token with
+// the given kid: the key with that kid, or every signing key if the token
+// names none
+func (s *jwkSet) candidates(kid string) []jwk {
+	var keys []jwk
+	for _, k := range s.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if kid == "" || k.Kid == kid {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// publicKey decodes an RSA, EC or Ed25519 (OKP) key
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeKeyParam("n", k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeKeyParam("e", k.E)
+		if err != nil {
+			return nil, err
+		}
+		exp := new(big.Int).SetBytes(e)
+		if !exp.IsInt64() || exp.Int64() < 2 || exp.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exp.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported EC curve %q", k.Crv)
+		}
+		x, err := decodeKeyParam("x", k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeKeyParam("y", k.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, fmt.Errorf("EC key is not on curve %s", k.Crv)
+		}
+		return key, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported OKP curve %q", k.Crv)
+		}
+		x, err := decodeKeyParam("x", k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid Ed25519 key length %d", len(x))
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+func decodeKeyParam(name, v string) ([]byte, error) {
+	if v == "" {
+		return nil, fmt.Errorf("key is missing %q", name)
+	}
+	b, err := base64.RawURLEncoding.DecodeString(v)
+	if err != nil {
+		return nil, fmt.Errorf("decode key parameter %q: %w", name, err)
+	}
+	return b, nil
+}
+
+// errBadSignature is returned by verifyJWS when the signature does not match
+var errBadSignature = errors.New("signature does not match")
+
+// verifyJWS checks the signature of a compact JWS with a public key.
+// Supported algorithms: RS256/384/512, PS256/384/512, ES256/384/512, EdDSA.
+func verifyJWS(token, alg string, key crypto.PublicKey) error {
+	i := strings.LastIndex(token, ".")
+	if i < 0 {
+		return fmt.Errorf("not a JWT")
+	}
+	input := []byte(token[:i])
+	sig, err := base64.RawURLEncoding.DecodeString(token[i+1:])
+	if err != nil {
+		return fmt.Errorf("decode JWT signature: %w", err)
+	}
+
+	if alg == "EdDSA" {
+		k, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return fmt.Errorf("%s requires an Ed25519 key", alg)
+		}
+		if !ed25519.Verify(k, input, sig) {
+			return errBadSignature
+		}
+		return nil
+	}
+
+	var hash crypto.Hash
+	switch alg[min(2, len(alg)):] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+	h := hash.New()
+	h.Write(input)
+	digest := h.Sum(nil)
+
+	switch alg[:2] {
+	case "RS", "PS":
+		k, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("%s requires an RSA key", alg)
+		}
+		if alg[:2] == "RS" {
+			err = rsa.VerifyPKCS1v15(k, hash, digest, sig)
+		} else {
+			err = rsa.VerifyPSS(k, hash, digest, sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		}
+		if err != nil {
+			return errBadSignature
+		}
+		return nil
+	case "ES":
+		k, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("%s requires an EC key", alg)
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if want := map[string]int{"256": 32, "384": 48, "512": 66}[alg[2:]]; size != want {
+			return fmt.Errorf("%s does not match curve %s", alg, k.Curve.Params().Name)
+		}
+		// JWS uses the fixed-size r||s encoding, not ASN.1
+		if len(sig) != 2*size {
+			return errBadSignature
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(k, digest, r, s) {
+			return errBadSignature
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+}
+
+// claimCheck is the outcome of comparing a claim with what was expected
+type claimCheck struct {
+	OK   bool
+	Got  string
+	Want string
+}
+
+// tokenVerification is what verifyJWT found out about a token
+type tokenVerification struct {
+	Signature string // One of the sig* verdicts
+	Alg       string
+	Kid       string
+	Err       error // Why the signature is invalid or was not verified
+
+	Issuer   *claimCheck // iss against the discovered issuer
+	Audience *claimCheck // aud against the expected audience; nil if none was given
+	Expiry   *claimCheck // exp against the current time; nil without exp
+}
+
+// Valid reports whether the signature and every claim check passed
+func (v tokenVerification) Valid() bool {
+	if v.Signature != sigValid {
+		return false
+	}
+	for _, c := range []*claimCheck{v.Issuer, v.Audience, v.Expiry} {
+		if c != nil && !c.OK {
+			return false
+		}
+	}
+	return true
+}
+
+// verifyJWT checks a token's signature against the issuer's JWKS and its
+// iss, aud and exp claims. The issuer's discovery document provides both
+// the keys and the expected iss. An empty audience skips the aud check.
+func verifyJWT(token, issuer, audience string, now time.Time) tokenVerification {
+	var v tokenVerification
+	header, err := decodeJWTHeader(token)
+	if err != nil {
+		v.Signature, v.Err = sigInvalid, err
+		return v
+	}
+	claims, err := decodeJWTClaims(token)
+	if err != nil {
+		v.Signature, v.Err = sigInvalid, err
+		return v
+	}
+	v.Alg, _ = header["alg"].(string)
+	v.Kid, _ = header["kid"].(string)
+	v.checkClaims(claims, issuer, audience, now)
+
+	if v.Alg == "" || v.Alg == "none" {
+		v.Signature, v.Err = sigInvalid, fmt.Errorf("token is not signed (alg %q)", v.Alg)
+		return v
+	}
+
+	config, err := DiscoverOIDC(issuer)
+	if err != nil {
+		v.Signature, v.Err = sigUnverified, err
+		return v
+	}
+	if config.Issuer != "" {
+		v.Issuer.Want = config.Issuer
+		v.Issuer.OK = v.Issuer.Got == config.Issuer
+	}
+	if config.JWKSURI == "" {
+		v.Signature, v.Err = sigUnverified, fmt.Errorf("discovery document has no jwks_uri")
+		return v
+	}
+	set, err := fetchJWKS(config.JWKSURI)
+	if err != nil {
+		v.Signature, v.Err = sigUnverified, err
+		return v
+	}
+
+	keys := set.candidates(v.Kid)
+	if len(keys) == 0 {
+		v.Signature = sigUnknownKid
+		if v.Kid == "" {
+			v.Err = fmt.Errorf("the JWKS has no signing keys")
+		} else {
+			v.Err = fmt.Errorf("no key with kid %q in the issuer's JWKS (%d keys)", v.Kid, len(set.Keys))
+		}
+		return v
+	}
+
+	// Without a kid every key is tried; the last error explains a failure.
+	// A key that cannot be used only leaves the token unverified, while a
+	// mismatching signature or algorithm makes it invalid.
+	v.Signature = sigUnverified
+	for _, k := range keys {
+		if k.Alg != "" && k.Alg != v.Alg {
+			v.Signature, v.Err = sigInvalid, fmt.Errorf("key %q is for %s, token uses %s", k.Kid, k.Alg, v.Alg)
+			continue
+		}
+		pub, err := k.publicKey()
+		if err != nil {
+			v.Err = fmt.Errorf("key %q: %w", k.Kid, err)
+			continue
+		}
+		if err := verifyJWS(token, v.Alg, pub); err != nil {
+			if errors.Is(err, errBadSignature) {
+				v.Signature = sigInvalid
+			}
+			v.Err = err
+			continue
+		}
+		v.Signature, v.Err = sigValid, nil
+		if v.Kid == "" {
+			v.Kid = k.Kid
+		}
+		return v
+	}
+	return v
+}
+
+// checkClaims fills in the iss, aud and exp checks. The issuer is compared
+// with the configured URL here; verifyJWT replaces it with the issuer from
+// the discovery document once that is known.
+func (v *tokenVerification) checkClaims(claims map[string]any, issuer, audience string, now time.Time) {
+	iss, _ := claims["iss"].(string)
+	v.Issuer = &claimCheck{Got: iss, Want: issuer, OK: strings.TrimRight(iss, "/") == strings.TrimRight(issuer, "/")}
+
+	if audience != "" {
+		auds := claimStrings(claims["aud"])
+		c := &claimCheck{Got: strings.Join(auds, ", "), Want: audience}
+		for _, a := range auds {
+			if a == audience {
+				c.OK = true
+			}
+		}
+		v.Audience = c
+	}
+
+	if exp, ok := claims["exp"].(float64); ok {
+		t := time.Unix(int64(exp), 0)
+		v.Expiry = &claimCheck{OK: now.Before(t), Got: formatRelative(t, now)}
+	}
+}
+
+// claimStrings reads a claim that may be a single string or an array of
+// strings, as aud is
+func claimStrings(v any) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []any:
+		var out []string
+		for _, s := range v {
+			if s, ok := s.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// signatureSummary describes the signature verdict in one line, e.g.
+// "valid (RS256, kid abc)" or "invalid: signature does not match"
+func (v tokenVerification) signatureSummary() string {
+	if v.Signature == sigValid {
+		details := []string{v.Alg}
+		if v.Kid != "" {
+			details = append(details, "kid "+v.Kid)
+		}
+		return v.Signature + " (" + strings.Join(details, ", ") + ")"
+	}
+	if v.Err != nil {
+		return v.Signature + ": " + v.Err.Error()
+	}
+	return v.Signature
+}
+
+// String describes a claim check, e.g. "api (expected billing)"
+func (c claimCheck) String() string {
+	got := c.Got
+	if got == "" {
+		got = "missing"
+	}
+	if c.OK || c.Want == "" {
+		return got
+	}
+	return got + " (expected " + c.Want + ")"
+}
+
+// runVerifyCmd implements `tkz verify`: it reads a token from stdin and
+// checks it against the issuer's JWKS
+func runVerifyCmd(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	clientName := fs.String("client", "", "take the issuer and expected audience from this client")
+	issuer := fs.String("issuer", "", "issuer URL (default: the client's)")
+	audience := fs.String("audience", "", "audience the token must be issued for")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: tkz verify (--client <name> | --issuer <url>) [--audience <aud>] < token")
+		fmt.Fprintln(stderr)
+		fmt.Fprintln(stderr, "Verify a JWT from stdin (raw, or the JSON from `tkz token -o json`)")
+		fmt.Fprintln(stderr, "against the issuer's JWKS and check its iss, aud and exp claims.")
+		fmt.Fprintln(stderr)
+		fs.PrintDefaults()
+	}
+
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if len(positional) != 0 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	data, err := io.ReadAll(stdin)
+	if err != nil {
+		fmt.Fprintf(stderr, "tkz: read stdin: %v\n", err)
+		return exitError
+	}
+	token := parseTokenInput(data)
+	if token == "" {
+		fmt.Fprintln(stderr, "tkz: no token given on stdin")
+		return exitUsage
+	}
+	if format := tokenFormat(token); format != formatJWT {
+		fmt.Fprintf(stderr, "tkz: cannot verify a token that is not a signed JWT (%s)\n", describeTokenFormat(token))
+		return exitUsage
+	}
+
+	if *clientName != "" {
+		clients, err := loadClients()
+		if err != nil {
+			fmt.Fprintf(stderr, "tkz: load clients: %v\n", err)
+			return exitError
+		}
+		client, err := findClient(clients, *clientName)
+		if err != nil {
+			fmt.Fprintf(stderr, "tkz: %v\n", err)
+			return exitCodeFor(err)
+		}
+		if *issuer == "" {
+			*issuer = client.Issuer
+		}
+		if *audience == "" {
+			*audience = client.Audience
+		}
+	}
+	if *issuer == "" {
+		// The token's own iss claim would let a forged token name the
+		// JWKS it is checked against
+		fmt.Fprintln(stderr, "tkz: no issuer to verify against (use --issuer or --client)")
+		return exitUsage
+	}
+
+	v := verifyJWT(token, *issuer, *audience, time.Now())
+	writeVerification(stdout, v)
+	switch {
+	case v.Valid():
+		return exitOK
+	case v.Signature == sigUnverified:
+		return exitDiscovery
+	default:
+		return exitTokenInvalid
+	}
+}
+
+// parseTokenInput extracts the access token from stdin: either the JSON
+// printed by `tkz token -o json` or the bare token
+func parseTokenInput(data []byte) string {
+	trimmed := strings.TrimSpace(string(data))
+	if strings.HasPrefix(trimmed, "{") {
+		var v struct {
+			AccessToken string `json:"access_token"`
+		}
+		if json.Unmarshal(data, &v) == nil {
+			return v.AccessToken
+		}
+	}
+	return trimmed
+}
+
+// writeVerification prints the outcome of verifyJWT, one check per line
+func writeVerification(w io.Writer, v tokenVerification) {
+	mark := func(ok bool) string {
+		if ok {
+			return "✓"
+		}
+		return "✗"
+	}
+	fmt.Fprintf(w, "%-10s %s %s\n", "Signature:", mark(v.Signature == sigValid), v.signatureSummary())
+	checks := []struct {
+		label string
+		check *claimCheck
+	}{
+		{"Issuer:", v.Issuer},
+		{"Audience:", v.Audience},
+		{"Expiry:", v.Expiry},
+	}
+	for _, c := range checks {
+		if c.check != nil {
+			fmt.Fprintf(w, "%-10s %s %s\n", c.label, mark(c.check.OK), c.check)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testJWK publishes a public key as a JWK
+func testJWK(t *testing.T, pub crypto.PublicKey, kid string) jwk {
+	t.Helper()
+	b64 := base64.RawURLEncoding.EncodeToString
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return jwk{Kty: "RSA", Kid: kid, Use: "sig", N: b64(k.N.Bytes()), E: b64(big.NewInt(int64(k.E)).Bytes())}
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		return jwk{Kty: "EC", Kid: kid, Crv: k.Curve.Params().Name, X: b64(k.X.FillBytes(make([]byte, size))), Y: b64(k.Y.FillBytes(make([]byte, size)))}
+	case ed25519.PublicKey:
+		return jwk{Kty: "OKP", Kid: kid, Crv: "Ed25519", X: b64(k)}
+	}
+	t.Fatalf("unsupported key %T", pub)
+	return jwk{}
+}
+
+// useJWKSServer serves a discovery document and JWKS with the given keys
+// and returns the issuer URL
+func useJWKSServer(t *testing.T, keys ...jwk) string {
+	t.Helper()
+	var server *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(OIDCConfig{Issuer: server.URL, TokenEndpoint: server.URL + "/token", JWKSURI: server.URL + "/jwks"})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jwkSet{Keys: keys})
+	})
+	server = httptest.NewTLSServer(mux)
+	t.Cleanup(server.Close)
+	useTLSServer(t, server)
+	return server.URL
+}
+
+func TestVerifyJWT(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	issuer := useJWKSServer(t,
+		testJWK(t, rsaKey.Public(), "rsa"),
+		testJWK(t, ecKey.Public(), "ec"),
+		testJWK(t, edKey.Public(), "ed"),
+	)
+	now := time.Now()
+	claims := map[string]any{"iss": issuer, "aud": []string{"api", "billing"}, "exp": now.Add(time.Hour).Unix()}
+	sign := func(key crypto.Signer, alg, kid string, claims map[string]any) string {
+		t.Helper()
+		header := map[string]any{}
+		if kid != "" {
+			header["kid"] = kid
+		}
+		token, err := signJWT(key, alg, header, claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	with := func(k string, v any) map[string]any {
+		c := map[string]any{}
+		for name, value := range claims {
+			c[name] = value
+		}
+		c[k] = v
+		return c
+	}
+	valid := sign(ecKey, "ES256", "ec", claims)
+
+	tests := []struct {
+		name      string
+		token     string
+		audience  string
+		signature string
+		valid     bool
+	}{
+		{"RS256", sign(rsaKey, "RS256", "rsa", claims), "", sigValid, true},
+		{"PS256", sign(rsaKey, "PS256", "rsa", claims), "", sigValid, true},
+		{"ES256", valid, "", sigValid, true},
+		{"EdDSA", sign(edKey, "EdDSA", "ed", claims), "", sigValid, true},
+		{"no kid", sign(edKey, "EdDSA", "", claims), "", sigValid, true},
+		{"expected audience", valid, "billing", sigValid, true},
+		{"wrong audience", valid, "reports", sigValid, false},
+		{"wrong issuer", sign(ecKey, "ES256", "ec", with("iss", "https://evil.example.com")), "", sigValid, false},
+		{"expired", sign(ecKey, "ES256", "ec", with("exp", now.Add(-time.Minute).Unix())), "", sigValid, false},
+		{"tampered", valid[:strings.LastIndex(valid, ".")-2] + "xx" + valid[strings.LastIndex(valid, "."):], "", sigInvalid, false},
+		{"wrong key", sign(otherKey, "ES256", "ec", claims), "", sigInvalid, false},
+		{"unknown kid", sign(otherKey, "ES256", "rotated", claims), "", sigUnknownKid, false},
+		{"key type mismatch", sign(ecKey, "ES256", "rsa", claims), "", sigUnverified, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := verifyJWT(tt.token, issuer, tt.audience, now)
+			if v.Signature != tt.signature {
+				t.Errorf("expected signature %q, got %q (%v)", tt.signature, v.Signature, v.Err)
+			}
+			if v.Valid() != tt.valid {
+				t.Errorf("expected valid=%v, got %+v", tt.valid, v)
+			}
+		})
+	}
+
+	if v := verifyJWT(sign(edKey, "EdDSA", "", claims), issuer, "", now); v.Kid != "ed" {
+		t.Errorf("expected the matching key's kid, got %q", v.Kid)
+	}
+	if v := verifyJWT(valid, issuer, "api", now); v.Audience == nil || !v.Audience.OK || v.Issuer.Want != issuer {
+		t.Errorf("unexpected claim checks %+v %+v", v.Issuer, v.Audience)
+	}
+}
+
+func TestVerifyJWS(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	input := "eyJhbGciOiJFUzM4NCJ9.eyJzdWIiOiJhbGljZSJ9"
+	digest := sha512.Sum384([]byte(input))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := make([]byte, 96)
+	r.FillBytes(sig[:48])
+	s.FillBytes(sig[48:])
+	token := input + "." + base64.RawURLEncoding.EncodeToString(sig)
+
+	if err := verifyJWS(token, "ES384", &key.PublicKey); err != nil {
+		t.Errorf("expected ES384 to verify: %v", err)
+	}
+	if err := verifyJWS(token, "ES256", &key.PublicKey); err == nil || err == errBadSignature {
+		t.Errorf("expected a curve mismatch error, got %v", err)
+	}
+	if err := verifyJWS(token, "HS256", &key.PublicKey); err == nil {
+		t.Error("expected HS256 to be unsupported")
+	}
+}
+
+func TestJWKPublicKey(t *testing.T) {
+	tests := []struct {
+		name string
+		key  jwk
+	}{
+		{"unknown type", jwk{Kty: "oct"}},
+		{"unknown curve", jwk{Kty: "EC", Crv: "P-192", X: "AA", Y: "AA"}},
+		{"point off curve", jwk{Kty: "EC", Crv: "P-256", X: "AQ", Y: "AQ"}},
+		{"missing modulus", jwk{Kty: "RSA", E: "AQAB"}},
+		{"short Ed25519 key", jwk{Kty: "OKP", Crv: "Ed25519", X: "AQID"}},
+	}
+	for _, tt := range tests {
+		if _, err := tt.key.publicKey(); err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+}
+
+func TestRunVerifyCmd(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	issuer := useJWKSServer(t, testJWK(t, key.Public(), "key-1"))
+	useConfigHome(t, []Client{{Name: "api", Issuer: issuer, Audience: "orders"}})
+
+	token, err := signJWT(key, "ES256", map[string]any{"kid": "key-1"}, map[string]any{"iss": issuer, "aud": "orders", "exp": time.Now().Add(time.Hour).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	forged, _ := signJWT(other, "ES256", map[string]any{"kid": "key-1"}, map[string]any{"iss": issuer})
+	asJSON, _ := json.Marshal(TokenResult{Token: TokenResponse{AccessToken: token}, Client: Client{Issuer: issuer}})
+
+	tests := []struct {
+		name  string
+		args  []string
+		stdin string
+		want  int
+		out   string
+	}{
+		{"explicit issuer", []string{"--issuer", issuer}, token + "\n", exitOK, "✓ valid (ES256, kid key-1)"},
+		{"no issuer", nil, token, exitUsage, ""},
+		{"tkz token json", []string{"--issuer", issuer}, string(asJSON), exitOK, "Issuer:    ✓ " + issuer},
+		{"client audience", []string{"--client", "api"}, token, exitOK, "Audience:  ✓ orders"},
+		{"wrong audience", []string{"--issuer", issuer, "--audience", "billing"}, token, exitTokenInvalid, "orders (expected billing)"},
+		{"forged", []string{"--issuer", issuer}, forged, exitTokenInvalid, "✗ invalid: signature does not match"},
+		{"discovery fails", []string{"--issuer", issuer + "/missing"}, token, exitDiscovery, "not verified"},
+		{"opaque", nil, "2YotnFZFEjr1zCsicMWpAA", exitUsage, ""},
+		{"empty", nil, "", exitUsage, ""},
+		{"unknown client", []string{"--client", "nope"}, token, exitClientNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			if code := runVerifyCmd(tt.args, strings.NewReader(tt.stdin), &stdout, &stderr); code != tt.want {
+				t.Fatalf("expected exit %d, got %d: %s%s", tt.want, code, stdout.String(), stderr.String())
+			}
+			if !strings.Contains(stdout.String(), tt.out) {
+				t.Errorf("expected %q in output:\n%s", tt.out, stdout.String())
+			}
+		})
+	}
+}
+
+func TestTokenViewVerification(t *testing.T) {
+	token := newTestJWT(t, map[string]any{"iss": "https://auth.example.com"})
+	m := initialModel("")
+	m.mode = tokenView
+	m.tokenResult = &TokenResult{Token: TokenResponse{AccessToken: token}, Client: Client{Name: "api"}}
+
+	if !strings.Contains(m.View(), "verifying against the issuer's JWKS") {
+		t.Error("expected a pending verification while the JWKS is fetched")
+	}
+
+	result, _ := m.Update(tokenVerifiedMsg{token: "stale", verification: tokenVerification{Signature: sigInvalid}})
+	m = result.(model)
+	if m.tokenVerify != nil {
+		t.Error("expected results for another token to be dropped")
+	}
+
+	result, _ = m.Update(tokenVerifiedMsg{token: token, verification: tokenVerification{
+		Signature: sigValid,
+		Alg:       "ES256",
+		Kid:       "key-1",
+		Issuer:    &claimCheck{OK: false, Got: "https://auth.example.com", Want: "https://login.example.com"},
+	}})
+	view := result.(model).View()
+	for _, want := range []string{"✓ valid (ES256, kid key-1)", "✗ https://auth.example.com (expected https://login.example.com)"} {
+		if !strings.Contains(view, want) {
+			t.Errorf("expected %q in the token view", want)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"strings"
+)
+
+// parsePrivateKeyPEM parses a PEM encoded RSA, EC or Ed25519 private key
+// (PKCS#8, PKCS#1 or SEC 1). Keys pasted into single-line fields often carry
+// literal "\n" sequences instead of newlines, so those are accepted too.
+func parsePrivateKeyPEM(data string) (crypto.Signer, error) {
+	block, _ := pem.Decode([]byte(normalizePEM(data)))
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found in private key")
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type %T", key)
+		}
+		return signer, nil
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type %q", block.Type)
+	}
+}
+
+// normalizePEM trims a PEM value and restores newlines that were stored as
+// literal "\n" sequences
+func normalizePEM(data string) string {
+	data = strings.TrimSpace(data)
+	if !strings.Contains(data, "\n") {
+		data = strings.ReplaceAll(data, `\n`, "\n")
+	}
+	return data
+}
+
+// decodeJWTClaims decodes the payload of a compact JWS without verifying
+// it. It fails for opaque (non-JWT) tokens.
+func decodeJWTClaims(token string) (map[string]any, error) {
+	return decodeJWTSegment(token, 1, "payload")
+}
+
+// decodeJWTHeader decodes the JOSE header of a compact JWS
+func decodeJWTHeader(token string) (map[string]any, error) {
+	return decodeJWTSegment(token, 0, "header")
+}
+
+func decodeJWTSegment(token string, i int, name string) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("not a JWT")
+	}
+	data, err := base64.RawURLEncoding.DecodeString(parts[i])
+	if err != nil {
+		return nil, fmt.Errorf("decode JWT %s: %w", name, err)
+	}
+	var v map[string]any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf("parse JWT %s: %w", name, err)
+	}
+	return v, nil
+}
+
+// defaultSigningAlg picks the JWS algorithm matching a key type
+func defaultSigningAlg(key crypto.Signer) string {
+	switch key.(type) {
+	case *ecdsa.PrivateKey:
+		return "ES256"
+	case ed25519.PrivateKey:
+		return "EdDSA"
+	default:
+		return "RS256"
+	}
+}
+
+// signJWT builds and signs a compact JWS with the given claims.
+// Supported algorithms: RS256, PS256, ES256, EdDSA.
+func signJWT(key crypto.Signer, alg string, header map[string]any, claims any) (string, error) {
+	if alg == "" {
+		alg = defaultSigningAlg(key)
+	}
+	h := map[string]any{"alg": alg, "typ": "JWT"}
+	for k, v := range header {
+		h[k] = v
+	}
+
+	headerJSON, err := json.Marshal(h)
+	if err != nil {
+		return "", err
+	}
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(headerJSON) + "." + base64.RawURLEncoding.EncodeToString(claimsJSON)
+
+	sig, err := signJWS(key, alg, []byte(signingInput))
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+func signJWS(key crypto.Signer, alg string, input []byte) ([]byte, error) {
+	digest := sha256.Sum256(input)
+
+	switch alg {
+	case "RS256":
+		k, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("%s requires an RSA key", alg)
+		}
+		return rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+	case "PS256":
+		k, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("%s requires an RSA key", alg)
+		}
+		return rsa.SignPSS(rand.Reader, k, crypto.SHA256, digest[:], &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+	case "ES256":
+		k, ok := key.(*ecdsa.PrivateKey)
+		if !ok || k.Curve.Params().BitSize != 256 {
+			return nil, fmt.Errorf("%s requires a P-256 EC key", alg)
+		}
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		if err != nil {
+			return nil, err
+		}
+		// JWS uses the fixed-size r||s encoding, not ASN.1
+		sig := make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+		return sig, nil
+	case "EdDSA":
+		k, ok := key.(ed25519.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("%s requires an Ed25519 key", alg)
+		}
+		return ed25519.Sign(k, input), nil
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q (use RS256, PS256, ES256 or EdDSA)", alg)
+	}
+}
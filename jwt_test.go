@@ -0,0 +1,145 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+// verifyTestJWT checks a compact JWS signature and returns its decoded parts
+func verifyTestJWT(t *testing.T, token string, pub crypto.PublicKey) (header, claims map[string]any) {
+	t.Helper()
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("expected 3 JWT segments, got %d", len(parts))
+	}
+	for i, v := range []*map[string]any{&header, &claims} {
+		raw, err := base64.RawURLEncoding.DecodeString(parts[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(raw, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := []byte(parts[0] + "." + parts[1])
+	digest := sha256.Sum256(input)
+
+	var ok bool
+	switch header["alg"] {
+	case "RS256":
+		ok = rsa.VerifyPKCS1v15(pub.(*rsa.PublicKey), crypto.SHA256, digest[:], sig) == nil
+	case "PS256":
+		ok = rsa.VerifyPSS(pub.(*rsa.PublicKey), crypto.SHA256, digest[:], sig, nil) == nil
+	case "ES256":
+		r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+		ok = len(sig) == 64 && ecdsa.Verify(pub.(*ecdsa.PublicKey), digest[:], r, s)
+	case "EdDSA":
+		ok = ed25519.Verify(pub.(ed25519.PublicKey), input, sig)
+	}
+	if !ok {
+		t.Fatalf("signature did not verify for alg %v", header["alg"])
+	}
+	return header, claims
+}
+
+func TestSignJWT(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	tests := []struct {
+		name    string
+		key     crypto.Signer
+		alg     string
+		wantAlg string
+	}{
+		{"RS256", rsaKey, "RS256", "RS256"},
+		{"PS256", rsaKey, "PS256", "PS256"},
+		{"ES256", ecKey, "ES256", "ES256"},
+		{"EdDSA", edKey, "EdDSA", "EdDSA"},
+		{"default RSA", rsaKey, "", "RS256"},
+		{"default EC", ecKey, "", "ES256"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := signJWT(tt.key, tt.alg, map[string]any{"kid": "k1"}, map[string]any{"sub": "me"})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			header, claims := verifyTestJWT(t, token, tt.key.Public())
+			if header["alg"] != tt.wantAlg || header["kid"] != "k1" || header["typ"] != "JWT" {
+				t.Errorf("unexpected header: %v", header)
+			}
+			if claims["sub"] != "me" {
+				t.Errorf("unexpected claims: %v", claims)
+			}
+		})
+	}
+}
+
+func TestSignJWTKeyMismatch(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if _, err := signJWT(ecKey, "RS256", nil, map[string]any{}); err == nil {
+		t.Error("expected error signing RS256 with an EC key")
+	}
+	if _, err := signJWT(ecKey, "HS256", nil, map[string]any{}); err == nil {
+		t.Error("expected error for unsupported algorithm")
+	}
+}
+
+func TestParsePrivateKeyPEM(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ecDER, _ := x509.MarshalECPrivateKey(ecKey)
+	pkcs8DER, _ := x509.MarshalPKCS8PrivateKey(rsaKey)
+
+	pkcs1 := string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}))
+	sec1 := string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: ecDER}))
+	pkcs8 := string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8DER}))
+
+	tests := []struct {
+		name    string
+		data    string
+		wantErr bool
+	}{
+		{"PKCS#1 RSA", pkcs1, false},
+		{"SEC 1 EC", sec1, false},
+		{"PKCS#8", pkcs8, false},
+		{"escaped newlines", strings.ReplaceAll(strings.TrimSpace(pkcs8), "\n", `\n`), false},
+		{"surrounding whitespace", "\n  " + pkcs1 + "\n", false},
+		{"not PEM", "just a secret", true},
+		{"wrong block type", "-----BEGIN CERTIFICATE-----\nAAAA\n-----END CERTIFICATE-----\n", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parsePrivateKeyPEM(tt.data)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
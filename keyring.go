@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// OS keyring entry holding the disk cache key
+const (
+	keyringService = "tkz"
+	keyringAccount = "token-cache"
+)
+
+// keyringCacheKey returns the disk cache key from the OS keyring, creating
+// and storing a random one on first use. The key is passed to the keyring
+// tools on stdin, never as an argument.
+func keyringCacheKey() ([]byte, error) {
+	encoded, err := keyringGet()
+	if err != nil {
+		return nil, err
+	}
+	if encoded != "" {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("keyring entry %s/%s is not a tkz cache key", keyringService, keyringAccount)
+		}
+		return key, nil
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if err := keyringSet(base64.StdEncoding.EncodeToString(key)); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// keyringGet returns the stored secret, or "" if there is none yet
+func keyringGet() (string, error) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("security", "find-generic-password", "-s", keyringService, "-a", keyringAccount, "-w")
+	case "linux", "freebsd", "openbsd":
+		cmd = exec.Command("secret-tool", "lookup", "service", keyringService, "account", keyringAccount)
+	default:
+		return "", fmt.Errorf("OS keyring is not supported on %s, use \"disk_cache\": \"session\"", runtime.GOOS)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && keyringNotFound(exitErr.ExitCode(), stderr.String()) {
+		return "", nil
+	}
+	if err != nil {
+		// A locked keyring must not read as a missing key: a new one would
+		// orphan the existing cache
+		return "", fmt.Errorf("keyring lookup: %s", strings.TrimSpace(stderr.String()+" "+err.Error()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// keyringNotFound reports whether a failed lookup means there is no entry:
+// security exits 44 (errSecItemNotFound), secret-tool exits 1 without a
+// message, while its D-Bus and unlock failures print one
+func keyringNotFound(code int, stderr string) bool {
+	if runtime.GOOS == "darwin" {
+		return code == 44
+	}
+	return code == 1 && strings.TrimSpace(stderr) == ""
+}
+
+func keyringSet(secret string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		// security -i reads commands from stdin, keeping the secret out of argv
+		cmd = exec.Command("security", "-i")
+		cmd.Stdin = strings.NewReader(fmt.Sprintf("add-generic-password -U -s %s -a %s -w %s\n", keyringService, keyringAccount, secret))
+	default:
+		cmd = exec.Command("secret-tool", "store", "--label=tkz token cache", "service", keyringService, "account", keyringAccount)
+		cmd.Stdin = strings.NewReader(secret)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("keyring store: %s", strings.TrimSpace(stderr.String()+" "+err.Error()))
+	}
+	return nil
+}
@@ -18,6 +18,8 @@ func main() {
 		case "--help", "-h":
 			printHelp()
 			os.Exit(0)
+		case "token", "refresh", "exec", "proxy", "cache", "agent", "verify", "introspect", "revoke":
+			os.Exit(runCLI(os.Args[1], os.Args[2:]))
 		}
 	}
 
@@ -40,9 +42,34 @@ func printHelp() {
 	fmt.Println("tkz - OAuth Token Manager")
 	fmt.Println()
 	fmt.Println("Manage OAuth clients and retrieve bearer tokens for development.")
-	fmt.Println("Secrets are fetched from Bitwarden at runtime, never stored locally.")
+	fmt.Println("Secrets are fetched from Bitwarden (or another secret provider) at runtime,")
+	fmt.Println("never stored locally.")
 	fmt.Println()
 	fmt.Println("Usage: tkz [flags]")
+	fmt.Println("       tkz token <client> [--output token|json|env|header] [--force]")
+	fmt.Println("       tkz refresh <client> [--refresh-token <token>] [--output ...]")
+	fmt.Println("       tkz exec <client> [--var NAME] [--header-var NAME] -- <command> [args...]")
+	fmt.Println("       tkz proxy --client <name> --upstream <url> [--listen 127.0.0.1:8080] [--route /prefix=<client>[@<url>]]")
+	fmt.Println("       tkz agent [--idle <duration>]")
+	fmt.Println("       tkz agent status|unlock|lock|stop")
+	fmt.Println("       tkz verify (--client <name> | --issuer <url>) [--audience <aud>] < token")
+	fmt.Println("       tkz introspect <client> [--hint access_token|refresh_token] [--output text|json] < token")
+	fmt.Println("       tkz revoke <client> [--hint access_token|refresh_token] < token")
+	fmt.Println()
+	fmt.Println("Commands:")
+	fmt.Println("  token <client>   Print an access token for a client (no TUI)")
+	fmt.Println("  refresh <client> Redeem a refresh token (flag or stdin) for a client")
+	fmt.Println("  exec <client> -- <command>")
+	fmt.Println("                   Run a command with the token in TKZ_TOKEN (--client name=VAR repeats)")
+	fmt.Println("  proxy            Forward requests to an upstream with the client's token added")
+	fmt.Println("  cache clear      Delete the encrypted on-disk token cache")
+	fmt.Println("  agent            Run the agent: holds the vault session and a token cache")
+	fmt.Println("  agent status|unlock|lock|stop")
+	fmt.Println("                   Control the running agent")
+	fmt.Println("  verify           Verify a JWT from stdin against the issuer's JWKS")
+	fmt.Println("  introspect <client>")
+	fmt.Println("                   Introspect a token from stdin with the client's credentials")
+	fmt.Println("  revoke <client>  Revoke a token from stdin and drop cached copies")
 	fmt.Println()
 	fmt.Println("Flags:")
 	fmt.Println("  --help, -h       Show this help")
@@ -50,9 +77,22 @@ func printHelp() {
 	fmt.Println()
 	fmt.Println("Environment:")
 	fmt.Println("  BW_SESSION       Bitwarden session key (optional, tkz prompts if needed)")
+	fmt.Println("  TKZ_AGENT_SOCK   Agent socket (default ~/.config/tkz/agent/agent.sock)")
+	fmt.Println()
+	fmt.Println("Exit codes (headless commands):")
+	fmt.Println("  0 success, 1 error, 2 usage, 3 secret provider locked, 4 client not found,")
+	fmt.Println("  5 discovery failed, 6 token endpoint rejected the request,")
+	fmt.Println("  7 token failed verification (tkz verify) or is inactive (tkz introspect),")
+	fmt.Println("  8 fetching the client's item failed")
 	fmt.Println()
 	fmt.Println("Key Bindings:")
 	fmt.Println("  enter            Get token for selected client")
+	fmt.Println("  f                Force a new token, bypassing the cache")
+	fmt.Println("  P                Start a proxy for the selected client")
+	fmt.Println("  r                Refresh token (in token view)")
+	fmt.Println("  i                Introspect token (in token view)")
+	fmt.Println("  x                Revoke access and/or refresh token (in token view)")
+	fmt.Println("  u                Show UserInfo claims and the decoded ID token (in token view)")
 	fmt.Println("  a                Add new OAuth client")
 	fmt.Println("  e                Edit selected client")
 	fmt.Println("  d                Delete selected client")
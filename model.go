@@ -1,6 +1,9 @@
 package main
 
 import (
+	"context"
+	"time"
+
 	"github.com/charmbracelet/bubbles/list"
 	"github.com/charmbracelet/bubbles/spinner"
 	"github.com/charmbracelet/bubbles/textinput"
@@ -20,37 +23,58 @@ type model struct {
 	prevMode viewMode
 
 	clients   []Client
+	settings  Settings
 	statusMsg string
 	errorMsg  string
 
-	bwSession    string
-	bwInstalled  bool
-	bwUnlocked   bool
-	bwChecking   bool
-	bwStatus     string // "unlocked", "locked", "unauthenticated"
-	bwItems      []BWItem
-	bwSelectList list.Model
-	bwPwInput    textinput.Model
-	bwUnlocking  bool
-	bwUnlockErr  string
+	// The secret provider the provider fields describe; actions on a client
+	// of another provider switch to it
+	provider           secretProvider
+	session            string
+	providerInstalled  bool
+	providerUnlocked   bool
+	providerChecking   bool
+	providerStatus     string // "unlocked", "locked", "unauthenticated"
+	providerItems      []secretRef
+	providerFolder     string // folder open in the picker of a tree provider
+	providerSelectList list.Model
+	providerPwInput    textinput.Model
+	providerUnlocking  bool
+	providerUnlockErr  string
 
 	form          *huh.Form
 	editingIndex  int
 	formClient    *Client
+	formItemsFor  string // client whose items are listed before its form opens
 	pendingAction string
 
 	tokenResult  *TokenResult
+	tokenCached  bool // tokenResult was served from tokenCache
+	tokenVerify  *tokenVerification
+	tokenCache   tokenCache
 	tokenLoading bool
+	tokenCancel  context.CancelFunc
+	tokenPrompts tuiPrompter
+	authURL      string
+	deviceAuth   *DeviceAuthResponse
+	dpopInput    textinput.Model
+
+	inspectTitle string
+
+	proxyInput textinput.Model
+	proxy      *tuiProxy
+	proxyLog   []string
 
 	deleteIndex int
 }
 
-func initialModel(bwSession string) model {
+func initialModel(session string) model {
 	s := spinner.New()
 	s.Spinner = spinner.Dot
 	s.Style = spinnerStyle
 
-	clients, _ := loadClients()
+	f, _ := loadClientsFile(getClientsPath())
+	clients := f.Clients
 
 	delegate := list.NewDefaultDelegate()
 	l := list.New(clientsToItems(clients), delegate, 0, 0)
@@ -62,37 +86,61 @@ func initialModel(bwSession string) model {
 
 	vp := viewport.New(80, 20)
 
+	// Start with the provider of the first client, so a setup without
+	// Bitwarden does not greet the user with a Bitwarden login
+	provider := secretProviders[0]
+	if len(clients) > 0 {
+		if p, err := providerFor(clients[0].Provider); err == nil {
+			provider = p
+		}
+	}
+
 	pwInput := textinput.New()
-	pwInput.Placeholder = "Master password"
+	pwInput.Placeholder = provider.info().prompt
 	pwInput.EchoMode = textinput.EchoPassword
 	pwInput.EchoCharacter = '*'
 	pwInput.Width = 40
 
-	bwList := list.New([]list.Item{}, list.NewDefaultDelegate(), 0, 0)
-	bwList.Title = "Select Bitwarden Item"
-	bwList.SetShowStatusBar(true)
-	bwList.SetFilteringEnabled(true)
-	bwList.SetShowHelp(false)
-	bwList.Styles.Title = titleStyle
+	dpopInput := textinput.New()
+	dpopInput.Placeholder = "GET https://api.example.com/resource"
+	dpopInput.Width = 60
+
+	proxyInput := textinput.New()
+	proxyInput.Placeholder = "https://api.example.com " + defaultProxyListen
+	proxyInput.Width = 60
+
+	itemList := list.New([]list.Item{}, list.NewDefaultDelegate(), 0, 0)
+	itemList.Title = "Select " + provider.info().label + " Item"
+	itemList.SetShowStatusBar(true)
+	itemList.SetFilteringEnabled(true)
+	itemList.SetShowHelp(false)
+	itemList.Styles.Title = titleStyle
 
 	return model{
-		list:         l,
-		spinner:      s,
-		viewport:     vp,
-		bwPwInput:    pwInput,
-		bwSelectList: bwList,
-		bwChecking:   true,
-		mode:         listView,
-		clients:      clients,
-		bwSession:    bwSession,
-		editingIndex: -1,
+		list:               l,
+		spinner:            s,
+		viewport:           vp,
+		providerPwInput:    pwInput,
+		dpopInput:          dpopInput,
+		proxyInput:         proxyInput,
+		providerSelectList: itemList,
+		providerChecking:   true,
+		mode:               listView,
+		clients:            clients,
+		settings:           f.Settings,
+		provider:           provider,
+		session:            session,
+		editingIndex:       -1,
+		tokenCache:         make(tokenCache),
 	}
 }
 
 func (m model) Init() tea.Cmd {
 	return tea.Batch(
 		m.spinner.Tick,
-		checkBWStatus(m.bwSession),
+		checkProviderStatus(m.provider, m.session),
+		cacheTick(),
+		loadDiskCacheCmd(m.settings, m.session),
 	)
 }
 
@@ -104,7 +152,7 @@ func clientsToItems(clients []Client) []list.Item {
 	return items
 }
 
-func bwItemsToListItems(items []BWItem) []list.Item {
+func secretRefsToListItems(items []secretRef) []list.Item {
 	listItems := make([]list.Item, len(items))
 	for i, item := range items {
 		listItems[i] = item
@@ -113,11 +161,124 @@ func bwItemsToListItems(items []BWItem) []list.Item {
 }
 
 func (m *model) updateList() {
-	m.list.SetItems(clientsToItems(m.clients))
+	items := make([]list.Item, len(m.clients))
+	for i, c := range m.clients {
+		c.cachedUntil = m.tokenCache.freshUntil(c)
+		items[i] = c
+	}
+	m.list.SetItems(items)
+}
+
+func (m *model) updateProviderSelectList() {
+	info := m.provider.info()
+	m.providerSelectList.Title = "Select " + info.label + " Item"
+	if !info.tree {
+		m.providerSelectList.SetItems(secretRefsToListItems(m.providerItems))
+		return
+	}
+	if m.providerFolder != "" {
+		m.providerSelectList.Title += ": " + m.providerFolder + "/"
+	}
+	m.providerSelectList.SetItems(secretRefsToListItems(folderItems(m.providerItems, m.providerFolder)))
+}
+
+// openProviderFolder shows folder in the item picker, "" being the top
+func (m *model) openProviderFolder(folder string) {
+	m.providerFolder = folder
+	m.updateProviderSelectList()
+	m.providerSelectList.ResetFilter()
+	m.providerSelectList.Select(0)
+}
+
+// useProvider makes p the provider the provider fields describe and starts
+// over with its status check
+func (m *model) useProvider(p secretProvider) {
+	m.provider = p
+	m.providerChecking = true
+	m.providerInstalled = false
+	m.providerUnlocked = false
+	m.providerStatus = ""
+	m.providerItems = nil
+	m.providerFolder = ""
+	m.updateProviderSelectList()
+	m.providerPwInput.Placeholder = p.info().prompt
+	m.mode = listView
+}
+
+// startTokenRequest switches to the token view and starts the token pipeline.
+// force skips the agent's cache.
+func (m *model) startTokenRequest(client Client, force bool) tea.Cmd {
+	m.cancelTokenRequest()
+	ctx, cancel := context.WithCancel(context.Background())
+	m.tokenCancel = cancel
+	m.tokenPrompts = make(tuiPrompter, 1)
+	m.mode = tokenView
+	m.tokenLoading = true
+	m.tokenResult = nil
+	m.tokenCached = false
+	m.tokenVerify = nil
+	m.authURL = ""
+	m.deviceAuth = nil
+	return tea.Batch(
+		m.spinner.Tick,
+		requestToken(ctx, m.session, m.clients, client, force, m.tokenPrompts),
+		waitForPrompt(m.tokenPrompts),
+	)
+}
+
+// mergeCache adds tokens loaded from disk, keeping whichever copy is newer
+func (m *model) mergeCache(tc tokenCache) {
+	for k, r := range tc {
+		if existing, ok := m.tokenCache[k]; !ok || existing.FetchedAt.Before(r.FetchedAt) {
+			m.tokenCache[k] = r
+		}
+	}
+	m.updateList()
+}
+
+// showCachedToken switches to the token view with a token from the cache
+func (m *model) showCachedToken(result TokenResult) {
+	m.cancelTokenRequest()
+	m.mode = tokenView
+	m.tokenLoading = false
+	m.tokenResult = &result
+	m.tokenCached = true
+	m.statusMsg = ""
 }
 
-func (m *model) updateBWSelectList() {
-	m.bwSelectList.SetItems(bwItemsToListItems(m.bwItems))
+// startIntrospection asks the authorization server about the shown token
+func (m *model) startIntrospection() tea.Cmd {
+	m.statusMsg = "Introspecting token..."
+	return introspectCmd(m.session, *m.tokenResult)
+}
+
+// startRefresh redeems the shown token's refresh token
+func (m *model) startRefresh() tea.Cmd {
+	prev := *m.tokenResult
+	m.cancelTokenRequest()
+	ctx, cancel := context.WithCancel(context.Background())
+	m.tokenCancel = cancel
+	m.tokenLoading = true
+	m.tokenResult = nil
+	return tea.Batch(m.spinner.Tick, refreshTokenCmd(ctx, m.session, prev))
+}
+
+// verifyToken starts the JWKS verification of the shown token. Opaque and
+// encrypted tokens have no signature tkz can check.
+func (m *model) verifyToken() tea.Cmd {
+	m.tokenVerify = nil
+	if m.tokenResult == nil || tokenFormat(m.tokenResult.Token.AccessToken) != formatJWT {
+		return nil
+	}
+	return verifyTokenCmd(*m.tokenResult)
+}
+
+// cancelTokenRequest aborts a running token pipeline or refresh, if any
+func (m *model) cancelTokenRequest() {
+	if m.tokenCancel != nil {
+		m.tokenCancel()
+		m.tokenCancel = nil
+	}
 }
 
 func (m *model) setErrorContent(errMsg string) {
@@ -130,7 +291,66 @@ func (m *model) setErrorContent(errMsg string) {
 	m.viewport.GotoTop()
 }
 
-func buildClientForm(client *Client) *huh.Form {
+// openInspect shows the decoded token in the scrollable inspection view
+func (m *model) openInspect(title, token string) {
+	m.openDetail(title, inspectToken(token, time.Now()))
+}
+
+// openDetail shows content in the scrollable inspection viewport
+func (m *model) openDetail(title, content string) {
+	m.inspectTitle = title
+	width := m.viewport.Width
+	if width <= 0 {
+		width = 76
+	}
+	m.viewport.SetContent(lipgloss.NewStyle().Width(width).Render(content))
+	m.viewport.GotoTop()
+	m.mode = inspectView
+}
+
+// itemOptions builds select options for picking a provider item by ID
+func itemOptions(items []secretRef) []huh.Option[string] {
+	opts := []huh.Option[string]{huh.NewOption("(none)", "")}
+	for _, item := range items {
+		label := item.Name
+		if item.Detail != "" {
+			label += " (" + item.Detail + ")"
+		}
+		opts = append(opts, huh.NewOption(label, item.ID))
+	}
+	return opts
+}
+
+// subjectClientOptions builds select options for picking a token exchange
+// subject client, leaving out the client being edited
+func subjectClientOptions(clients []Client, self string) []huh.Option[string] {
+	opts := []huh.Option[string]{huh.NewOption("(none)", "")}
+	for _, c := range clients {
+		if c.Name != self {
+			opts = append(opts, huh.NewOption(c.Name, c.Name))
+		}
+	}
+	return opts
+}
+
+// withCurrent adds value to a select's options if they lack it. A huh
+// select writes back its highlighted option, which is the first one when
+// the saved value is not offered, so the value would be lost on save.
+func withCurrent(opts []huh.Option[string], value, note string) []huh.Option[string] {
+	if value == "" {
+		return opts
+	}
+	for _, o := range opts {
+		if o.Value == value {
+			return opts
+		}
+	}
+	return append(opts, huh.NewOption(value+" ("+note+")", value))
+}
+
+func buildClientForm(client *Client, provider secretProvider, items []secretRef, clients []Client) *huh.Form {
+	info := provider.info()
+	vault, isVault := provider.(vaultProvider)
 	return huh.NewForm(
 		huh.NewGroup(
 			huh.NewInput().
@@ -138,23 +358,34 @@ func buildClientForm(client *Client) *huh.Form {
 				Value(&client.Name).
 				Placeholder("e.g., keycloak-dev"),
 
+			huh.NewSelect[string]().
+				Title("Grant Type").
+				Options(withCurrent([]huh.Option[string]{
+					huh.NewOption("Client Credentials", ""),
+					huh.NewOption("Authorization Code + PKCE (browser)", grantAuthorizationCode),
+					huh.NewOption("Device Code (headless / SSH)", grantDeviceCode),
+					huh.NewOption("Password (resource owner)", grantPassword),
+					huh.NewOption("Token Exchange (from another client)", grantTokenExchange),
+				}, client.GrantType, "from clients.json")...).
+				Value(&client.GrantType),
+
 			huh.NewInput().
 				Title("Client ID").
 				Value(&client.ClientID).
-				Placeholder("leave empty to pull from Bitwarden").
-				Description("Manual override — skips BW lookup for client_id"),
+				Placeholder("leave empty to pull from "+info.label).
+				Description("Manual override — skips the provider lookup for client_id"),
 
 			huh.NewInput().
 				Title("Client ID Field").
 				Value(&client.ClientIDField).
-				Placeholder("login.username").
-				Description("BW field: login.username, login.password, fields.<name>, notes"),
+				Placeholder(info.idField).
+				Description(info.label+" field: "+info.fields),
 
 			huh.NewInput().
 				Title("Client Secret Field").
 				Value(&client.ClientSecretField).
-				Placeholder("login.password").
-				Description("BW field: login.username, login.password, fields.<name>, notes"),
+				Placeholder(info.secretField).
+				Description(info.label+" field: "+info.fields),
 
 			huh.NewInput().
 				Title("Issuer URL").
@@ -166,5 +397,102 @@ func buildClientForm(client *Client) *huh.Form {
 				Value(&client.Scopes).
 				Placeholder("openid profile email"),
 		),
+
+		huh.NewGroup(
+			huh.NewSelect[string]().
+				Title("Token Endpoint Auth Method").
+				Options(withCurrent([]huh.Option[string]{
+					huh.NewOption("(from discovery)", ""),
+					huh.NewOption("client_secret_basic", authClientSecretBasic),
+					huh.NewOption("client_secret_post", authClientSecretPost),
+					huh.NewOption("private_key_jwt", authPrivateKeyJWT),
+					huh.NewOption("tls_client_auth", authTLSClientAuth),
+					huh.NewOption("none (public client)", authNone),
+				}, client.AuthMethod, "from clients.json")...).
+				Value(&client.AuthMethod),
+
+			huh.NewConfirm().
+				Title("DPoP-bound tokens").
+				Description("Prove possession of a per-session key (RFC 9449)").
+				Value(&client.DPoP),
+
+			huh.NewInput().
+				Title("Private Key Field").
+				Value(&client.PrivateKeyField).
+				Placeholder("leave empty to use the client secret").
+				Description("private_key_jwt PEM key, a "+info.label+" field"),
+
+			huh.NewSelect[string]().
+				Title("Signing Algorithm").
+				Options(withCurrent([]huh.Option[string]{
+					huh.NewOption("(from key type)", ""),
+					huh.NewOption("RS256", "RS256"),
+					huh.NewOption("PS256", "PS256"),
+					huh.NewOption("ES256", "ES256"),
+					huh.NewOption("EdDSA", "EdDSA"),
+				}, client.SigningAlg, "from clients.json")...).
+				Value(&client.SigningAlg),
+
+			huh.NewInput().
+				Title("Key ID (kid)").
+				Value(&client.KeyID).
+				Placeholder("optional"),
+
+			huh.NewInput().
+				Title("TLS Client Certificate Field").
+				Value(&client.TLSCertField).
+				Placeholder("leave empty for no mutual TLS").
+				Description("PEM certificate (and key), a "+info.label+" field"),
+
+			huh.NewInput().
+				Title("TLS Client Key Field").
+				Value(&client.TLSKeyField).
+				Placeholder("leave empty if the certificate field holds the key"),
+		),
+
+		huh.NewGroup(
+			huh.NewInput().
+				Title("Vault Address").
+				Value(&client.VaultAddr).
+				Placeholder(vault.address()).
+				Description("Leave empty for VAULT_ADDR"),
+
+			huh.NewInput().
+				Title("Vault Namespace").
+				Value(&client.VaultNamespace).
+				Placeholder(vault.ns()).
+				Description("Leave empty for VAULT_NAMESPACE"),
+
+			huh.NewInput().
+				Title("KV v2 Mount").
+				Value(&client.VaultMount).
+				Placeholder(vault.mountPath()),
+		).WithHideFunc(func() bool { return !isVault }),
+
+		huh.NewGroup(
+			huh.NewSelect[string]().
+				Title("User Credentials Item").
+				Description(info.label+" item whose "+info.userField+"/"+info.passwordField+" are sent in the password grant").
+				Options(withCurrent(itemOptions(items), client.UserItemID, "not in the loaded items")...).
+				Value(&client.UserItemID),
+		).WithHideFunc(func() bool { return client.GrantType != grantPassword }),
+
+		huh.NewGroup(
+			huh.NewSelect[string]().
+				Title("Subject Client").
+				Description("Client whose token is exchanged").
+				Options(withCurrent(subjectClientOptions(clients, client.Name), client.SubjectClient, "no such client")...).
+				Value(&client.SubjectClient),
+
+			huh.NewInput().
+				Title("Audience").
+				Value(&client.Audience).
+				Placeholder("downstream-service"),
+
+			huh.NewInput().
+				Title("Requested Token Type").
+				Value(&client.RequestedTokenType).
+				Placeholder("urn:ietf:params:oauth:token-type:access_token"),
+		).WithHideFunc(func() bool { return client.GrantType != grantTokenExchange }),
 	).WithTheme(huh.ThemeDracula()).WithWidth(60)
 }
@@ -48,3 +48,43 @@ func TestSetErrorContent(t *testing.T) {
 		}
 	})
 }
+
+func TestItemOptions(t *testing.T) {
+	opts := itemOptions([]secretRef{
+		{ID: "id-1", Name: "Test User", Detail: "alice"},
+		{ID: "id-2", Name: "No Login"},
+	})
+	if len(opts) != 3 {
+		t.Fatalf("expected 3 options (none + 2 items), got %d", len(opts))
+	}
+	if opts[0].Value != "" {
+		t.Errorf("expected first option to be empty, got %q", opts[0].Value)
+	}
+	if opts[1].Value != "id-1" || opts[1].Key != "Test User (alice)" {
+		t.Errorf("unexpected option: %+v", opts[1])
+	}
+	if opts[2].Key != "No Login" {
+		t.Errorf("expected plain name without username, got %q", opts[2].Key)
+	}
+}
+
+func TestBuildClientFormKeepsUnlistedValues(t *testing.T) {
+	client := Client{
+		Name:          "legacy",
+		GrantType:     grantPassword,
+		UserItemID:    "op://Private/Test User",
+		SubjectClient: "renamed-client",
+		SigningAlg:    "ES384",
+		AuthMethod:    "client_secret_jwt",
+	}
+	want := client
+	buildClientForm(&client, secretProviders[0], nil, []Client{{Name: "legacy"}, {Name: "other"}})
+	if client != want {
+		t.Errorf("expected saved values to survive building the form, got %+v", client)
+	}
+
+	opts := withCurrent(itemOptions([]secretRef{{ID: "id-1", Name: "Test User"}}), "id-1", "not in the loaded items")
+	if len(opts) != 2 {
+		t.Errorf("expected a listed value not to be added again, got %d options", len(opts))
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+)
+
+// mtlsEndpointAliases are the alternative endpoints an authorization server
+// exposes for mutual-TLS clients (RFC 8705 §5)
+type mtlsEndpointAliases struct {
+	TokenEndpoint               string `json:"token_endpoint"`
+	DeviceAuthorizationEndpoint string `json:"device_authorization_endpoint"`
+	IntrospectionEndpoint       string `json:"introspection_endpoint"`
+	RevocationEndpoint          string `json:"revocation_endpoint"`
+	UserinfoEndpoint            string `json:"userinfo_endpoint"`
+}
+
+// parseClientCertificate builds a TLS client certificate from PEM data.
+// The key may live in the same value as the certificate, so an empty keyPEM
+// reuses certPEM.
+func parseClientCertificate(certPEM, keyPEM string) (tls.Certificate, error) {
+	certPEM = normalizePEM(certPEM)
+	if keyPEM == "" {
+		keyPEM = certPEM
+	}
+	cert, err := tls.X509KeyPair([]byte(certPEM), []byte(normalizePEM(keyPEM)))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return tls.Certificate{}, err
+		}
+	}
+	return cert, nil
+}
+
+// certThumbprint returns the x5t#S256 thumbprint of a certificate: the
+// base64url SHA-256 of its DER encoding (RFC 8705 §3.1)
+func certThumbprint(cert *tls.Certificate) string {
+	if cert == nil || len(cert.Certificate) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256(cert.Certificate[0])
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// tokenCertThumbprint returns the cnf.x5t#S256 claim of a JWT access token,
+// or "" if the token is opaque or not certificate-bound
+func tokenCertThumbprint(accessToken string) string {
+	claims, err := decodeJWTClaims(accessToken)
+	if err != nil {
+		return ""
+	}
+	cnf, _ := claims["cnf"].(map[string]any)
+	x5t, _ := cnf["x5t#S256"].(string)
+	return x5t
+}
+
+// withMTLSAliases returns the discovery document with endpoints replaced by
+// their mutual-TLS aliases where the server publishes them
+func (c OIDCConfig) withMTLSAliases() *OIDCConfig {
+	if a := c.MTLSEndpointAliases; a != nil {
+		if a.TokenEndpoint != "" {
+			c.TokenEndpoint = a.TokenEndpoint
+		}
+		if a.DeviceAuthorizationEndpoint != "" {
+			c.DeviceAuthorizationEndpoint = a.DeviceAuthorizationEndpoint
+		}
+		if a.IntrospectionEndpoint != "" {
+			c.IntrospectionEndpoint = a.IntrospectionEndpoint
+		}
+		if a.RevocationEndpoint != "" {
+			c.RevocationEndpoint = a.RevocationEndpoint
+		}
+		if a.UserinfoEndpoint != "" {
+			c.UserinfoEndpoint = a.UserinfoEndpoint
+		}
+	}
+	return &c
+}
+
+// mtlsHTTPClient returns an HTTP client presenting cert, with the same
+// timeout and TLS floor as httpClient
+func mtlsHTTPClient(cert tls.Certificate) (*http.Client, error) {
+	base, ok := httpClient.Transport.(*http.Transport)
+	if !ok {
+		return nil, fmt.Errorf("unexpected HTTP transport %T", httpClient.Transport)
+	}
+	transport := base.Clone()
+	transport.TLSClientConfig.Certificates = []tls.Certificate{cert}
+	return &http.Client{Timeout: httpClient.Timeout, Transport: transport}, nil
+}
+
+// certThumbprints returns the certificate thumbprint the access token is
+// bound to and the one of the client certificate tkz presented
+func (r TokenResult) certThumbprints() (bound, presented string) {
+	return tokenCertThumbprint(r.Token.AccessToken), certThumbprint(r.creds.certificate)
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestClientCert returns a self-signed client certificate and its key as PEM
+func newTestClientCert(t *testing.T) (certPEM, keyPEM string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "tkz-test-client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	keyPEM = string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}))
+	return certPEM, keyPEM
+}
+
+func TestParseClientCertificate(t *testing.T) {
+	certPEM, keyPEM := newTestClientCert(t)
+
+	tests := []struct {
+		name    string
+		cert    string
+		key     string
+		wantErr bool
+	}{
+		{"separate fields", certPEM, keyPEM, false},
+		{"combined value", certPEM + keyPEM, "", false},
+		{"escaped newlines", strings.ReplaceAll(strings.TrimSpace(certPEM), "\n", `\n`), keyPEM, false},
+		{"missing key", certPEM, "", true},
+		{"not PEM", "secret", keyPEM, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cert, err := parseClientCertificate(tt.cert, tt.key)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if cert.Leaf == nil || cert.Leaf.Subject.CommonName != "tkz-test-client" {
+				t.Errorf("expected parsed leaf certificate, got %+v", cert.Leaf)
+			}
+		})
+	}
+}
+
+func TestCertThumbprints(t *testing.T) {
+	certPEM, keyPEM := newTestClientCert(t)
+	cert, err := parseClientCertificate(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(cert.Leaf.Raw)
+	want := base64.RawURLEncoding.EncodeToString(sum[:])
+	if got := certThumbprint(&cert); got != want {
+		t.Errorf("expected thumbprint %s, got %s", want, got)
+	}
+
+	payload, _ := json.Marshal(map[string]any{"cnf": map[string]any{"x5t#S256": want}})
+	token := "eyJhbGciOiJub25lIn0." + base64.RawURLEncoding.EncodeToString(payload) + ".sig"
+	bound, presented := TokenResult{Token: TokenResponse{AccessToken: token}, creds: clientCredentials{certificate: &cert}}.certThumbprints()
+	if bound != want || presented != want {
+		t.Errorf("expected both thumbprints %s, got %s / %s", want, bound, presented)
+	}
+
+	if got := tokenCertThumbprint("opaque-token"); got != "" {
+		t.Errorf("expected no thumbprint for opaque token, got %q", got)
+	}
+}
+
+func TestWithMTLSAliases(t *testing.T) {
+	oidc := OIDCConfig{
+		TokenEndpoint:               "https://auth.example.com/token",
+		DeviceAuthorizationEndpoint: "https://auth.example.com/device",
+		MTLSEndpointAliases:         &mtlsEndpointAliases{TokenEndpoint: "https://mtls.auth.example.com/token"},
+	}
+	got := oidc.withMTLSAliases()
+	if got.TokenEndpoint != "https://mtls.auth.example.com/token" {
+		t.Errorf("expected mTLS token endpoint, got %s", got.TokenEndpoint)
+	}
+	if got.DeviceAuthorizationEndpoint != "https://auth.example.com/device" {
+		t.Errorf("expected device endpoint without alias to be kept, got %s", got.DeviceAuthorizationEndpoint)
+	}
+	if oidc.TokenEndpoint != "https://auth.example.com/token" {
+		t.Error("expected original discovery document to be unchanged")
+	}
+	if (OIDCConfig{TokenEndpoint: "https://a/token"}).withMTLSAliases().TokenEndpoint != "https://a/token" {
+		t.Error("expected endpoints unchanged without aliases")
+	}
+}
+
+func TestTLSClientAuthPresentsCertificate(t *testing.T) {
+	certPEM, keyPEM := newTestClientCert(t)
+	cert, err := parseClientCertificate(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":"invalid_client"}`))
+			return
+		}
+		r.ParseForm()
+		if r.FormValue("client_id") != "mtls-client" || r.FormValue("client_secret") != "" {
+			t.Errorf("expected only client_id in form, got %v", r.PostForm)
+		}
+		sum := sha256.Sum256(r.TLS.PeerCertificates[0].Raw)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(TokenResponse{
+			AccessToken: "bound-" + base64.RawURLEncoding.EncodeToString(sum[:]),
+			TokenType:   "Bearer",
+		})
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.Config.ErrorLog = log.New(io.Discard, "", 0) // expected handshake failure below
+	server.StartTLS()
+	defer server.Close()
+	useTLSServer(t, server)
+
+	creds, err := clientCredentials{ClientID: "mtls-client", certificate: &cert}.withAuthMethod(authTLSClientAuth, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := requestClientCredentials(server.URL+"/token", creds, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if token.AccessToken != "bound-"+certThumbprint(&cert) {
+		t.Errorf("expected token bound to client certificate, got %q", token.AccessToken)
+	}
+
+	// Without a certificate the handshake is rejected
+	if _, err := requestClientCredentials(server.URL+"/token", clientCredentials{ClientID: "mtls-client"}, ""); err == nil {
+		t.Error("expected error without a client certificate")
+	}
+	if _, err := (clientCredentials{ClientID: "mtls-client"}).withAuthMethod(authTLSClientAuth, nil); err == nil {
+		t.Error("expected tls_client_auth to require a certificate")
+	}
+}
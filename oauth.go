@@ -51,19 +51,106 @@ func DiscoverOIDC(issuerURL string) (*OIDCConfig, error) {
 
 // RequestToken performs a client_credentials grant against the token endpoint
 func RequestToken(tokenEndpoint, clientID, clientSecret, scopes string) (*TokenResponse, error) {
-	if !strings.HasPrefix(tokenEndpoint, "https://") {
-		return nil, fmt.Errorf("token endpoint must use HTTPS: %s", tokenEndpoint)
+	return requestClientCredentials(tokenEndpoint, clientCredentials{ClientID: clientID, ClientSecret: clientSecret}, scopes)
+}
+
+// requestClientCredentials performs a client_credentials grant with any client authentication
+func requestClientCredentials(tokenEndpoint string, creds clientCredentials, scopes string) (*TokenResponse, error) {
+	data := url.Values{"grant_type": {"client_credentials"}}
+	if scopes != "" {
+		data.Set("scope", scopes)
 	}
+	return postTokenForm(tokenEndpoint, creds, data)
+}
+
+// RequestPasswordToken performs a resource owner password credentials grant (RFC 6749 §4.3)
+func RequestPasswordToken(tokenEndpoint string, creds clientCredentials, username, password, scopes string) (*TokenResponse, error) {
 	data := url.Values{
-		"grant_type":    {"client_credentials"},
-		"client_id":     {clientID},
-		"client_secret": {clientSecret},
+		"grant_type": {"password"},
+		"username":   {username},
+		"password":   {password},
 	}
 	if scopes != "" {
 		data.Set("scope", scopes)
 	}
+	return postTokenForm(tokenEndpoint, creds, data)
+}
+
+// RequestRefreshToken performs a refresh_token grant (RFC 6749 §6)
+func RequestRefreshToken(tokenEndpoint string, creds clientCredentials, refreshToken, scopes string) (*TokenResponse, error) {
+	data := url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refreshToken},
+	}
+	if scopes != "" {
+		data.Set("scope", scopes)
+	}
+	return postTokenForm(tokenEndpoint, creds, data)
+}
+
+// Token type identifiers (RFC 8693 §3)
+const (
+	tokenTypeAccessToken = "urn:ietf:params:oauth:token-type:access_token"
+	tokenExchangeGrant   = "urn:ietf:params:oauth:grant-type:token-exchange"
+)
+
+// tokenExchangeParams are the RFC 8693 request parameters
+type tokenExchangeParams struct {
+	SubjectToken       string
+	SubjectTokenType   string // Defaults to the access_token type
+	Audience           string
+	RequestedTokenType string
+	Scopes             string
+}
+
+// RequestTokenExchange exchanges a subject token for a new token (RFC 8693 §2.1)
+func RequestTokenExchange(tokenEndpoint string, creds clientCredentials, params tokenExchangeParams) (*TokenResponse, error) {
+	subjectType := params.SubjectTokenType
+	if subjectType == "" {
+		subjectType = tokenTypeAccessToken
+	}
+	data := url.Values{
+		"grant_type":         {tokenExchangeGrant},
+		"subject_token":      {params.SubjectToken},
+		"subject_token_type": {subjectType},
+	}
+	if params.Audience != "" {
+		data.Set("audience", params.Audience)
+	}
+	if params.RequestedTokenType != "" {
+		data.Set("requested_token_type", params.RequestedTokenType)
+	}
+	if params.Scopes != "" {
+		data.Set("scope", params.Scopes)
+	}
+	return postTokenForm(tokenEndpoint, creds, data)
+}
+
+// OAuthError is an error response from the token endpoint (RFC 6749 §5.2)
+type OAuthError struct {
+	StatusCode  int    `json:"-"`
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+	Body        string `json:"-"`
+}
+
+func (e *OAuthError) Error() string {
+	return fmt.Sprintf("token endpoint returned status %d: %s", e.StatusCode, e.Body)
+}
+
+func newOAuthError(status int, body []byte) *OAuthError {
+	e := &OAuthError{StatusCode: status, Body: string(body)}
+	json.Unmarshal(body, e)
+	return e
+}
+
+// postTokenForm POSTs a grant to the token endpoint and parses the response
+func postTokenForm(tokenEndpoint string, creds clientCredentials, data url.Values) (*TokenResponse, error) {
+	if !strings.HasPrefix(tokenEndpoint, "https://") {
+		return nil, fmt.Errorf("token endpoint must use HTTPS: %s", tokenEndpoint)
+	}
 
-	resp, err := httpClient.PostForm(tokenEndpoint, data)
+	resp, err := postAuthenticatedForm(tokenEndpoint, creds, data)
 	if err != nil {
 		return nil, fmt.Errorf("token request failed: %w", err)
 	}
@@ -75,7 +162,7 @@ func RequestToken(tokenEndpoint, clientID, clientSecret, scopes string) (*TokenR
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("token endpoint returned status %d: %s", resp.StatusCode, string(body))
+		return nil, newOAuthError(resp.StatusCode, body)
 	}
 
 	var token TokenResponse
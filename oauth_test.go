@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -210,3 +211,51 @@ func TestRequestTokenRejectsHTTP(t *testing.T) {
 		t.Errorf("expected HTTPS error, got: %v", err)
 	}
 }
+
+func TestRequestPasswordToken(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		want := map[string]string{
+			"grant_type": "password",
+			"client_id":  "legacy-app",
+			"username":   "test-user",
+			"password":   "test-pass",
+			"scope":      "openid",
+		}
+		for k, v := range want {
+			if r.FormValue(k) != v {
+				t.Errorf("expected %s=%q, got %q", k, v, r.FormValue(k))
+			}
+		}
+		if _, ok := r.PostForm["client_secret"]; ok {
+			t.Error("expected no client_secret for a public client")
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token": "user-tok", "token_type": "Bearer", "expires_in": 300}`))
+	}))
+	defer server.Close()
+	useTLSServer(t, server)
+
+	token, err := RequestPasswordToken(server.URL, clientCredentials{ClientID: "legacy-app"}, "test-user", "test-pass", "openid")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if token.AccessToken != "user-tok" {
+		t.Errorf("unexpected access_token: %s", token.AccessToken)
+	}
+}
+
+func TestRequestPasswordTokenRejected(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"error": "invalid_grant", "error_description": "Invalid user credentials"}`))
+	}))
+	defer server.Close()
+	useTLSServer(t, server)
+
+	_, err := RequestPasswordToken(server.URL, clientCredentials{ClientID: "app", ClientSecret: "secret"}, "user", "wrong", "")
+	var oauthErr *OAuthError
+	if !errors.As(err, &oauthErr) || oauthErr.Code != "invalid_grant" {
+		t.Errorf("expected invalid_grant OAuthError, got %v", err)
+	}
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const providerOnePassword = "1password"
+
+// onePasswordProvider resolves secrets with the 1Password CLI. Item IDs are
+// 1Password item IDs or op://<vault>/<item> references; field paths may
+// also be complete op:// secret references.
+//
+// op's own sign-in (the desktop app, a service account, or OP_SESSION_*
+// from `eval $(op signin)`) is used as is. Signing in from tkz keeps the
+// session token in memory and hands it to op in its environment only.
+type onePasswordProvider struct {
+	mu      sync.Mutex
+	user    string // user UUID the session belongs to
+	session string
+}
+
+// OPAccount is an account from `op account list`
+type OPAccount struct {
+	URL         string `json:"url"`
+	Email       string `json:"email"`
+	UserUUID    string `json:"user_uuid"`
+	AccountUUID string `json:"account_uuid"`
+}
+
+// OPVault references the vault an item lives in
+type OPVault struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// OPURL is a website of a 1Password item
+type OPURL struct {
+	Href    string `json:"href"`
+	Primary bool   `json:"primary"`
+}
+
+// OPItem is an item from `op item list`
+type OPItem struct {
+	ID                    string  `json:"id"`
+	Title                 string  `json:"title"`
+	Category              string  `json:"category"`
+	Vault                 OPVault `json:"vault"`
+	URLs                  []OPURL `json:"urls"`
+	AdditionalInformation string  `json:"additional_information"`
+}
+
+// OPSection groups fields of a 1Password item
+type OPSection struct {
+	ID    string `json:"id"`
+	Label string `json:"label"`
+}
+
+// OPField is a field of a 1Password item
+type OPField struct {
+	ID      string     `json:"id"`
+	Type    string     `json:"type"`
+	Purpose string     `json:"purpose"`
+	Label   string     `json:"label"`
+	Value   string     `json:"value"`
+	Section *OPSection `json:"section"`
+}
+
+// OPFullItem is an item from `op item get` with its fields
+type OPFullItem struct {
+	OPItem
+	Fields []OPField `json:"fields"`
+}
+
+func (p *onePasswordProvider) info() providerInfo {
+	return providerInfo{
+		name:          providerOnePassword,
+		label:         "1Password",
+		command:       "op",
+		install:       "brew install 1password-cli",
+		login:         "op account add",
+		unlock:        "eval $(op signin)",
+		prompt:        "Password",
+		fields:        "<field>, <section>.<field>, notes, op://<vault>/<item>/<field>",
+		idField:       "username",
+		secretField:   "password",
+		userField:     "username",
+		passwordField: "password",
+	}
+}
+
+// status is "unlocked" when op can read items, "locked" when an account
+// is set up but signed out, and "unauthenticated" without any account
+func (p *onePasswordProvider) status(string) (bool, string) {
+	if _, err := exec.LookPath("op"); err != nil {
+		return false, "unauthenticated"
+	}
+	if _, err := p.run(nil, "whoami", "--format", "json"); err == nil {
+		return true, "unlocked"
+	}
+	out, err := p.run(nil, "account", "list", "--format", "json")
+	if err != nil {
+		return true, "unauthenticated"
+	}
+	accounts, err := parseOPAccounts(out)
+	if err != nil || len(accounts) == 0 {
+		return true, "unauthenticated"
+	}
+	return true, "locked"
+}
+
+// unlock signs in to the first account (or $OP_ACCOUNT) with the account
+// password and keeps the session token for later op calls
+func (p *onePasswordProvider) unlock(password string) (string, error) {
+	out, err := p.run(nil, "account", "list", "--format", "json")
+	if err != nil {
+		return "", err
+	}
+	accounts, err := parseOPAccounts(out)
+	if err != nil {
+		return "", fmt.Errorf("op account list: %w", err)
+	}
+	name := os.Getenv("OP_ACCOUNT")
+	account, ok := pickOPAccount(accounts, name)
+	if !ok {
+		if name != "" && len(accounts) > 0 {
+			var listed []string
+			for _, a := range accounts {
+				listed = append(listed, a.URL+" ("+a.Email+")")
+			}
+			return "", fmt.Errorf("OP_ACCOUNT=%s matches none of the accounts set up: %s", name, strings.Join(listed, ", "))
+		}
+		return "", fmt.Errorf("no 1Password account set up (run: op account add)")
+	}
+
+	out, err = p.run(strings.NewReader(password+"\n"), "signin", "--raw", "--account", account.AccountUUID)
+	if err != nil {
+		return "", fmt.Errorf("sign in failed: %w", err)
+	}
+	session := strings.TrimSpace(string(out))
+	if session == "" {
+		return "", fmt.Errorf("no session token returned")
+	}
+
+	p.mu.Lock()
+	p.user, p.session = account.UserUUID, session
+	p.mu.Unlock()
+	return "", nil
+}
+
+func (p *onePasswordProvider) listItems(string) ([]secretRef, error) {
+	out, err := p.run(nil, "item", "list", "--format", "json")
+	if err != nil {
+		return nil, err
+	}
+	items, err := parseOPItems(out)
+	if err != nil {
+		return nil, fmt.Errorf("op item list: %w", err)
+	}
+	refs := make([]secretRef, len(items))
+	for i, item := range items {
+		detail := item.Vault.Name
+		if item.AdditionalInformation != "" {
+			detail += " • " + item.AdditionalInformation
+		}
+		refs[i] = secretRef{ID: item.ID, Name: item.Title, Detail: detail, URL: item.primaryURL()}
+	}
+	return refs, nil
+}
+
+// item loads an item by ID or op://<vault>/<item>. Without an ID only
+// op:// field references resolve.
+func (p *onePasswordProvider) item(_, id string) (secretItem, error) {
+	if id == "" {
+		return opSecretItem{provider: p}, nil
+	}
+	args := []string{"item", "get", id, "--format", "json"}
+	if strings.HasPrefix(id, "op://") {
+		vault, item, ok := strings.Cut(strings.TrimPrefix(id, "op://"), "/")
+		if !ok || vault == "" || item == "" || strings.Contains(item, "/") {
+			return nil, fmt.Errorf("invalid item reference %q (use op://<vault>/<item>)", id)
+		}
+		args = []string{"item", "get", item, "--vault", vault, "--format", "json"}
+	}
+	out, err := p.run(nil, args...)
+	if err != nil {
+		return nil, err
+	}
+	item, err := parseOPFullItem(out)
+	if err != nil {
+		return nil, fmt.Errorf("parse: %w", err)
+	}
+	return opSecretItem{provider: p, item: item}, nil
+}
+
+// read resolves an op:// secret reference
+func (p *onePasswordProvider) read(ref string) (string, error) {
+	out, err := p.run(nil, "read", "--no-newline", ref)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// run runs op, passing the session from unlock in the environment so it
+// stays out of the process list
+func (p *onePasswordProvider) run(stdin *strings.Reader, args ...string) ([]byte, error) {
+	cmd := exec.Command("op", args...)
+	p.mu.Lock()
+	if p.session != "" {
+		cmd.Env = append(os.Environ(), "OP_SESSION_"+p.user+"="+p.session)
+	}
+	p.mu.Unlock()
+	if stdin != nil {
+		cmd.Stdin = stdin
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("op %s: %s", args[0], opErrorMessage(stderr.String(), err))
+	}
+	return stdout.Bytes(), nil
+}
+
+// opLogPrefix matches the "[ERROR] 2026/01/02 15:04:05 " op puts before
+// its messages
+var opLogPrefix = regexp.MustCompile(`^\[ERROR\] \S+ \S+ `)
+
+func opErrorMessage(stderr string, err error) string {
+	msg := strings.TrimSpace(opLogPrefix.ReplaceAllString(strings.TrimSpace(stderr), ""))
+	if msg == "" {
+		return err.Error()
+	}
+	return msg
+}
+
+// opSecretItem resolves field paths against a 1Password item, and op://
+// references with op read
+type opSecretItem struct {
+	provider *onePasswordProvider
+	item     *OPFullItem
+}
+
+func (i opSecretItem) field(path string) (string, error) {
+	if strings.HasPrefix(path, "op://") {
+		return i.provider.read(path)
+	}
+	if i.item == nil {
+		return "", fmt.Errorf("no 1password item configured for field %q (use an op:// reference)", path)
+	}
+	return ResolveOPField(i.item, path)
+}
+
+func (item OPItem) primaryURL() string {
+	for _, u := range item.URLs {
+		if u.Primary {
+			return u.Href
+		}
+	}
+	if len(item.URLs) > 0 {
+		return item.URLs[0].Href
+	}
+	return ""
+}
+
+// pickOPAccount picks the account to sign in to: the one named by
+// OP_ACCOUNT (URL, email, or UUID), or else the first
+func pickOPAccount(accounts []OPAccount, name string) (OPAccount, bool) {
+	for _, a := range accounts {
+		if name == "" || name == a.URL || name == a.Email || name == a.AccountUUID || name == a.UserUUID {
+			return a, true
+		}
+	}
+	return OPAccount{}, false
+}
+
+// --- JSON parsing functions (tested independently) ---
+
+func parseOPAccounts(data []byte) ([]OPAccount, error) {
+	var accounts []OPAccount
+	if err := json.Unmarshal(data, &accounts); err != nil {
+		return nil, err
+	}
+	return accounts, nil
+}
+
+func parseOPItems(data []byte) ([]OPItem, error) {
+	var items []OPItem
+	if err := json.Unmarshal(data, &items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+func parseOPFullItem(data []byte) (*OPFullItem, error) {
+	var item OPFullItem
+	if err := json.Unmarshal(data, &item); err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+// ResolveOPField resolves a field path against a 1Password item: a field
+// label or ID like "password", "<section>.<field>" for a field in a
+// section, or "notes". Labels match case-insensitively, like op:// paths.
+func ResolveOPField(item *OPFullItem, fieldPath string) (string, error) {
+	if fieldPath == "" {
+		return "", fmt.Errorf("empty field path")
+	}
+	var matches []OPField
+	for _, f := range item.Fields {
+		if opFieldMatches(f, fieldPath) {
+			matches = append(matches, f)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("field %q not found in 1password item %q", fieldPath, item.Title)
+	case 1:
+		return matches[0].Value, nil
+	default:
+		return "", fmt.Errorf("field %q is ambiguous in 1password item %q (use <section>.<field>)", fieldPath, item.Title)
+	}
+}
+
+func opFieldMatches(f OPField, path string) bool {
+	if path == "notes" && f.Purpose == "NOTES" {
+		return true
+	}
+	if strings.EqualFold(path, f.ID) || strings.EqualFold(path, f.Label) {
+		return true
+	}
+	if f.Section == nil {
+		return false
+	}
+	section, name, ok := strings.Cut(path, ".")
+	if !ok {
+		return false
+	}
+	inSection := strings.EqualFold(section, f.Section.Label) || strings.EqualFold(section, f.Section.ID)
+	return inSection && (strings.EqualFold(name, f.Label) || strings.EqualFold(name, f.ID))
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+const testOPItem = `{
+  "id": "item-1",
+  "title": "Keycloak API",
+  "category": "LOGIN",
+  "vault": {"id": "v-1", "name": "Private"},
+  "urls": [{"href": "https://other.example.com"}, {"href": "https://auth.example.com", "primary": true}],
+  "fields": [
+    {"id": "username", "type": "STRING", "purpose": "USERNAME", "label": "username", "value": "my-client"},
+    {"id": "password", "type": "CONCEALED", "purpose": "PASSWORD", "label": "password", "value": "s3cret"},
+    {"id": "notesPlain", "type": "STRING", "purpose": "NOTES", "label": "notesPlain", "value": "a note"},
+    {"id": "f1", "type": "CONCEALED", "label": "secret", "value": "prod-secret", "section": {"id": "s1", "label": "Prod"}},
+    {"id": "f2", "type": "CONCEALED", "label": "secret", "value": "dev-secret", "section": {"id": "s2", "label": "Dev"}}
+  ]
+}`
+
+// fakeOP puts an op script on PATH that serves testOPItem. The session is
+// "signed in" when the signed-in file exists (like the desktop app) or
+// when OP_SESSION_USER1 holds the token from signing in with "hunter2".
+func fakeOP(t *testing.T) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("fake op script needs a POSIX shell")
+	}
+	dir := t.TempDir()
+	files := map[string]string{
+		"accounts.json":  `[{"url":"my.1password.com","email":"alice@example.com","user_uuid":"USER1","account_uuid":"ACC1"}]`,
+		"items.json":     `[{"id":"item-1","title":"Keycloak API","vault":{"id":"v-1","name":"Private"},"urls":[{"href":"https://auth.example.com","primary":true}],"additional_information":"my-client"}]`,
+		"item-item-1":    testOPItem,
+		"item-Keycloak":  testOPItem,
+		"read-reference": "from-reference",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	script := `#!/bin/sh
+dir="` + dir + `"
+fail() { echo "[ERROR] 2026/10/17 10:00:00 $1" >&2; exit 1; }
+signed_in() { [ -f "$dir/signed-in" ] || [ "$OP_SESSION_USER1" = "tok-1" ]; }
+case "$1" in
+whoami) signed_in && echo '{"user_uuid":"USER1"}' || fail "account is not signed in" ;;
+account) cat "$dir/accounts.json" ;;
+signin)
+	[ "$*" = "signin --raw --account ACC1" ] || fail "unexpected arguments: $*"
+	read -r pw
+	[ "$pw" = "hunter2" ] && echo tok-1 || fail "Authentication: (401) Unauthorized" ;;
+item)
+	signed_in || fail "account is not signed in"
+	case "$2" in
+	list) cat "$dir/items.json" ;;
+	get) [ -f "$dir/item-$3" ] && cat "$dir/item-$3" || fail "\"$3\" isn't an item" ;;
+	esac ;;
+read)
+	signed_in || fail "account is not signed in"
+	[ "$3" = "op://Private/Keycloak/reference" ] && printf %s "$(cat "$dir/read-reference")" || fail "could not read $3" ;;
+esac
+`
+	if err := os.WriteFile(filepath.Join(dir, "op"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("OP_ACCOUNT", "")
+	return dir
+}
+
+func TestResolveOPField(t *testing.T) {
+	item, err := parseOPFullItem([]byte(testOPItem))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path    string
+		want    string
+		wantErr string
+	}{
+		{path: "username", want: "my-client"},
+		{path: "Password", want: "s3cret"},
+		{path: "notes", want: "a note"},
+		{path: "prod.secret", want: "prod-secret"},
+		{path: "s2.secret", want: "dev-secret"},
+		{path: "secret", wantErr: "ambiguous"},
+		{path: "token", wantErr: "not found"},
+		{path: "", wantErr: "empty"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, err := ResolveOPField(item, tt.path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestParseOPItems(t *testing.T) {
+	items, err := parseOPItems([]byte(`[{"id":"a","title":"A","vault":{"name":"Private"}},{"id":"b","title":"B","urls":[{"href":"https://x.example.com"}]}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 || items[0].Vault.Name != "Private" || items[1].primaryURL() != "https://x.example.com" {
+		t.Errorf("unexpected items %+v", items)
+	}
+	if _, err := parseOPItems([]byte(`not json`)); err == nil {
+		t.Error("expected an error for invalid JSON")
+	}
+}
+
+func TestOnePasswordSignIn(t *testing.T) {
+	fakeOP(t)
+	p := &onePasswordProvider{}
+
+	if installed, status := p.status(""); !installed || status != "locked" {
+		t.Fatalf("expected a locked account, got %v %q", installed, status)
+	}
+	_, err := p.unlock("wrong")
+	if err == nil || !strings.Contains(err.Error(), "(401) Unauthorized") || strings.Contains(err.Error(), "[ERROR]") {
+		t.Fatalf("expected op's error without its log prefix, got %v", err)
+	}
+	session, err := p.unlock("hunter2")
+	if err != nil || session != "" {
+		t.Fatalf("expected the session to stay with the provider, got %q, %v", session, err)
+	}
+	if _, status := p.status(""); status != "unlocked" {
+		t.Errorf("expected the session to be used, got %q", status)
+	}
+
+	refs, err := p.listItems("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := secretRef{ID: "item-1", Name: "Keycloak API", Detail: "Private • my-client", URL: "https://auth.example.com"}
+	if len(refs) != 1 || refs[0] != want {
+		t.Errorf("expected %+v, got %+v", want, refs)
+	}
+}
+
+func TestOnePasswordUnknownAccount(t *testing.T) {
+	fakeOP(t)
+	t.Setenv("OP_ACCOUNT", "team.1password.com")
+	_, err := (&onePasswordProvider{}).unlock("hunter2")
+	want := "OP_ACCOUNT=team.1password.com matches none of the accounts set up: my.1password.com (alice@example.com)"
+	if err == nil || err.Error() != want {
+		t.Errorf("expected %q, got %v", want, err)
+	}
+}
+
+func TestOnePasswordNoAccount(t *testing.T) {
+	dir := fakeOP(t)
+	if err := os.WriteFile(filepath.Join(dir, "accounts.json"), []byte(`[]`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	p := &onePasswordProvider{}
+	if _, status := p.status(""); status != "unauthenticated" {
+		t.Errorf("expected unauthenticated without accounts, got %q", status)
+	}
+	err := requireProvider("", Client{Provider: providerOnePassword})
+	if !isProviderLocked(err) || !strings.Contains(err.Error(), "op account add") {
+		t.Errorf("expected a hint to add an account, got %v", err)
+	}
+}
+
+func TestOnePasswordCredentials(t *testing.T) {
+	dir := fakeOP(t)
+	if err := os.WriteFile(filepath.Join(dir, "signed-in"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		client  Client
+		id      string
+		secret  string
+		wantErr string
+	}{
+		{
+			name:   "default fields",
+			client: Client{Provider: providerOnePassword, ItemID: "item-1"},
+			id:     "my-client", secret: "s3cret",
+		},
+		{
+			name:   "vault reference and section field",
+			client: Client{Provider: providerOnePassword, ItemID: "op://Private/Keycloak", ClientSecretField: "Prod.secret"},
+			id:     "my-client", secret: "prod-secret",
+		},
+		{
+			name:   "secret reference",
+			client: Client{Provider: providerOnePassword, ItemID: "item-1", ClientSecretField: "op://Private/Keycloak/reference"},
+			id:     "my-client", secret: "from-reference",
+		},
+		{
+			name:   "references only",
+			client: Client{Provider: providerOnePassword, ClientIDField: "op://Private/Keycloak/reference", ClientSecretField: "op://Private/Keycloak/reference"},
+			id:     "from-reference", secret: "from-reference",
+		},
+		{
+			name:    "unknown item",
+			client:  Client{Provider: providerOnePassword, ItemID: "item-2"},
+			wantErr: `1password: op item: "item-2" isn't an item`,
+		},
+		{
+			name:    "bad reference",
+			client:  Client{Provider: providerOnePassword, ItemID: "op://Private"},
+			wantErr: "invalid item reference",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			creds, err := resolveClientCredentials("", tt.client)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if creds.ClientID != tt.id || creds.ClientSecret != tt.secret {
+				t.Errorf("expected %s/%s, got %+v", tt.id, tt.secret, creds)
+			}
+		})
+	}
+}
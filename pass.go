@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const (
+	providerPass   = "pass"
+	providerGopass = "gopass"
+)
+
+// passProvider resolves secrets from a GPG password store with pass or
+// gopass. Item IDs are entry paths like work/keycloak/dev. gpg-agent does
+// the unlocking, so tkz never asks for a password itself.
+type passProvider struct {
+	command string // "pass" or "gopass"
+}
+
+func (p passProvider) info() providerInfo {
+	info := providerInfo{
+		name:          p.command,
+		label:         p.command,
+		command:       p.command,
+		install:       "brew install pass",
+		login:         "pass init <gpg-id>",
+		unlock:        "pass show <entry>",
+		fields:        "password, fields.<name>, notes",
+		idField:       "fields.username",
+		secretField:   "password",
+		userField:     "fields.username",
+		passwordField: "password",
+		tree:          true,
+	}
+	if p.command == providerGopass {
+		info.install = "brew install gopass"
+		info.login = "gopass setup"
+		info.unlock = "gopass show <entry>"
+	}
+	return info
+}
+
+// status is "unlocked" once the store is set up; whether the GPG key is
+// usable only shows when an entry is decrypted
+func (p passProvider) status(string) (bool, string) {
+	if _, err := exec.LookPath(p.command); err != nil {
+		return false, "unauthenticated"
+	}
+	if p.command == providerGopass {
+		if _, err := p.run("ls", "--flat"); err != nil {
+			return true, "unauthenticated"
+		}
+		return true, "unlocked"
+	}
+	if _, err := os.Stat(filepath.Join(passStoreDir(), ".gpg-id")); err != nil {
+		return true, "unauthenticated"
+	}
+	return true, "unlocked"
+}
+
+func (p passProvider) unlock(string) (string, error) {
+	return "", fmt.Errorf("%s is unlocked by gpg-agent", p.command)
+}
+
+// listItems lists the store's entries; reading the tree needs no key
+func (p passProvider) listItems(string) ([]secretRef, error) {
+	var paths []string
+	if p.command == providerGopass {
+		out, err := p.run("ls", "--flat")
+		if err != nil {
+			return nil, err
+		}
+		// One entry per line; names may contain spaces
+		for _, line := range strings.Split(string(out), "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				paths = append(paths, line)
+			}
+		}
+	} else {
+		var err error
+		if paths, err = listPassStore(passStoreDir()); err != nil {
+			return nil, fmt.Errorf("read password store: %w", err)
+		}
+	}
+	refs := make([]secretRef, len(paths))
+	for i, path := range paths {
+		refs[i] = secretRef{ID: path, Name: path}
+	}
+	return refs, nil
+}
+
+func (p passProvider) item(_, id string) (secretItem, error) {
+	// -- keeps an entry named like a flag from being read as one
+	args := []string{"show", "--", id}
+	if p.command == providerGopass {
+		// Print the entry as stored, even with safecontent on
+		args = []string{"show", "--unsafe", "--noparsing", "--", id}
+	}
+	out, err := p.run(args...)
+	if err != nil {
+		return nil, err
+	}
+	return parsePassEntry(out), nil
+}
+
+// run runs pass or gopass. Its errors are mostly gpg's, which are passed
+// on with a hint for the common gpg-agent problems. A key gpg-agent could
+// not unlock is reported like a locked provider.
+func (p passProvider) run(args ...string) ([]byte, error) {
+	cmd := exec.Command(p.command, args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		hint, locked := gpgHint(msg)
+		err := fmt.Errorf("%s %s: %s%s", p.command, args[0], strings.TrimPrefix(msg, "Error: "), hint)
+		if locked {
+			return nil, &cliError{code: exitProviderLocked, err: err}
+		}
+		return nil, err
+	}
+	return stdout.Bytes(), nil
+}
+
+// gpgHint explains gpg failures that have nothing to do with the entry, and
+// reports whether the key is merely locked
+func gpgHint(msg string) (hint string, locked bool) {
+	switch {
+	case strings.Contains(msg, "No secret key"):
+		return " (the entry is encrypted for a key this machine does not have)", false
+	case strings.Contains(msg, "No pinentry"), strings.Contains(msg, "Inappropriate ioctl"),
+		strings.Contains(msg, "Operation cancelled"), strings.Contains(msg, "Timeout"):
+		return " (gpg-agent could not ask for the passphrase; unlock the key in a terminal first, or configure a graphical pinentry)", true
+	}
+	return "", false
+}
+
+// passStoreDir is where pass keeps its store
+func passStoreDir() string {
+	if dir := os.Getenv("PASSWORD_STORE_DIR"); dir != "" {
+		return dir
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".password-store")
+}
+
+// listPassStore lists the entries of a pass store: the .gpg files, as
+// slash-separated paths without the extension
+func listPassStore(dir string) ([]string, error) {
+	var paths []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && strings.HasPrefix(d.Name(), ".") && path != dir {
+			return filepath.SkipDir // .git, .extensions
+		}
+		if d.IsDir() || !strings.HasSuffix(d.Name(), ".gpg") {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		paths = append(paths, filepath.ToSlash(strings.TrimSuffix(rel, ".gpg")))
+		return nil
+	})
+	slices.Sort(paths)
+	return paths, err
+}
+
+// passEntry is a decrypted pass entry: the password on the first line,
+// then free text in which "key: value" lines are named fields
+type passEntry struct {
+	password string
+	notes    string
+	fields   map[string]string
+}
+
+// parsePassEntry splits a decrypted entry; the first of repeated keys wins
+func parsePassEntry(data []byte) passEntry {
+	password, rest, _ := strings.Cut(string(data), "\n")
+	entry := passEntry{
+		password: strings.TrimSuffix(password, "\r"),
+		notes:    strings.TrimRight(rest, "\n"),
+		fields:   map[string]string{},
+	}
+	for _, line := range strings.Split(rest, "\n") {
+		key, value, ok := strings.Cut(strings.TrimSuffix(line, "\r"), ":")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			continue
+		}
+		if _, seen := entry.fields[key]; !seen {
+			entry.fields[key] = strings.TrimSpace(value)
+		}
+	}
+	return entry
+}
+
+// field resolves password (the first line), notes (everything after it)
+// and fields.<name> for "name: value" lines
+func (e passEntry) field(path string) (string, error) {
+	switch {
+	case path == "password":
+		return e.password, nil
+	case path == "notes":
+		return e.notes, nil
+	case strings.HasPrefix(path, "fields."):
+		name := strings.TrimPrefix(path, "fields.")
+		if v, ok := e.fields[name]; ok {
+			return v, nil
+		}
+		return "", fmt.Errorf("field %q not found in pass entry", name)
+	default:
+		return "", fmt.Errorf("unsupported field path: %s (use password, fields.<name>, or notes)", path)
+	}
+}
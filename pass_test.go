@@ -0,0 +1,219 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// fakePass sets up a password store with placeholder .gpg files and puts
+// a pass script on PATH that shows the plain text kept next to them.
+// "broken" fails like an entry encrypted for another key.
+func fakePass(t *testing.T) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("fake pass script needs a POSIX shell")
+	}
+	store := t.TempDir()
+	plain := t.TempDir()
+	entries := map[string]string{
+		"work/keycloak/dev": "s3cret\nusername: my-client\nurl: https://auth.example.com\n",
+		"work/okta":         "okta-secret\n",
+		"personal":          "hunter2\n",
+		"broken":            "",
+		".git/objects/x":    "",
+	}
+	for name, content := range entries {
+		for _, f := range []string{filepath.Join(store, name+".gpg"), filepath.Join(plain, name)} {
+			if err := os.MkdirAll(filepath.Dir(f), 0700); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(f, []byte(content), 0600); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := os.WriteFile(filepath.Join(store, ".gpg-id"), []byte("alice@example.com\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	bin := t.TempDir()
+	script := `#!/bin/sh
+[ "$1 $2" = "show --" ] || { echo "unexpected arguments: $*" >&2; exit 1; }
+if [ "$3" = broken ]; then
+	echo "gpg: decryption failed: No secret key" >&2
+	exit 2
+fi
+if [ "$3" = locked ]; then
+	echo "gpg: public key decryption failed: No pinentry" >&2
+	exit 2
+fi
+[ -f "` + plain + `/$3" ] && cat "` + plain + `/$3" || { echo "Error: $3 is not in the password store." >&2; exit 1; }
+`
+	if err := os.WriteFile(filepath.Join(bin, "pass"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("PASSWORD_STORE_DIR", store)
+	return store
+}
+
+func TestParsePassEntry(t *testing.T) {
+	entry := parsePassEntry([]byte("s3cret\r\nusername: my-client\r\nurl: https://auth.example.com\nusername: second\n\n-----BEGIN KEY-----\n"))
+	tests := []struct {
+		path    string
+		want    string
+		wantErr string
+	}{
+		{path: "password", want: "s3cret"},
+		{path: "fields.username", want: "my-client"},
+		{path: "fields.url", want: "https://auth.example.com"},
+		{path: "notes", want: "username: my-client\r\nurl: https://auth.example.com\nusername: second\n\n-----BEGIN KEY-----"},
+		{path: "fields.token", wantErr: "not found"},
+		{path: "login.password", wantErr: "unsupported field path"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, err := entry.field(tt.path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestPassProvider(t *testing.T) {
+	store := fakePass(t)
+	p := passProvider{command: providerPass}
+
+	if installed, status := p.status(""); !installed || status != "unlocked" {
+		t.Fatalf("expected a usable store, got %v %q", installed, status)
+	}
+	refs, err := p.listItems("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, r := range refs {
+		ids = append(ids, r.ID)
+	}
+	if got := strings.Join(ids, " "); got != "broken personal work/keycloak/dev work/okta" {
+		t.Errorf("unexpected entries %q", got)
+	}
+
+	creds, err := resolveClientCredentials("", Client{Provider: providerPass, ItemID: "work/keycloak/dev"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.ClientID != "my-client" || creds.ClientSecret != "s3cret" {
+		t.Errorf("unexpected credentials %+v", creds)
+	}
+
+	_, err = resolveClientCredentials("", Client{Provider: providerPass, ItemID: "broken"})
+	if exitCodeFor(err) != exitItemFailed || !strings.Contains(err.Error(), "pass: pass show: gpg: decryption failed: No secret key (the entry is encrypted for a key") {
+		t.Errorf("expected the gpg error with a hint, got %v", err)
+	}
+	_, err = resolveClientCredentials("", Client{Provider: providerPass, ItemID: "locked"})
+	if !isProviderLocked(err) || !strings.Contains(err.Error(), "No pinentry (gpg-agent could not ask for the passphrase") {
+		t.Errorf("expected a key gpg-agent could not unlock to count as locked, got %v", err)
+	}
+	_, err = resolveClientCredentials("", Client{Provider: providerPass, ItemID: "missing"})
+	if err == nil || !strings.HasSuffix(err.Error(), "pass show: missing is not in the password store.") {
+		t.Errorf("expected pass's error, got %v", err)
+	}
+	// An entry named like a flag is still looked up as an entry
+	_, err = resolveClientCredentials("", Client{Provider: providerPass, ItemID: "--help"})
+	if err == nil || !strings.HasSuffix(err.Error(), "--help is not in the password store.") {
+		t.Errorf("expected the entry to be looked up, got %v", err)
+	}
+
+	if err := os.Remove(filepath.Join(store, ".gpg-id")); err != nil {
+		t.Fatal(err)
+	}
+	if err := requireProvider("", Client{Provider: providerPass}); !isProviderLocked(err) || !strings.Contains(err.Error(), "pass init") {
+		t.Errorf("expected a hint to set up the store, got %v", err)
+	}
+}
+
+func TestGopassListItems(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake gopass script needs a POSIX shell")
+	}
+	bin := t.TempDir()
+	script := "#!/bin/sh\n[ \"$1 $2\" = \"ls --flat\" ] || exit 1\nprintf 'personal\\r\\nwork/my api client\\n\\n  work/okta \\n'\n"
+	if err := os.WriteFile(filepath.Join(bin, "gopass"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	refs, err := passProvider{command: providerGopass}.listItems("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, r := range refs {
+		ids = append(ids, r.ID)
+	}
+	if got := strings.Join(ids, "|"); got != "personal|work/my api client|work/okta" {
+		t.Errorf("expected one entry per line, got %q", got)
+	}
+}
+
+func TestPassItemPickerBrowsesTree(t *testing.T) {
+	fakePass(t)
+	p := passProvider{command: providerPass}
+	m := initialModel("")
+	m.useProvider(p)
+	result, _ := m.Update(checkProviderStatus(p, "")())
+	m = result.(model)
+	result, _ = m.Update(fetchProviderItems(p, "")())
+	m = result.(model)
+	m.formClient = &Client{}
+	m.openProviderFolder("")
+	m.mode = providerSelectView
+
+	names := func() string {
+		var names []string
+		for _, item := range m.providerSelectList.Items() {
+			names = append(names, item.(secretRef).Name)
+		}
+		return strings.Join(names, " ")
+	}
+	if got := names(); got != "work/ broken personal" {
+		t.Fatalf("expected folders first, got %q", got)
+	}
+
+	result, _ = m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	m = result.(model)
+	if got := names(); got != "keycloak/ okta" || m.providerSelectList.Title != "Select pass Item: work/" {
+		t.Fatalf("expected the work folder, got %q in %q", got, m.providerSelectList.Title)
+	}
+	result, _ = m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	m = result.(model)
+	result, _ = m.Update(tea.KeyMsg{Type: tea.KeyBackspace})
+	m = result.(model)
+	if got := names(); got != "keycloak/ okta" {
+		t.Fatalf("expected backspace to go up to work, got %q", got)
+	}
+
+	result, _ = m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	m = result.(model)
+	result, _ = m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	m = result.(model)
+	if m.mode != formView || m.formClient.Provider != providerPass || m.formClient.ItemID != "work/keycloak/dev" || m.formClient.Name != "dev" {
+		t.Errorf("expected the entry to be picked, got %v %+v", m.mode, *m.formClient)
+	}
+}
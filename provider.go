@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const providerBitwarden = "bitwarden"
+
+// secretProvider is a secret store that client credentials are resolved
+// from. Methods get the session tkz holds (BW_SESSION for Bitwarden);
+// providers that keep their own sign-in ignore it.
+type secretProvider interface {
+	info() providerInfo
+	// status reports whether the provider's CLI is installed and the
+	// store is "unlocked", "locked" or "unauthenticated". Stores that
+	// cannot be unlocked with a password from tkz never report "locked".
+	status(session string) (installed bool, status string)
+	// unlock unlocks the store with a password and returns the session
+	// for the other methods, or "" if the provider keeps it itself
+	unlock(password string) (string, error)
+	// listItems lists the items offered when configuring a client
+	listItems(session string) ([]secretRef, error)
+	// item loads an item so its fields can be resolved
+	item(session, id string) (secretItem, error)
+}
+
+// secretItem is a loaded item whose fields are resolved by path, e.g.
+// login.password for Bitwarden
+type secretItem interface {
+	field(path string) (string, error)
+}
+
+// providerInfo names a provider and tells the user how to set it up
+type providerInfo struct {
+	name    string // key in clients.json
+	label   string // shown in the TUI, e.g. "Bitwarden"
+	command string // the CLI tkz runs, e.g. "bw"
+	install string // shell command installing the CLI
+	login   string // shell command signing in
+	unlock  string // shell command unlocking for headless commands
+	prompt  string // what unlock asks for, e.g. "Master password"
+
+	// Field paths a client can point at, and the defaults for the client
+	// ID and secret and for the password grant's username and password
+	fields        string
+	idField       string
+	secretField   string
+	userField     string
+	passwordField string
+
+	// Item IDs are slash-separated paths, browsed as folders in the picker
+	tree bool
+}
+
+// secretRef is an item offered when picking a client's secrets
+type secretRef struct {
+	ID     string
+	Name   string
+	Detail string // shown below the name, e.g. the login username
+	URL    string // prefills the issuer of a new client
+	Folder bool   // a folder of a tree provider, opened instead of picked
+}
+
+// Title implements list.Item
+func (r secretRef) Title() string { return r.Name }
+
+// Description implements list.Item
+func (r secretRef) Description() string {
+	if r.Detail != "" {
+		return r.Detail
+	}
+	return r.ID
+}
+
+// FilterValue implements list.Item
+func (r secretRef) FilterValue() string { return r.Name }
+
+// secretProviders are the available providers, the default first
+var secretProviders = []secretProvider{
+	bitwardenProvider{},
+	&onePasswordProvider{},
+	passProvider{command: providerPass},
+	passProvider{command: providerGopass},
+	vaultProvider{},
+}
+
+// clientConfigurable is implemented by providers with per-client settings
+type clientConfigurable interface {
+	forClient(client Client) secretProvider
+}
+
+// folderItems lists the entries and subfolders directly inside folder,
+// folders first, for providers whose item IDs are slash-separated paths
+func folderItems(refs []secretRef, folder string) []secretRef {
+	prefix := ""
+	if folder != "" {
+		prefix = folder + "/"
+	}
+	var folders, entries []secretRef
+	counts := map[string]int{}
+	for _, r := range refs {
+		rest, ok := strings.CutPrefix(r.ID, prefix)
+		if !ok || rest == "" {
+			continue
+		}
+		if name, _, isDir := strings.Cut(rest, "/"); isDir {
+			if counts[name] == 0 {
+				folders = append(folders, secretRef{ID: prefix + name, Name: name + "/", Folder: true})
+			}
+			counts[name]++
+			continue
+		}
+		r.Name = rest
+		entries = append(entries, r)
+	}
+	for i, f := range folders {
+		n := counts[strings.TrimSuffix(f.Name, "/")]
+		folders[i].Detail = fmt.Sprintf("%d entries", n)
+		if n == 1 {
+			folders[i].Detail = "1 entry"
+		}
+	}
+	return append(folders, entries...)
+}
+
+// providerFor looks up a provider by its clients.json name; "" is the
+// default, so clients from before providers existed use Bitwarden
+func providerFor(name string) (secretProvider, error) {
+	if name == "" {
+		return secretProviders[0], nil
+	}
+	var names []string
+	for _, p := range secretProviders {
+		if p.info().name == name {
+			return p, nil
+		}
+		names = append(names, p.info().name)
+	}
+	return nil, stageErr(stageResolve, "unknown secret provider %q (use %s)", name, strings.Join(names, ", "))
+}
+
+// clientProvider is the provider of client, with the client's settings
+func clientProvider(client Client) (secretProvider, error) {
+	p, err := providerFor(client.Provider)
+	if err != nil {
+		return nil, err
+	}
+	if c, ok := p.(clientConfigurable); ok {
+		return c.forClient(client), nil
+	}
+	return p, nil
+}
+
+// providerKey is the name stored in a client's provider field, empty for
+// the default provider
+func providerKey(p secretProvider) string {
+	if p.info().name == secretProviders[0].info().name {
+		return ""
+	}
+	return p.info().name
+}
+
+// nextProvider is the provider after p, wrapping around to the first
+func nextProvider(p secretProvider) secretProvider {
+	for i, q := range secretProviders {
+		if sameProvider(p, q) {
+			return secretProviders[(i+1)%len(secretProviders)]
+		}
+	}
+	return secretProviders[0]
+}
+
+// sameProvider reports whether a and b are the same provider
+func sameProvider(a, b secretProvider) bool {
+	return a != nil && b != nil && a.info().name == b.info().name
+}
+
+// loadItem loads an item from a provider, tagging failures with stageProvider
+func loadItem(session string, p secretProvider, id string) (secretItem, error) {
+	item, err := p.item(session, id)
+	if err != nil {
+		return nil, stageErr(stageProvider, "%s: %w", p.info().name, err)
+	}
+	return item, nil
+}
+
+// firstProviderClient returns the first client of a client's token exchange subject
+// chain, starting with the client itself, that reads from a provider
+func firstProviderClient(client Client, clients []Client) (Client, bool) {
+	var found Client
+	ok := anyInSubjectChain(client, clients, func(c Client) bool {
+		found = c
+		return c.needsProvider()
+	})
+	return found, ok
+}
+
+// requireChainProvider runs requireProvider for each client of a client's token
+// exchange subject chain that reads from a provider
+func requireChainProvider(session string, client Client, clients []Client) error {
+	var err error
+	anyInSubjectChain(client, clients, func(c Client) bool {
+		if c.needsProvider() {
+			err = requireProvider(session, c)
+		}
+		return err != nil
+	})
+	return err
+}
+
+// requireProvider checks without prompting that the provider of client is
+// usable
+func requireProvider(session string, client Client) error {
+	p, err := clientProvider(client)
+	if err != nil {
+		return err
+	}
+	info := p.info()
+	installed, status := p.status(session)
+	switch {
+	case !installed:
+		return &cliError{code: exitProviderLocked, err: fmt.Errorf("%s CLI not found", info.command)}
+	case status == "unlocked":
+		return nil
+	case status == "locked":
+		return &cliError{code: exitProviderLocked, err: fmt.Errorf("vault locked (run: %s)", info.unlock)}
+	default:
+		return &cliError{code: exitProviderLocked, err: fmt.Errorf("not logged in to %s (run: %s)", info.label, info.login)}
+	}
+}
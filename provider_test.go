@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// fakeProvider is an always unlocked provider serving items from memory
+type fakeProvider struct {
+	name  string
+	items map[string]map[string]string
+}
+
+func (p fakeProvider) info() providerInfo {
+	return providerInfo{
+		name:          p.name,
+		label:         "Fake",
+		command:       "fake",
+		prompt:        "Password",
+		fields:        "<name>",
+		idField:       "id",
+		secretField:   "secret",
+		userField:     "user",
+		passwordField: "password",
+	}
+}
+
+func (p fakeProvider) status(string) (bool, string) { return true, "unlocked" }
+
+func (p fakeProvider) unlock(string) (string, error) { return "", nil }
+
+func (p fakeProvider) listItems(string) ([]secretRef, error) {
+	var refs []secretRef
+	for id := range p.items {
+		refs = append(refs, secretRef{ID: id, Name: strings.ToUpper(id), URL: "https://auth.example.com"})
+	}
+	slices.SortFunc(refs, func(a, b secretRef) int { return strings.Compare(a.ID, b.ID) })
+	return refs, nil
+}
+
+func (p fakeProvider) item(_, id string) (secretItem, error) {
+	fields, ok := p.items[id]
+	if !ok {
+		return nil, fmt.Errorf("item %q not found", id)
+	}
+	return fakeItem(fields), nil
+}
+
+type fakeItem map[string]string
+
+func (i fakeItem) field(path string) (string, error) {
+	v, ok := i[path]
+	if !ok {
+		return "", fmt.Errorf("no field %q", path)
+	}
+	return v, nil
+}
+
+// useFakeProvider registers p after the built-in providers for the test
+func useFakeProvider(t *testing.T, p fakeProvider) {
+	t.Helper()
+	prev := secretProviders
+	secretProviders = append(slices.Clip(prev), p)
+	t.Cleanup(func() { secretProviders = prev })
+}
+
+func TestProviderFor(t *testing.T) {
+	useFakeProvider(t, fakeProvider{name: "fake"})
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"", providerBitwarden},
+		{providerBitwarden, providerBitwarden},
+		{"fake", "fake"},
+	}
+	for _, tt := range tests {
+		p, err := providerFor(tt.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.info().name != tt.want {
+			t.Errorf("providerFor(%q) = %s, want %s", tt.name, p.info().name, tt.want)
+		}
+	}
+
+	_, err := providerFor("keepass")
+	if err == nil || !strings.Contains(err.Error(), "(use bitwarden, ") || !strings.HasSuffix(err.Error(), ", fake)") {
+		t.Errorf("expected an unknown provider error listing the providers, got %v", err)
+	}
+	if providerKey(secretProviders[0]) != "" || providerKey(fakeProvider{name: "fake"}) != "fake" {
+		t.Error("expected only non-default providers to be stored")
+	}
+}
+
+func TestResolveClientCredentialsFromProvider(t *testing.T) {
+	useFakeProvider(t, fakeProvider{name: "fake", items: map[string]map[string]string{
+		"api":  {"id": "my-client", "secret": "s3cret", "other": "alt-secret"},
+		"user": {"user": "alice", "password": "hunter2"},
+	}})
+
+	creds, err := resolveClientCredentials("", Client{Provider: "fake", ItemID: "api"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.ClientID != "my-client" || creds.ClientSecret != "s3cret" {
+		t.Errorf("expected the provider's default fields, got %+v", creds)
+	}
+
+	creds, err = resolveClientCredentials("", Client{Provider: "fake", ItemID: "api", ClientSecretField: "other"})
+	if err != nil || creds.ClientSecret != "alt-secret" {
+		t.Errorf("expected the configured secret field, got %+v, %v", creds, err)
+	}
+
+	username, password, err := resolveUserCredentials("", Client{Provider: "fake", UserItemID: "user"})
+	if err != nil || username != "alice" || password != "hunter2" {
+		t.Errorf("unexpected user credentials %q/%q: %v", username, password, err)
+	}
+
+	_, err = resolveClientCredentials("", Client{Provider: "fake", ItemID: "missing"})
+	if exitCodeFor(err) != exitItemFailed || isProviderLocked(err) || !strings.HasPrefix(err.Error(), "fake: ") {
+		t.Errorf("expected a failed item rather than a locked vault, got %v", err)
+	}
+	_, err = resolveClientCredentials("", Client{Provider: "nope", ItemID: "api"})
+	if exitCodeFor(err) != exitError {
+		t.Errorf("expected an unknown provider to be a plain error, got %v", err)
+	}
+}
+
+func TestClientProviderJSON(t *testing.T) {
+	// Files from before providers existed load as Bitwarden clients
+	var c Client
+	if err := json.Unmarshal([]byte(`{"name":"kc","bitwarden_item_id":"abc-123","issuer":"https://auth.example.com","scopes":""}`), &c); err != nil {
+		t.Fatal(err)
+	}
+	if c.Provider != "" || c.ItemID != "abc-123" {
+		t.Errorf("unexpected client %+v", c)
+	}
+	data, err := json.Marshal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "provider") {
+		t.Errorf("expected Bitwarden clients to be saved without a provider, got %s", data)
+	}
+}
+
+func TestSelectItemFromOtherProvider(t *testing.T) {
+	fake := fakeProvider{name: "fake", items: map[string]map[string]string{"api": {}}}
+	useFakeProvider(t, fake)
+	m := initialModel("")
+	m.provider = secretProviders[len(secretProviders)-2]
+	m.providerChecking = false
+	m.providerUnlocked = true
+	m.formClient = &Client{}
+	m.mode = providerSelectView
+
+	result, cmd := m.Update(tea.KeyMsg{Type: tea.KeyTab})
+	m = result.(model)
+	if cmd == nil || m.provider.info().name != "fake" || m.pendingAction != "add" || !m.providerChecking {
+		t.Fatalf("expected a switch to the fake provider, got %s", m.provider.info().name)
+	}
+	if !strings.Contains(m.View(), "Connecting to Fake") {
+		t.Errorf("expected the status check to show, got:\n%s", m.View())
+	}
+
+	result, _ = m.Update(checkProviderStatus(fake, "")())
+	m = result.(model)
+	result, _ = m.Update(fetchProviderItems(fake, "")())
+	m = result.(model)
+	if m.mode != providerSelectView || m.providerSelectList.Title != "Select Fake Item" || len(m.providerSelectList.Items()) != 1 {
+		t.Fatalf("expected the fake provider's items, got %v", m.mode)
+	}
+
+	result, _ = m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	m = result.(model)
+	if m.mode != formView {
+		t.Fatalf("expected the client form, got %v", m.mode)
+	}
+	want := Client{Name: "API", Provider: "fake", ItemID: "api", Issuer: "https://auth.example.com"}
+	if *m.formClient != want {
+		t.Errorf("expected %+v, got %+v", want, *m.formClient)
+	}
+}
+
+func TestStaleProviderStatusIgnored(t *testing.T) {
+	useFakeProvider(t, fakeProvider{name: "fake"})
+	m := initialModel("")
+	m.useProvider(fakeProvider{name: "fake"})
+
+	result, _ := m.Update(providerStatusMsg{provider: providerBitwarden, installed: false})
+	m = result.(model)
+	if !m.providerChecking || m.mode == providerLoginView {
+		t.Error("expected the status of the previous provider to be ignored")
+	}
+}
@@ -0,0 +1,638 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// defaultProxyListen is where `tkz proxy` listens without --listen
+const defaultProxyListen = "127.0.0.1:8080"
+
+// maxProxyLog is how many request lines the TUI proxy view keeps
+const maxProxyLog = 500
+
+// proxyRoute sends requests below prefix upstream with the client's token.
+// Routes on the default upstream keep the path; routes with their own
+// upstream replace the prefix with its path, like nginx's proxy_pass with a URI.
+type proxyRoute struct {
+	prefix   string
+	client   string
+	upstream *url.URL
+	strip    bool
+}
+
+// parseProxyRoute parses a --route value: <prefix>=<client>[@<upstream>].
+// Routes without an upstream get one from withDefaultUpstream.
+func parseProxyRoute(s string) (proxyRoute, error) {
+	prefix, rest, ok := strings.Cut(s, "=")
+	if !ok || !strings.HasPrefix(prefix, "/") || rest == "" {
+		return proxyRoute{}, fmt.Errorf("invalid route %q (use /prefix=client or /prefix=client@https://upstream)", s)
+	}
+	route := proxyRoute{prefix: prefix, client: rest}
+	if client, upstream, ok := strings.Cut(rest, "@"); ok {
+		u, err := parseUpstream(upstream)
+		if err != nil {
+			return proxyRoute{}, fmt.Errorf("route %s: %w", prefix, err)
+		}
+		route.client, route.upstream, route.strip = client, u, true
+	}
+	if route.client == "" {
+		return proxyRoute{}, fmt.Errorf("route %s: no client", prefix)
+	}
+	return route, nil
+}
+
+// parseUpstream accepts HTTPS upstreams, and plain HTTP only on loopback,
+// so tokens never cross the network in the clear
+func parseUpstream(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid upstream %q (use https://host[/path])", s)
+	}
+	switch u.Scheme {
+	case "https":
+		return u, nil
+	case "http":
+		if isLoopbackHost(u.Hostname()) {
+			return u, nil
+		}
+		return nil, fmt.Errorf("upstream %s must use HTTPS (plain HTTP is only allowed on localhost)", s)
+	}
+	return nil, fmt.Errorf("invalid upstream %q (use https://host[/path])", s)
+}
+
+// listenProxy listens on a loopback address only: anyone who can reach the
+// proxy makes requests with the client's token
+func listenProxy(addr string) (net.Listener, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid listen address %q (use 127.0.0.1:<port>)", addr)
+	}
+	if !isLoopbackHost(host) {
+		return nil, fmt.Errorf("listen address %s is not a loopback address; the proxy adds your token to every request it receives (use 127.0.0.1:<port>)", addr)
+	}
+	return net.Listen("tcp", addr)
+}
+
+func isLoopbackHost(host string) bool {
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// matches reports whether path is the route's prefix or below it
+func (r proxyRoute) matches(path string) bool {
+	if r.prefix == "/" || path == r.prefix {
+		return true
+	}
+	return strings.HasPrefix(path, strings.TrimSuffix(r.prefix, "/")+"/")
+}
+
+// proxyLogEntry is one proxied request, for the TUI or stdout
+type proxyLogEntry struct {
+	Time     time.Time
+	Method   string
+	Path     string
+	Client   string
+	Status   int
+	Duration time.Duration
+	Err      error
+}
+
+func (e proxyLogEntry) String() string {
+	line := fmt.Sprintf("%s %s %s", e.Time.Format("15:04:05"), e.Method, e.Path)
+	if e.Err != nil {
+		return fmt.Sprintf("%s → %d %s: %v", line, e.Status, e.Client, e.Err)
+	}
+	return fmt.Sprintf("%s → %d %s %s", line, e.Status, e.Client, e.Duration.Round(time.Millisecond))
+}
+
+// proxyTokens keeps one token per client, renewing it once it is within the
+// client's cache skew of expiring or after the upstream rejected it
+type proxyTokens struct {
+	clients []Client
+	fetch   func(client Client, force bool) (*TokenResult, error)
+
+	mu       sync.Mutex
+	locks    map[string]*sync.Mutex // Per client, so one slow grant doesn't block other routes
+	current  map[string]*TokenResult
+	rejected map[string]bool
+}
+
+func newProxyTokens(clients []Client, fetch func(Client, bool) (*TokenResult, error)) *proxyTokens {
+	return &proxyTokens{
+		clients:  clients,
+		fetch:    fetch,
+		locks:    map[string]*sync.Mutex{},
+		current:  map[string]*TokenResult{},
+		rejected: map[string]bool{},
+	}
+}
+
+func (p *proxyTokens) get(name string) (*TokenResult, error) {
+	client, ok := findClientByName(p.clients, name)
+	if !ok {
+		return nil, fmt.Errorf("client %q not found", name)
+	}
+
+	p.mu.Lock()
+	lock, ok := p.locks[name]
+	if !ok {
+		lock = &sync.Mutex{}
+		p.locks[name] = lock
+	}
+	p.mu.Unlock()
+	lock.Lock()
+	defer lock.Unlock()
+
+	p.mu.Lock()
+	result, force := p.current[name], p.rejected[name]
+	p.mu.Unlock()
+	// Tokens without a known expiry are kept until the upstream rejects them
+	if result != nil && !force {
+		if stale := result.staleAt(); stale.IsZero() || time.Now().Before(stale) {
+			return result, nil
+		}
+	}
+
+	result, err := p.fetch(client, force)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	p.current[name] = result
+	delete(p.rejected, name)
+	p.mu.Unlock()
+	return result, nil
+}
+
+// reject makes the next request for the client fetch a new token
+func (p *proxyTokens) reject(name string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.rejected[name] = true
+}
+
+// authProxy is a reverse proxy that adds each route's token to requests
+type authProxy struct {
+	routes []proxyRoute // Longest prefix first
+	tokens *proxyTokens
+	log    func(proxyLogEntry)
+	proxy  *httputil.ReverseProxy
+}
+
+// proxyTarget is what ServeHTTP resolved for rewrite and the transport
+type proxyTarget struct {
+	route  proxyRoute
+	result *TokenResult
+}
+
+type proxyTargetKey struct{}
+
+func newAuthProxy(routes []proxyRoute, tokens *proxyTokens, log func(proxyLogEntry)) *authProxy {
+	routes = append([]proxyRoute(nil), routes...)
+	sort.SliceStable(routes, func(i, j int) bool { return len(routes[i].prefix) > len(routes[j].prefix) })
+	p := &authProxy{routes: routes, tokens: tokens, log: log}
+	p.proxy = &httputil.ReverseProxy{
+		Rewrite:   p.rewrite,
+		Transport: &proxyTransport{byClient: map[string]certTransport{}},
+		// Pass streamed and server-sent event responses on as they arrive
+		FlushInterval: -1,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			http.Error(w, "tkz proxy: "+err.Error(), http.StatusBadGateway)
+		},
+	}
+	return p
+}
+
+func (p *authProxy) match(path string) (proxyRoute, bool) {
+	for _, r := range p.routes {
+		if r.matches(path) {
+			return r, true
+		}
+	}
+	return proxyRoute{}, false
+}
+
+func (p *authProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	entry := proxyLogEntry{Time: time.Now(), Method: r.Method, Path: r.URL.RequestURI()}
+	defer func() {
+		entry.Duration = time.Since(entry.Time)
+		p.log(entry)
+	}()
+
+	if status, err := checkLocalRequest(r); err != nil {
+		entry.Status, entry.Err = status, err
+		http.Error(w, "tkz proxy: "+err.Error(), entry.Status)
+		return
+	}
+
+	route, ok := p.match(r.URL.Path)
+	if !ok {
+		entry.Status, entry.Err = http.StatusNotFound, errors.New("no route")
+		http.Error(w, "tkz proxy: no route for "+r.URL.Path, entry.Status)
+		return
+	}
+	entry.Client = route.client
+
+	result, err := p.tokens.get(route.client)
+	if err != nil {
+		entry.Status, entry.Err = http.StatusBadGateway, err
+		http.Error(w, "tkz proxy: "+route.client+": "+err.Error(), entry.Status)
+		return
+	}
+
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	ctx := context.WithValue(r.Context(), proxyTargetKey{}, proxyTarget{route: route, result: result})
+	p.proxy.ServeHTTP(rec, r.WithContext(ctx))
+	entry.Status = rec.status
+	if rec.status == http.StatusUnauthorized {
+		p.tokens.reject(route.client)
+	}
+}
+
+// checkLocalRequest refuses requests a web page may have sent: a Host that
+// is not a loopback name means DNS rebinding, and an Origin of another site
+// a cross-site form or fetch. Either would be sent with the user's token.
+func checkLocalRequest(r *http.Request) (int, error) {
+	if host := (&url.URL{Host: r.Host}).Hostname(); !isLoopbackHost(host) {
+		return http.StatusMisdirectedRequest, fmt.Errorf("host %q is not a loopback address", r.Host)
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		if u, err := url.Parse(origin); err != nil || !isLoopbackHost(u.Hostname()) {
+			return http.StatusForbidden, fmt.Errorf("cross-site request from %s", origin)
+		}
+	}
+	return 0, nil
+}
+
+func (p *authProxy) rewrite(pr *httputil.ProxyRequest) {
+	target := pr.In.Context().Value(proxyTargetKey{}).(proxyTarget)
+	if target.route.strip && target.route.prefix != "/" {
+		pr.Out.URL.Path = strings.TrimPrefix(pr.Out.URL.Path, strings.TrimSuffix(target.route.prefix, "/"))
+		pr.Out.URL.RawPath = ""
+	}
+	pr.SetURL(target.route.upstream)
+
+	// The caller's credentials never reach the upstream, only the client's
+	pr.Out.Header.Set("Authorization", target.result.AuthorizationHeader())
+	pr.Out.Header.Del("DPoP")
+	if dpop := target.result.creds.dpop; dpop != nil {
+		if proof, err := dpop.proof(pr.Out.Method, pr.Out.URL.String(), target.result.Token.AccessToken); err == nil {
+			pr.Out.Header.Set("DPoP", proof)
+		}
+	}
+}
+
+// maxDPoPReplay bounds the request body kept to resend it with a DPoP nonce
+const maxDPoPReplay = 1 << 20
+
+// proxyTransport presents the client certificate of mTLS clients, whose
+// tokens are bound to it. Each client keeps one transport, replaced when a
+// renewal brings a different certificate. DPoP clients retry once with a
+// new proof when the resource server demands a nonce (RFC 9449 §9).
+type proxyTransport struct {
+	mu       sync.Mutex
+	byClient map[string]certTransport
+}
+
+// certTransport is a transport presenting the certificate with fingerprint
+type certTransport struct {
+	fingerprint [sha256.Size]byte
+	rt          *http.Transport
+}
+
+func (t *proxyTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	target, _ := r.Context().Value(proxyTargetKey{}).(proxyTarget)
+	if target.result == nil || target.result.creds.dpop == nil {
+		return t.send(r, target)
+	}
+	dpop := target.result.creds.dpop
+
+	r, err := replayable(r)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.send(r, target)
+	if err != nil {
+		return nil, err
+	}
+	nonce := resp.Header.Get("DPoP-Nonce")
+	if nonce == "" {
+		return resp, nil
+	}
+	dpop.setNonce(r.URL.String(), nonce)
+	if resp.StatusCode != http.StatusUnauthorized || !strings.Contains(resp.Header.Get("WWW-Authenticate"), "use_dpop_nonce") || r.GetBody == nil {
+		return resp, nil
+	}
+
+	retry := r.Clone(r.Context())
+	if retry.Body, err = r.GetBody(); err != nil {
+		return resp, nil
+	}
+	proof, err := dpop.proof(r.Method, r.URL.String(), target.result.Token.AccessToken)
+	if err != nil {
+		return resp, nil
+	}
+	resp.Body.Close()
+	retry.Header.Set("DPoP", proof)
+	return t.send(retry, target)
+}
+
+// replayable returns r with a body that can be sent again, or r as it is
+// when the body is too large to keep
+func replayable(r *http.Request) (*http.Request, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		out := r.Clone(r.Context())
+		out.GetBody = func() (io.ReadCloser, error) { return http.NoBody, nil }
+		return out, nil
+	}
+	if r.GetBody != nil {
+		return r, nil
+	}
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxDPoPReplay+1))
+	if err != nil {
+		return nil, err
+	}
+	out := r.Clone(r.Context())
+	if len(data) > maxDPoPReplay {
+		out.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(data), r.Body), r.Body}
+		return out, nil
+	}
+	r.Body.Close()
+	out.Body = io.NopCloser(bytes.NewReader(data))
+	out.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(data)), nil }
+	return out, nil
+}
+
+// send sends r, with the client certificate of mTLS clients
+func (t *proxyTransport) send(r *http.Request, target proxyTarget) (*http.Response, error) {
+	cert := (*tls.Certificate)(nil)
+	if target.result != nil {
+		cert = target.result.creds.certificate
+	}
+	if cert == nil || len(cert.Certificate) == 0 {
+		return httpClient.Transport.RoundTrip(r)
+	}
+
+	fingerprint := sha256.Sum256(cert.Certificate[0])
+	t.mu.Lock()
+	ct, ok := t.byClient[target.route.client]
+	if !ok || ct.fingerprint != fingerprint {
+		client, err := mtlsHTTPClient(*cert)
+		if err != nil {
+			t.mu.Unlock()
+			return nil, err
+		}
+		if ok {
+			ct.rt.CloseIdleConnections()
+		}
+		ct = certTransport{fingerprint: fingerprint, rt: client.Transport.(*http.Transport)}
+		t.byClient[target.route.client] = ct
+	}
+	t.mu.Unlock()
+	return ct.rt.RoundTrip(r)
+}
+
+// statusRecorder remembers the status code written through it
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.status = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer for flushing
+func (r *statusRecorder) Unwrap() http.ResponseWriter { return r.ResponseWriter }
+
+// withDefaultUpstream points routes without their own upstream at the
+// --upstream URL
+func withDefaultUpstream(routes []proxyRoute, upstream *url.URL) ([]proxyRoute, error) {
+	out := make([]proxyRoute, len(routes))
+	for i, r := range routes {
+		if r.upstream == nil {
+			if upstream == nil {
+				return nil, fmt.Errorf("route %s has no upstream (add @https://... or --upstream)", r.prefix)
+			}
+			r.upstream = upstream
+		}
+		out[i] = r
+	}
+	return out, nil
+}
+
+// proxyFetcher fetches proxy tokens like `tkz token` does. DPoP and mTLS
+// clients always run the pipeline here: the proxy needs their key and
+// certificate, which never leave the process that requested the token.
+func proxyFetcher(session string, clients []Client, stderr io.Writer) func(Client, bool) (*TokenResult, error) {
+	return func(client Client, force bool) (*TokenResult, error) {
+		if client.DPoP || client.TLSCertField != "" {
+			return localToken(session, clients, client, stderrPrompter{w: stderr})
+		}
+		return headlessToken(client.Name, session, force, stderr)
+	}
+}
+
+// runProxyCmd implements `tkz proxy`: a local reverse proxy that adds the
+// client's token to every request
+func runProxyCmd(args []string, session string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("proxy", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	client := fs.String("client", "", "client whose token is added to requests")
+	upstream := fs.String("upstream", "", "URL requests are forwarded to")
+	listen := fs.String("listen", defaultProxyListen, "address to listen on")
+	var routeFlags repeatedFlag
+	fs.Var(&routeFlags, "route", "path prefix mapping, as /prefix=client or /prefix=client@https://upstream (repeatable)")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: tkz proxy --client <name> --upstream <url> [--listen 127.0.0.1:8080]")
+		fmt.Fprintln(stderr, "       tkz proxy --route /prefix=<client>[@<url>] [--route ...] [--upstream <url>] [--listen ...]")
+		fmt.Fprintln(stderr)
+		fmt.Fprintln(stderr, "Forward requests to the upstream with the client's token in the Authorization")
+		fmt.Fprintln(stderr, "header, renewing it before it expires. Requests are logged to stdout.")
+		fmt.Fprintln(stderr)
+		fs.PrintDefaults()
+	}
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if len(positional) != 0 || (*client == "" && len(routeFlags) == 0) {
+		fs.Usage()
+		return exitUsage
+	}
+
+	routes, err := proxyRoutes(*client, *upstream, routeFlags)
+	if err != nil {
+		fmt.Fprintf(stderr, "tkz: %v\n", err)
+		return exitUsage
+	}
+	clients, err := loadClients()
+	if err != nil {
+		fmt.Fprintf(stderr, "tkz: load clients: %v\n", err)
+		return exitError
+	}
+	for _, r := range routes {
+		if _, err := findClient(clients, r.client); err != nil {
+			fmt.Fprintf(stderr, "tkz: %v\n", err)
+			return exitCodeFor(err)
+		}
+	}
+
+	ln, err := listenProxy(*listen)
+	if err != nil {
+		fmt.Fprintf(stderr, "tkz: %v\n", err)
+		return exitError
+	}
+	var logMu sync.Mutex
+	proxy := newAuthProxy(routes, newProxyTokens(clients, proxyFetcher(session, clients, stderr)), func(e proxyLogEntry) {
+		logMu.Lock()
+		defer logMu.Unlock()
+		fmt.Fprintln(stdout, e)
+	})
+	server := &http.Server{Handler: proxy, ReadHeaderTimeout: 10 * time.Second}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		<-signals
+		server.Close()
+	}()
+
+	for _, r := range proxy.routes {
+		fmt.Fprintf(stderr, "tkz proxy: http://%s%s → %s (%s)\n", ln.Addr(), r.prefix, r.upstream, r.client)
+	}
+	if err := server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		fmt.Fprintf(stderr, "tkz: %v\n", err)
+		return exitError
+	}
+	return exitOK
+}
+
+// proxyRoutes builds the routing table from --client, --upstream and --route
+func proxyRoutes(client, upstream string, routeFlags []string) ([]proxyRoute, error) {
+	var def *url.URL
+	if upstream != "" {
+		var err error
+		if def, err = parseUpstream(upstream); err != nil {
+			return nil, err
+		}
+	}
+	var routes []proxyRoute
+	for _, f := range routeFlags {
+		r, err := parseProxyRoute(f)
+		if err != nil {
+			return nil, err
+		}
+		routes = append(routes, r)
+	}
+	if client != "" {
+		if def == nil {
+			return nil, errors.New("--client needs --upstream")
+		}
+		routes = append(routes, proxyRoute{prefix: "/", client: client})
+	}
+	return withDefaultUpstream(routes, def)
+}
+
+// tuiProxy is a proxy started from the TUI; its log lines go to the proxy view
+type tuiProxy struct {
+	server *http.Server
+	addr   string
+	route  proxyRoute
+	logs   chan string
+	done   chan struct{}
+}
+
+// startTUIProxy parses "<upstream> [listen address]" and starts proxying to
+// it with the client's token
+func startTUIProxy(input string, session string, clients []Client, client Client) (*tuiProxy, error) {
+	fields := strings.Fields(input)
+	if len(fields) == 0 || len(fields) > 2 {
+		return nil, errors.New("enter the upstream URL and optionally a listen address")
+	}
+	upstream, err := parseUpstream(fields[0])
+	if err != nil {
+		return nil, err
+	}
+	listen := defaultProxyListen
+	if len(fields) == 2 {
+		listen = fields[1]
+	}
+	ln, err := listenProxy(listen)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &tuiProxy{
+		addr:  ln.Addr().String(),
+		route: proxyRoute{prefix: "/", client: client.Name, upstream: upstream},
+		logs:  make(chan string, 100),
+		done:  make(chan struct{}),
+	}
+	// Grant prompts and warnings land in the log instead of the terminal
+	fetch := proxyFetcher(session, clients, proxyLogWriter(p.logs))
+	handler := newAuthProxy([]proxyRoute{p.route}, newProxyTokens(clients, fetch), func(e proxyLogEntry) {
+		p.send(e.String())
+	})
+	p.server = &http.Server{Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+	go p.server.Serve(ln)
+	return p, nil
+}
+
+// send queues a log line, dropping it if the TUI is not keeping up rather
+// than stalling requests
+func (p *tuiProxy) send(line string) {
+	select {
+	case p.logs <- line:
+	default:
+	}
+}
+
+func (p *tuiProxy) stop() {
+	close(p.done)
+	p.server.Close()
+}
+
+// proxyLogWriter turns text written to it into proxy log lines
+type proxyLogWriter chan string
+
+func (w proxyLogWriter) Write(b []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(b), "\n"), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			select {
+			case w <- line:
+			default:
+			}
+		}
+	}
+	return len(b), nil
+}
@@ -0,0 +1,478 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+func TestParseProxyRoute(t *testing.T) {
+	tests := []struct {
+		in       string
+		prefix   string
+		client   string
+		upstream string
+		wantErr  bool
+	}{
+		{in: "/billing=billing-client", prefix: "/billing", client: "billing-client"},
+		{in: "/billing=billing-client@https://billing.example.com/api", prefix: "/billing", client: "billing-client", upstream: "https://billing.example.com/api"},
+		{in: "billing=client", wantErr: true},
+		{in: "/billing", wantErr: true},
+		{in: "/billing=", wantErr: true},
+		{in: "/billing=@https://billing.example.com", wantErr: true},
+		{in: "/billing=client@ftp://billing.example.com", wantErr: true},
+		{in: "/billing=client@http://billing.example.com", wantErr: true},
+		{in: "/billing=client@http://127.0.0.1:9000", prefix: "/billing", client: "client", upstream: "http://127.0.0.1:9000"},
+		{in: "/billing=client@http://localhost:9000/", prefix: "/billing", client: "client", upstream: "http://localhost:9000/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			r, err := parseProxyRoute(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %+v", r)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.prefix != tt.prefix || r.client != tt.client {
+				t.Errorf("unexpected route %+v", r)
+			}
+			if tt.upstream == "" && (r.upstream != nil || r.strip) {
+				t.Errorf("expected no upstream, got %v", r.upstream)
+			}
+			if tt.upstream != "" && (r.upstream.String() != tt.upstream || !r.strip) {
+				t.Errorf("expected stripping upstream %s, got %v", tt.upstream, r.upstream)
+			}
+		})
+	}
+}
+
+func TestProxyRoutes(t *testing.T) {
+	routes, err := proxyRoutes("api", "https://api.example.com", []string{"/billing=billing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(routes) != 2 || routes[0].upstream.Host != "api.example.com" || routes[1].prefix != "/" {
+		t.Errorf("unexpected routes %+v", routes)
+	}
+	if _, err := proxyRoutes("api", "", nil); err == nil {
+		t.Error("expected --client without --upstream to fail")
+	}
+	if _, err := proxyRoutes("", "", []string{"/billing=billing"}); err == nil {
+		t.Error("expected a route without any upstream to fail")
+	}
+}
+
+func TestProxyRouteMatches(t *testing.T) {
+	r := proxyRoute{prefix: "/billing"}
+	for path, want := range map[string]bool{
+		"/billing":         true,
+		"/billing/":        true,
+		"/billing/invoice": true,
+		"/billingx":        false,
+		"/other":           false,
+	} {
+		if got := r.matches(path); got != want {
+			t.Errorf("matches(%q) = %v, want %v", path, got, want)
+		}
+	}
+	if !(proxyRoute{prefix: "/"}).matches("/anything") {
+		t.Error("expected / to match everything")
+	}
+}
+
+// staticTokens returns a proxyTokens handing out "tok-<client>" and counting fetches
+func staticTokens(clients []Client, expiresIn int) (*proxyTokens, func() []bool) {
+	var mu sync.Mutex
+	var forced []bool
+	tokens := newProxyTokens(clients, func(c Client, force bool) (*TokenResult, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		forced = append(forced, force)
+		return &TokenResult{Token: TokenResponse{AccessToken: "tok-" + c.Name, TokenType: "Bearer", ExpiresIn: expiresIn}, Client: c, FetchedAt: time.Now()}, nil
+	})
+	return tokens, func() []bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]bool(nil), forced...)
+	}
+}
+
+func TestAuthProxy(t *testing.T) {
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Forwarded-For") != "" || r.Header.Get("X-Forwarded-Host") != "" {
+			t.Error("expected no X-Forwarded headers upstream")
+		}
+		io.WriteString(w, r.URL.Path+" "+r.Header.Get("Authorization"))
+	}))
+	defer upstream.Close()
+	useTLSServer(t, upstream)
+
+	clients := []Client{{Name: "api"}, {Name: "billing"}, {Name: "reports"}}
+	tokens, _ := staticTokens(clients, 300)
+	routes, err := proxyRoutes("api", upstream.URL, []string{"/billing=billing", "/reports=reports@" + upstream.URL + "/v2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var logged []proxyLogEntry
+	var mu sync.Mutex
+	proxy := httptest.NewServer(newAuthProxy(routes, tokens, func(e proxyLogEntry) {
+		mu.Lock()
+		defer mu.Unlock()
+		logged = append(logged, e)
+	}))
+	defer proxy.Close()
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/things", "/things Bearer tok-api"},
+		{"/billing/invoices", "/billing/invoices Bearer tok-billing"},
+		{"/reports/daily", "/v2/daily Bearer tok-reports"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet, proxy.URL+tt.path, nil)
+			req.Header.Set("Authorization", "Bearer caller-token")
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			if string(body) != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, body)
+			}
+		})
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(logged) != len(tests) {
+		t.Fatalf("expected %d log entries, got %d", len(tests), len(logged))
+	}
+	if line := logged[1].String(); !strings.Contains(line, "GET /billing/invoices → 200 billing") {
+		t.Errorf("unexpected log line %q", line)
+	}
+}
+
+func TestAuthProxyRenewsTokens(t *testing.T) {
+	status := http.StatusOK
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer upstream.Close()
+	useTLSServer(t, upstream)
+
+	clients := []Client{{Name: "api", CacheSkew: 10}}
+	get := func(tokens *proxyTokens) {
+		t.Helper()
+		routes, _ := proxyRoutes("api", upstream.URL, nil)
+		rec := httptest.NewRecorder()
+		newAuthProxy(routes, tokens, func(proxyLogEntry) {}).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://127.0.0.1:8080/", nil))
+	}
+
+	// A token well before its skew is reused
+	tokens, fetches := staticTokens(clients, 300)
+	get(tokens)
+	get(tokens)
+	if n := len(fetches()); n != 1 {
+		t.Errorf("expected 1 fetch for a fresh token, got %d", n)
+	}
+
+	// A token within the skew of expiring is renewed
+	tokens, fetches = staticTokens(clients, 5)
+	get(tokens)
+	get(tokens)
+	if n := len(fetches()); n != 2 {
+		t.Errorf("expected a renewal within the skew, got %d fetches", n)
+	}
+
+	// A rejected token is replaced, bypassing caches
+	tokens, fetches = staticTokens(clients, 300)
+	status = http.StatusUnauthorized
+	get(tokens)
+	status = http.StatusOK
+	get(tokens)
+	if got := fetches(); len(got) != 2 || got[0] || !got[1] {
+		t.Errorf("expected a forced fetch after 401, got %v", got)
+	}
+}
+
+func TestAuthProxyErrors(t *testing.T) {
+	tokens := newProxyTokens([]Client{{Name: "api"}}, func(Client, bool) (*TokenResult, error) {
+		return nil, errors.New("vault locked")
+	})
+	routes, _ := proxyRoutes("", "", []string{"/api=api@https://api.example.com"})
+	var entry proxyLogEntry
+	proxy := newAuthProxy(routes, tokens, func(e proxyLogEntry) { entry = e })
+
+	rec := httptest.NewRecorder()
+	proxy.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://127.0.0.1:8080/other", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 without a route, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	proxy.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://127.0.0.1:8080/api/things", nil))
+	if rec.Code != http.StatusBadGateway || !strings.Contains(rec.Body.String(), "vault locked") {
+		t.Errorf("expected 502 with the token error, got %d %q", rec.Code, rec.Body.String())
+	}
+	if entry.Err == nil || entry.Client != "api" {
+		t.Errorf("expected the failure to be logged, got %+v", entry)
+	}
+}
+
+func TestAuthProxyRefusesForeignRequests(t *testing.T) {
+	fetched := false
+	tokens := newProxyTokens([]Client{{Name: "api"}}, func(Client, bool) (*TokenResult, error) {
+		fetched = true
+		return nil, errors.New("no token expected")
+	})
+	routes, _ := proxyRoutes("api", "https://api.example.com", nil)
+	proxy := newAuthProxy(routes, tokens, func(proxyLogEntry) {})
+
+	tests := []struct {
+		name   string
+		host   string
+		origin string
+		want   int
+	}{
+		{"rebound host", "attacker.example:8080", "", http.StatusMisdirectedRequest},
+		{"rebound host without port", "attacker.example", "", http.StatusMisdirectedRequest},
+		{"cross-site form", "127.0.0.1:8080", "https://attacker.example", http.StatusForbidden},
+		{"opaque origin", "localhost:8080", "null", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "http://127.0.0.1:8080/things", nil)
+			req.Host = tt.host
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			rec := httptest.NewRecorder()
+			proxy.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("expected %d, got %d", tt.want, rec.Code)
+			}
+		})
+	}
+	if fetched {
+		t.Error("expected no token to be fetched for a refused request")
+	}
+
+	for _, host := range []string{"127.0.0.1:8080", "localhost:8080", "[::1]:8080", "LOCALHOST"} {
+		if status, err := checkLocalRequest(&http.Request{Host: host, Header: http.Header{}}); err != nil {
+			t.Errorf("expected %s to be accepted, got %d: %v", host, status, err)
+		}
+	}
+}
+
+func TestAuthProxyDPoP(t *testing.T) {
+	k, err := newDPoPKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var proof, auth string
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proof, auth = r.Header.Get("DPoP"), r.Header.Get("Authorization")
+	}))
+	defer upstream.Close()
+	useTLSServer(t, upstream)
+
+	tokens := newProxyTokens([]Client{{Name: "api"}}, func(c Client, _ bool) (*TokenResult, error) {
+		return &TokenResult{Token: TokenResponse{AccessToken: "tok", TokenType: "DPoP", ExpiresIn: 300}, Client: c, FetchedAt: time.Now(), creds: clientCredentials{dpop: k}}, nil
+	})
+	routes, _ := proxyRoutes("api", upstream.URL, nil)
+	rec := httptest.NewRecorder()
+	newAuthProxy(routes, tokens, func(proxyLogEntry) {}).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "http://127.0.0.1:8080/orders?x=1", nil))
+
+	if auth != "DPoP tok" {
+		t.Errorf("expected DPoP authorization, got %q", auth)
+	}
+	claims, err := decodeJWTClaims(proof)
+	if err != nil {
+		t.Fatalf("expected a DPoP proof, got %q: %v", proof, err)
+	}
+	if claims["htm"] != "POST" || claims["htu"] != upstream.URL+"/orders" || claims["ath"] == nil {
+		t.Errorf("unexpected proof claims %v", claims)
+	}
+}
+
+func TestAuthProxyDPoPNonce(t *testing.T) {
+	k, err := newDPoPKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var nonces, bodies []string
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := decodeJWTClaims(r.Header.Get("DPoP"))
+		nonce, _ := claims["nonce"].(string)
+		body, _ := io.ReadAll(r.Body)
+		nonces, bodies = append(nonces, nonce), append(bodies, string(body))
+		if nonce != "n-1" {
+			w.Header().Set("DPoP-Nonce", "n-1")
+			w.Header().Set("WWW-Authenticate", `DPoP error="use_dpop_nonce", error_description="Resource server requires nonce in DPoP proof"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		io.WriteString(w, "ok")
+	}))
+	defer upstream.Close()
+	useTLSServer(t, upstream)
+
+	var forced []bool
+	tokens := newProxyTokens([]Client{{Name: "api"}}, func(c Client, force bool) (*TokenResult, error) {
+		forced = append(forced, force)
+		return &TokenResult{Token: TokenResponse{AccessToken: "tok", TokenType: "DPoP", ExpiresIn: 300}, Client: c, FetchedAt: time.Now(), creds: clientCredentials{dpop: k}}, nil
+	})
+	routes, _ := proxyRoutes("api", upstream.URL, nil)
+	proxy := newAuthProxy(routes, tokens, func(proxyLogEntry) {})
+	rec := httptest.NewRecorder()
+	proxy.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "http://127.0.0.1:8080/orders", strings.NewReader(`{"n":1}`)))
+
+	if rec.Code != http.StatusOK || rec.Body.String() != "ok" {
+		t.Errorf("expected the retry with the nonce to succeed, got %d %q", rec.Code, rec.Body.String())
+	}
+	if strings.Join(nonces, ",") != ",n-1" || strings.Join(bodies, ",") != `{"n":1},{"n":1}` {
+		t.Errorf("expected one retry with the nonce and the same body, got nonces %q, bodies %q", nonces, bodies)
+	}
+
+	// The token was accepted, so the next request reuses it with the nonce
+	proxy.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://127.0.0.1:8080/orders", nil))
+	if len(forced) != 1 || len(nonces) != 3 || nonces[2] != "n-1" {
+		t.Errorf("expected the token and nonce to be reused, got fetches %v, nonces %q", forced, nonces)
+	}
+}
+
+func TestProxyView(t *testing.T) {
+	m := initialModel("")
+	m.clients = []Client{{Name: "api"}}
+	m.list.SetItems(clientsToItems(m.clients))
+	m.providerUnlocked = true
+
+	result, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'P'}})
+	m = result.(model)
+	if m.mode != proxyInputView {
+		t.Fatalf("expected proxyInputView, got %v", m.mode)
+	}
+
+	m.proxyInput.SetValue("ftp://nope")
+	result, _ = m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	m = result.(model)
+	if m.mode != proxyInputView || m.statusMsg == "" {
+		t.Fatalf("expected to stay in the prompt with an error, got %v / %q", m.mode, m.statusMsg)
+	}
+
+	m.proxyInput.SetValue("https://api.example.com 127.0.0.1:0")
+	result, cmd := m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	m = result.(model)
+	if m.mode != proxyView || m.proxy == nil || cmd == nil {
+		t.Fatalf("expected a running proxy, got %v", m.mode)
+	}
+	p := m.proxy
+
+	result, _ = m.Update(proxyLogMsg{line: "12:00:00 GET / → 200 api 3ms"})
+	m = result.(model)
+	if !strings.Contains(m.View(), "GET / → 200 api") {
+		t.Error("expected the log line in the proxy view")
+	}
+
+	result, _ = m.Update(tea.KeyMsg{Type: tea.KeyEsc})
+	m = result.(model)
+	if m.mode != listView || m.proxy != nil {
+		t.Errorf("expected esc to stop the proxy, got %v", m.mode)
+	}
+	if _, err := http.Get("http://" + p.addr); err == nil {
+		t.Error("expected the proxy to stop listening")
+	}
+}
+
+func TestListenProxy(t *testing.T) {
+	tests := []struct {
+		addr    string
+		wantErr string
+	}{
+		{"127.0.0.1:0", ""},
+		{"localhost:0", ""},
+		{":0", "not a loopback address"},
+		{"0.0.0.0:0", "not a loopback address"},
+		{"192.0.2.1:8080", "not a loopback address"},
+		{"8080", "invalid listen address"},
+	}
+	for _, tt := range tests {
+		ln, err := listenProxy(tt.addr)
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("listenProxy(%q): %v", tt.addr, err)
+				continue
+			}
+			ln.Close()
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("listenProxy(%q): expected error containing %q, got %v", tt.addr, tt.wantErr, err)
+			if ln != nil {
+				ln.Close()
+			}
+		}
+	}
+
+	if _, err := startTUIProxy("https://api.example.com 0.0.0.0:0", "", nil, Client{Name: "api"}); err == nil {
+		t.Error("expected the TUI proxy to refuse a non-loopback address")
+	}
+}
+
+func TestProxyTransportKeepsOneTransportPerClient(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	useTLSServer(t, server)
+
+	newCert := func() *tls.Certificate {
+		certPEM, keyPEM := newTestClientCert(t)
+		cert, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &cert
+	}
+	transport := &proxyTransport{byClient: map[string]certTransport{}}
+	roundTrip := func(client string, cert *tls.Certificate) *http.Transport {
+		t.Helper()
+		target := proxyTarget{route: proxyRoute{client: client}, result: &TokenResult{creds: clientCredentials{certificate: cert}}}
+		req, _ := http.NewRequestWithContext(context.WithValue(context.Background(), proxyTargetKey{}, target), http.MethodGet, server.URL, nil)
+		resp, err := transport.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return transport.byClient[client].rt
+	}
+
+	cert := newCert()
+	first := roundTrip("api", cert)
+	renewed := *cert
+	if roundTrip("api", &renewed) != first {
+		t.Error("expected the same certificate from a renewal to reuse the transport")
+	}
+	if roundTrip("api", newCert()) == first {
+		t.Error("expected a new certificate to get a new transport")
+	}
+	roundTrip("other", cert)
+	if len(transport.byClient) != 2 {
+		t.Errorf("expected one transport per client, got %d", len(transport.byClient))
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// revocation is one token to revoke, with its token_type_hint
+type revocation struct {
+	token string
+	hint  string
+}
+
+// name describes the token for messages, e.g. "refresh token"
+func (r revocation) name() string {
+	if r.hint == "" {
+		return "token"
+	}
+	return strings.ReplaceAll(r.hint, "_", " ")
+}
+
+// revocationsFor lists the tokens of a result to revoke: the refresh token
+// first, since once it is gone no new access tokens can be minted from it
+func revocationsFor(token TokenResponse, access, refresh bool) []revocation {
+	var rs []revocation
+	if refresh && token.RefreshToken != "" {
+		rs = append(rs, revocation{token.RefreshToken, hintRefreshToken})
+	}
+	if access && token.AccessToken != "" {
+		rs = append(rs, revocation{token.AccessToken, hintAccessToken})
+	}
+	return rs
+}
+
+// revokeToken asks the authorization server to revoke a token (RFC 7009).
+// Servers answer 200 for tokens that are already invalid, so success only
+// means the token is not usable anymore.
+func revokeToken(endpoint string, creds clientCredentials, token, hint string) error {
+	if !strings.HasPrefix(endpoint, "https://") {
+		return fmt.Errorf("revocation endpoint must use HTTPS: %s", endpoint)
+	}
+	data := url.Values{"token": {token}}
+	if hint != "" {
+		data.Set("token_type_hint", hint)
+	}
+
+	resp, err := postAuthenticatedForm(endpoint, creds, data)
+	if err != nil {
+		return fmt.Errorf("revocation request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("revocation endpoint returned status %d: %s", resp.StatusCode, body)
+	}
+	return nil
+}
+
+// revoke revokes tokens with the credentials of the client they belong to.
+// A failure does not stop the others; the tokens that were revoked are
+// returned either way, so callers can forget them.
+func revoke(session string, result TokenResult, tokens []revocation) ([]revocation, error) {
+	creds, oidc, err := clientEndpoints(session, result)
+	if err != nil {
+		return nil, err
+	}
+	if oidc.RevocationEndpoint == "" {
+		return nil, stageErr(stageDiscovery, "oidc discovery: response missing revocation_endpoint")
+	}
+	var revoked []revocation
+	var errs []error
+	for _, r := range tokens {
+		if err := revokeToken(oidc.RevocationEndpoint, creds, r.token, r.hint); err != nil {
+			errs = append(errs, stageErr(stageToken, "revoke %s: %w", r.name(), err))
+			continue
+		}
+		revoked = append(revoked, r)
+	}
+	return revoked, errors.Join(errs...)
+}
+
+// forgetRevoked drops revoked tokens from the disk cache and a running
+// agent, so nothing hands them out again
+func forgetRevoked(settings Settings, session string, tokens []revocation) error {
+	cache, err := openDiskCache(settings, session)
+	var errs []error
+	if err != nil {
+		errs = append(errs, err)
+	}
+	for _, r := range tokens {
+		if cache != nil {
+			if err := cache.forget(r.token); err != nil {
+				errs = append(errs, fmt.Errorf("disk cache: %w", err))
+			}
+		}
+		if _, err := callAgent(agentRequest{Op: agentOpForget, Token: r.token}); err != nil && !errors.Is(err, errAgentNotRunning) {
+			errs = append(errs, fmt.Errorf("agent: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// runRevokeCmd implements `tkz revoke <client>`: it reads a token from stdin
+// and revokes it with the client's credentials
+func runRevokeCmd(args []string, session string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("revoke", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	hint := fs.String("hint", "", "token_type_hint for a bare token: access_token or refresh_token")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: tkz revoke <client> [--hint access_token|refresh_token] < token")
+		fmt.Fprintln(stderr)
+		fmt.Fprintln(stderr, "Revoke a token from stdin with the named client's credentials. Given the")
+		fmt.Fprintln(stderr, "JSON from `tkz token -o json`, both the refresh and the access token are")
+		fmt.Fprintln(stderr, "revoked. Cached copies are dropped from the disk cache and the agent.")
+		fmt.Fprintln(stderr)
+		fs.PrintDefaults()
+	}
+
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if len(positional) != 1 {
+		fs.Usage()
+		return exitUsage
+	}
+	if *hint != "" && *hint != hintAccessToken && *hint != hintRefreshToken {
+		fmt.Fprintf(stderr, "tkz: unknown token type hint %q (use %s or %s)\n", *hint, hintAccessToken, hintRefreshToken)
+		return exitUsage
+	}
+
+	data, err := io.ReadAll(stdin)
+	if err != nil {
+		fmt.Fprintf(stderr, "tkz: read stdin: %v\n", err)
+		return exitError
+	}
+	tokens := parseRevokeInput(data, *hint)
+	if len(tokens) == 0 {
+		fmt.Fprintln(stderr, "tkz: no token given on stdin")
+		return exitUsage
+	}
+
+	f, err := loadClientsFile(getClientsPath())
+	if err != nil {
+		fmt.Fprintf(stderr, "tkz: load clients: %v\n", err)
+		return exitError
+	}
+	client, err := findClient(f.Clients, positional[0])
+	if err == nil && client.needsProvider() {
+		err = requireProvider(session, client)
+	}
+	var revoked []revocation
+	if err == nil {
+		revoked, err = revoke(session, TokenResult{Client: client}, tokens)
+	}
+	for _, r := range revoked {
+		fmt.Fprintf(stdout, "Revoked %s\n", r.name())
+	}
+	// Forget what was revoked even if another token failed
+	if len(revoked) > 0 {
+		if err := forgetRevoked(f.Settings, session, revoked); err != nil {
+			fmt.Fprintf(stderr, "tkz: warning: %v\n", err)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "tkz: %v\n", err)
+		return exitCodeFor(err)
+	}
+	return exitOK
+}
+
+// parseRevokeInput reads the tokens to revoke from stdin: the refresh and
+// access token from the JSON printed by `tkz token -o json`, or a bare
+// token with the given hint
+func parseRevokeInput(data []byte, hint string) []revocation {
+	trimmed := strings.TrimSpace(string(data))
+	if strings.HasPrefix(trimmed, "{") {
+		var v TokenResponse
+		if json.Unmarshal(data, &v) == nil {
+			return revocationsFor(v, true, true)
+		}
+	}
+	if trimmed == "" {
+		return nil
+	}
+	return []revocation{{trimmed, hint}}
+}
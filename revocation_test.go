@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// revocationRecorder accepts revocations with client_secret_basic
+// credentials and records the revoked tokens as "hint:token"
+func revocationRecorder(t *testing.T) (http.HandlerFunc, func() []string) {
+	var mu sync.Mutex
+	var revoked []string
+	return func(w http.ResponseWriter, r *http.Request) {
+			if id, secret, ok := r.BasicAuth(); !ok || id != "my-client" || secret != "s3cret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if err := r.ParseForm(); err != nil {
+				t.Fatal(err)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			revoked = append(revoked, r.PostForm.Get("token_type_hint")+":"+r.PostForm.Get("token"))
+		}, func() []string {
+			mu.Lock()
+			defer mu.Unlock()
+			return append([]string(nil), revoked...)
+		}
+}
+
+func TestRevoke(t *testing.T) {
+	handler, revoked := revocationRecorder(t)
+	server := newFakeEndpointIssuer(t, "revocation_endpoint", handler)
+	result := testIntrospectionResult(server.URL)
+	result.Token.RefreshToken = "rt"
+
+	if done, err := revoke("", result, revocationsFor(result.Token, true, true)); err != nil || len(done) != 2 {
+		t.Fatalf("expected both tokens to be revoked, got %v, %v", done, err)
+	}
+	if got := strings.Join(revoked(), " "); got != "refresh_token:rt access_token:tok" {
+		t.Errorf("expected the refresh token to be revoked first, got %q", got)
+	}
+
+	result.creds.ClientSecret = "wrong"
+	done, err := revoke("", result, revocationsFor(result.Token, true, false))
+	if exitCodeFor(err) != exitTokenRejected || !strings.Contains(err.Error(), "revoke access token") || len(done) != 0 {
+		t.Errorf("expected a rejected revocation, got %v, %v", done, err)
+	}
+}
+
+// refreshRejecter records revocations like revocationRecorder but refuses
+// refresh tokens
+func refreshRejecter(t *testing.T) (http.HandlerFunc, func() []string) {
+	handler, revoked := revocationRecorder(t)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("token_type_hint") == hintRefreshToken {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		handler(w, r)
+	}, revoked
+}
+
+func TestRevokeContinuesPastFailure(t *testing.T) {
+	handler, revoked := refreshRejecter(t)
+	server := newFakeEndpointIssuer(t, "revocation_endpoint", handler)
+	result := testIntrospectionResult(server.URL)
+	result.Token.RefreshToken = "rt"
+
+	done, err := revoke("", result, revocationsFor(result.Token, true, true))
+	if exitCodeFor(err) != exitTokenRejected || !strings.Contains(err.Error(), "revoke refresh token") {
+		t.Errorf("expected the refresh token's failure, got %v", err)
+	}
+	if len(done) != 1 || done[0].token != "tok" {
+		t.Errorf("expected the access token to be reported as revoked, got %v", done)
+	}
+	if got := strings.Join(revoked(), " "); got != "access_token:tok" {
+		t.Errorf("expected the access token to be revoked after the failure, got %q", got)
+	}
+}
+
+func TestRevokeErrors(t *testing.T) {
+	server := newFakeIssuer(t, func(w http.ResponseWriter, r *http.Request) {})
+	_, err := revoke("", testIntrospectionResult(server.URL), []revocation{{"tok", hintAccessToken}})
+	if err == nil || !strings.Contains(err.Error(), "revocation_endpoint") || exitCodeFor(err) != exitDiscovery {
+		t.Errorf("expected a missing endpoint error, got %v", err)
+	}
+
+	if err := revokeToken("http://auth.example.com/revoke", clientCredentials{}, "tok", ""); err == nil {
+		t.Error("expected plain HTTP to be refused")
+	}
+}
+
+func TestParseRevokeInput(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		hint  string
+		want  []revocation
+	}{
+		{"bare token", "tok\n", "", []revocation{{"tok", ""}}},
+		{"bare refresh token", "rt", hintRefreshToken, []revocation{{"rt", hintRefreshToken}}},
+		{"tkz token json", `{"access_token":"tok","refresh_token":"rt"}`, "", []revocation{{"rt", hintRefreshToken}, {"tok", hintAccessToken}}},
+		{"json without refresh token", `{"access_token":"tok"}`, hintRefreshToken, []revocation{{"tok", hintAccessToken}}},
+		{"empty", "  \n", "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseRevokeInput([]byte(tt.input), tt.hint)
+			if len(got) != len(tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("expected %v, got %v", tt.want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestForgetRevoked(t *testing.T) {
+	useConfigHome(t, nil)
+	client := Client{Name: "api", Issuer: "https://auth.example.com"}
+	result := TokenResult{Token: TokenResponse{AccessToken: "tok", RefreshToken: "rt", ExpiresIn: 300}, Client: client, FetchedAt: time.Now()}
+	other := TokenResult{Token: TokenResponse{AccessToken: "other", ExpiresIn: 300}, Client: Client{Name: "other"}, FetchedAt: time.Now()}
+
+	settings := Settings{DiskCache: diskCacheSession}
+	d, err := openDiskCache(settings, "sess")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.put(result)
+	d.put(other)
+	a := startTestAgent(t, "", 0)
+	seedAgent(a, result)
+	seedAgent(a, other)
+
+	if err := forgetRevoked(settings, "sess", []revocation{{"rt", hintRefreshToken}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := d.get(client); ok {
+		t.Error("expected the revoked token to be dropped from the disk cache")
+	}
+	if _, ok := d.get(other.Client); !ok {
+		t.Error("expected other tokens to stay in the disk cache")
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.cache) != 1 {
+		t.Errorf("expected only the other token to stay in the agent, got %d", len(a.cache))
+	}
+}
+
+func TestRevokeKey(t *testing.T) {
+	handler, revoked := revocationRecorder(t)
+	server := newFakeEndpointIssuer(t, "revocation_endpoint", handler)
+	useConfigHome(t, nil)
+	result := testIntrospectionResult(server.URL)
+	result.Token.RefreshToken = "rt"
+	result.Token.ExpiresIn = 300
+	result.FetchedAt = time.Now()
+
+	m := initialModel("")
+	m.mode = tokenView
+	m.tokenResult = &result
+	m.tokenCache.put(result)
+
+	updated, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'x'}})
+	m = updated.(model)
+	if m.mode != revokeView || !strings.Contains(m.View(), "r: refresh token") {
+		t.Fatalf("expected the revoke prompt, got %v", m.mode)
+	}
+
+	updated, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'r'}})
+	m = updated.(model)
+	if cmd == nil || m.mode != tokenView {
+		t.Fatal("expected the revocation to start")
+	}
+	updated, _ = m.Update(cmd())
+	m = updated.(model)
+
+	if got := strings.Join(revoked(), " "); got != "refresh_token:rt" {
+		t.Errorf("expected only the refresh token to be revoked, got %q", got)
+	}
+	if m.statusMsg != "Revoked refresh token" {
+		t.Errorf("unexpected status %q", m.statusMsg)
+	}
+	if m.tokenResult.Token.RefreshToken != "" {
+		t.Error("expected the revoked refresh token to be dropped")
+	}
+	if _, ok := m.tokenCache.get(result.Client, time.Now()); ok {
+		t.Error("expected the revoked token to be dropped from the cache")
+	}
+}
+
+func TestRevokeKeyPartialFailure(t *testing.T) {
+	handler, _ := refreshRejecter(t)
+	server := newFakeEndpointIssuer(t, "revocation_endpoint", handler)
+	useConfigHome(t, nil)
+	result := testIntrospectionResult(server.URL)
+	result.Token.RefreshToken = "rt"
+	result.Token.ExpiresIn = 300
+	result.FetchedAt = time.Now()
+
+	m := initialModel("")
+	m.mode = revokeView
+	m.tokenResult = &result
+	m.tokenCache.put(result)
+
+	updated, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'b'}})
+	m = updated.(model)
+	if cmd == nil {
+		t.Fatal("expected the revocation to start")
+	}
+	updated, _ = m.Update(cmd())
+	m = updated.(model)
+
+	if m.mode != errorView || !strings.HasPrefix(m.errorMsg, "Revoked access token, but: ") {
+		t.Errorf("expected the failure after the revoked token, got %v %q", m.mode, m.errorMsg)
+	}
+	if _, ok := m.tokenCache.get(result.Client, time.Now()); ok {
+		t.Error("expected the revoked access token to be dropped from the cache")
+	}
+	if m.tokenResult.Token.RefreshToken != "rt" {
+		t.Error("expected the refresh token that was not revoked to stay")
+	}
+}
+
+func TestRunRevokeCmdPartialFailure(t *testing.T) {
+	handler, _ := refreshRejecter(t)
+	server := newFakeEndpointIssuer(t, "revocation_endpoint", handler)
+	useFakeProvider(t, fakeProvider{name: "fake", items: map[string]map[string]string{
+		"api": {"id": "my-client", "secret": "s3cret"},
+	}})
+	useConfigHome(t, []Client{})
+	client := Client{Name: "api", Provider: "fake", ItemID: "api", Issuer: server.URL, AuthMethod: authClientSecretBasic}
+	settings := Settings{DiskCache: diskCacheSession}
+	data, _ := json.Marshal(clientsFile{Settings: settings, Clients: []Client{client}})
+	if err := os.WriteFile(getClientsPath(), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	d, err := openDiskCache(settings, "sess")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.put(TokenResult{Token: TokenResponse{AccessToken: "tok", RefreshToken: "rt", ExpiresIn: 300}, Client: client, FetchedAt: time.Now()})
+
+	var stdout, stderr bytes.Buffer
+	stdin := `{"access_token": "tok", "refresh_token": "rt"}`
+	if code := runRevokeCmd([]string{"api"}, "sess", strings.NewReader(stdin), &stdout, &stderr); code != exitTokenRejected {
+		t.Fatalf("expected exit %d, got %d: %s", exitTokenRejected, code, stderr.String())
+	}
+	if stdout.String() != "Revoked access token\n" || !strings.Contains(stderr.String(), "revoke refresh token") {
+		t.Errorf("unexpected output %q, %q", stdout.String(), stderr.String())
+	}
+	if _, ok := d.get(client); ok {
+		t.Error("expected the revoked access token to be dropped from the disk cache")
+	}
+}
+
+func TestRunRevokeCmd(t *testing.T) {
+	useConfigHome(t, []Client{{Name: "api", Issuer: "https://auth.example.com"}})
+	tests := []struct {
+		name  string
+		args  []string
+		stdin string
+		want  int
+	}{
+		{"no client", nil, "tok", exitUsage},
+		{"bad hint", []string{"api", "--hint", "id_token"}, "tok", exitUsage},
+		{"empty stdin", []string{"api"}, "", exitUsage},
+		{"unknown client", []string{"nope"}, "tok", exitClientNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			if code := runRevokeCmd(tt.args, "", strings.NewReader(tt.stdin), &stdout, &stderr); code != tt.want {
+				t.Errorf("expected exit %d, got %d: %s", tt.want, code, stderr.String())
+			}
+		})
+	}
+}
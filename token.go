@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// tokenStage identifies the step of the token pipeline that failed
+type tokenStage int
+
+const (
+	stageProvider  tokenStage = iota // Fetching or parsing the item from the secret provider
+	stageResolve                     // Resolving client_id / client_secret fields
+	stageDiscovery                   // OIDC discovery
+	stageToken                       // Token endpoint request
+)
+
+// tokenError wraps a pipeline failure with the stage it occurred in
+type tokenError struct {
+	stage tokenStage
+	err   error
+}
+
+func (e *tokenError) Error() string { return e.err.Error() }
+
+func (e *tokenError) Unwrap() error { return e.err }
+
+func stageErr(stage tokenStage, format string, args ...any) error {
+	return &tokenError{stage: stage, err: fmt.Errorf(format, args...)}
+}
+
+// flowPrompter receives user-facing instructions from interactive grants.
+// The TUI forwards them as messages; headless commands print them to stderr.
+type flowPrompter interface {
+	authorizeURL(url string)
+	deviceCode(auth DeviceAuthResponse)
+}
+
+// resolveUserCredentials reads the resource owner's username and password
+// from the client's user item
+func resolveUserCredentials(session string, client Client) (username, password string, err error) {
+	if client.UserItemID == "" {
+		return "", "", stageErr(stageResolve, "password grant: no user item configured")
+	}
+	p, err := clientProvider(client)
+	if err != nil {
+		return "", "", err
+	}
+	item, err := loadItem(session, p, client.UserItemID)
+	if err != nil {
+		return "", "", err
+	}
+	if username, err = item.field(p.info().userField); err != nil {
+		return "", "", stageErr(stageResolve, "resolve username: %w", err)
+	}
+	if password, err = item.field(p.info().passwordField); err != nil {
+		return "", "", stageErr(stageResolve, "resolve password: %w", err)
+	}
+	return username, password, nil
+}
+
+// resolveClientCredentials fetches the client's item from its secret
+// provider and resolves client_id and client_secret from it. Public clients
+// with a manual client_id and no item skip the provider entirely.
+func resolveClientCredentials(session string, client Client) (clientCredentials, error) {
+	if client.ItemID == "" && client.ClientID != "" {
+		if !client.public() {
+			return clientCredentials{}, stageErr(stageResolve, "no item configured for the client's credentials (set the auth method to none for a public client)")
+		}
+		return clientCredentials{ClientID: client.ClientID}, nil
+	}
+
+	p, err := clientProvider(client)
+	if err != nil {
+		return clientCredentials{}, err
+	}
+	item, err := loadItem(session, p, client.ItemID)
+	if err != nil {
+		return clientCredentials{}, err
+	}
+
+	// Resolve client_id: manual override takes precedence
+	clientID := client.ClientID
+	if clientID == "" {
+		fieldPath := client.ClientIDField
+		if fieldPath == "" {
+			fieldPath = p.info().idField
+		}
+		clientID, err = item.field(fieldPath)
+		if err != nil {
+			return clientCredentials{}, stageErr(stageResolve, "resolve client_id (%s): %w", fieldPath, err)
+		}
+	}
+
+	creds := clientCredentials{ClientID: clientID}
+
+	// Mutual TLS certificate, kept in memory only
+	if client.TLSCertField != "" {
+		if creds.certificate, err = resolveClientCertificate(item, client); err != nil {
+			return clientCredentials{}, err
+		}
+	}
+
+	// These methods identify the client without a shared secret
+	if client.AuthMethod == authNone || client.AuthMethod == authTLSClientAuth {
+		return creds, nil
+	}
+
+	// private_key_jwt replaces the client secret with a signed assertion
+	if client.PrivateKeyField != "" {
+		pemData, err := item.field(client.PrivateKeyField)
+		if err != nil {
+			return clientCredentials{}, stageErr(stageResolve, "resolve private key (%s): %w", client.PrivateKeyField, err)
+		}
+		key, err := parsePrivateKeyPEM(pemData)
+		if err != nil {
+			return clientCredentials{}, stageErr(stageResolve, "parse private key (%s): %w", client.PrivateKeyField, err)
+		}
+		creds.signingKey = key
+		creds.signingAlg = client.SigningAlg
+		creds.keyID = client.KeyID
+		return creds, nil
+	}
+
+	// Resolve client_secret: always from the provider
+	secretFieldPath := client.ClientSecretField
+	if secretFieldPath == "" {
+		secretFieldPath = p.info().secretField
+	}
+	clientSecret, err := item.field(secretFieldPath)
+	if err != nil {
+		return clientCredentials{}, stageErr(stageResolve, "resolve client_secret (%s): %w", secretFieldPath, err)
+	}
+
+	creds.ClientSecret = clientSecret
+	return creds, nil
+}
+
+// resolveClientCertificate loads the client's mutual TLS certificate and key
+// from its item
+func resolveClientCertificate(item secretItem, client Client) (*tls.Certificate, error) {
+	certPEM, err := item.field(client.TLSCertField)
+	if err != nil {
+		return nil, stageErr(stageResolve, "resolve TLS certificate (%s): %w", client.TLSCertField, err)
+	}
+	var keyPEM string
+	if client.TLSKeyField != "" {
+		if keyPEM, err = item.field(client.TLSKeyField); err != nil {
+			return nil, stageErr(stageResolve, "resolve TLS key (%s): %w", client.TLSKeyField, err)
+		}
+	}
+	cert, err := parseClientCertificate(certPEM, keyPEM)
+	if err != nil {
+		return nil, stageErr(stageResolve, "parse TLS certificate: %w", err)
+	}
+	return &cert, nil
+}
+
+// settleCredentials applies what the discovery document and the client's
+// settings decide about talking to the token endpoint: auth method, mTLS
+// endpoint aliases and the DPoP key
+func settleCredentials(client Client, creds clientCredentials, oidc *OIDCConfig) (clientCredentials, *OIDCConfig, error) {
+	creds, err := creds.withAuthMethod(client.AuthMethod, oidc.TokenEndpointAuthMethods)
+	if err != nil {
+		return creds, nil, stageErr(stageResolve, "client authentication: %w", err)
+	}
+	creds.audience = oidc.TokenEndpoint
+	if creds.certificate != nil {
+		oidc = oidc.withMTLSAliases()
+	}
+	if client.DPoP {
+		if creds.dpop, err = sessionDPoPKey(); err != nil {
+			return creds, nil, stageErr(stageResolve, "%w", err)
+		}
+	}
+	return creds, oidc, nil
+}
+
+// tokenPipeline holds what the token pipeline needs besides the client itself.
+// It is shared by the TUI and the headless commands.
+type tokenPipeline struct {
+	session string
+	clients []Client // Configured clients, for chained grants (token exchange)
+	prompt  flowPrompter
+}
+
+// fetchToken runs the full token pipeline for a client:
+// secret provider fetch → field resolution → OIDC discovery → grant.
+func (p tokenPipeline) fetchToken(ctx context.Context, client Client) (*TokenResult, error) {
+	return p.fetch(ctx, client, nil)
+}
+
+// fetch runs the pipeline; chain holds the clients already being resolved
+// so a token exchange cannot reference itself
+func (p tokenPipeline) fetch(ctx context.Context, client Client, chain []string) (*TokenResult, error) {
+	if slices.Contains(chain, client.Name) {
+		return nil, stageErr(stageResolve, "token exchange: subject client cycle: %s", strings.Join(append(chain, client.Name), " → "))
+	}
+	chain = append(chain, client.Name)
+
+	// Token exchange first obtains the subject token from another client
+	var subject *TokenResult
+	if client.grant() == grantTokenExchange {
+		subjectClient, ok := findClientByName(p.clients, client.SubjectClient)
+		if !ok {
+			return nil, stageErr(stageResolve, "token exchange: subject client %q not found", client.SubjectClient)
+		}
+		var err error
+		if subject, err = p.fetch(ctx, subjectClient, chain); err != nil {
+			return nil, fmt.Errorf("subject %s: %w", subjectClient.Name, err)
+		}
+	}
+
+	creds, err := resolveClientCredentials(p.session, client)
+	if err != nil {
+		return nil, err
+	}
+
+	var username, password string
+	if client.grant() == grantPassword {
+		if username, password, err = resolveUserCredentials(p.session, client); err != nil {
+			return nil, err
+		}
+	}
+
+	oidc, err := DiscoverOIDC(client.Issuer)
+	if err != nil {
+		return nil, stageErr(stageDiscovery, "oidc discovery: %w", err)
+	}
+	if creds, oidc, err = settleCredentials(client, creds, oidc); err != nil {
+		return nil, err
+	}
+
+	var token *TokenResponse
+	switch client.grant() {
+	case grantClientCredentials:
+		token, err = requestClientCredentials(oidc.TokenEndpoint, creds, client.Scopes)
+	case grantPassword:
+		token, err = RequestPasswordToken(oidc.TokenEndpoint, creds, username, password, client.Scopes)
+	case grantAuthorizationCode:
+		if oidc.AuthorizationEndpoint == "" {
+			return nil, stageErr(stageDiscovery, "oidc discovery: response missing authorization_endpoint")
+		}
+		token, err = authorizationCodeGrant(ctx, oidc, creds, client.Scopes, p.prompt)
+	case grantDeviceCode:
+		if oidc.DeviceAuthorizationEndpoint == "" {
+			return nil, stageErr(stageDiscovery, "oidc discovery: response missing device_authorization_endpoint")
+		}
+		token, err = deviceCodeGrant(ctx, oidc, creds, client.Scopes, p.prompt)
+	case grantTokenExchange:
+		token, err = RequestTokenExchange(oidc.TokenEndpoint, creds, tokenExchangeParams{
+			SubjectToken:       subject.Token.AccessToken,
+			SubjectTokenType:   client.SubjectTokenType,
+			Audience:           client.Audience,
+			RequestedTokenType: client.RequestedTokenType,
+			Scopes:             client.Scopes,
+		})
+	default:
+		return nil, fmt.Errorf("unsupported grant type: %s", client.GrantType)
+	}
+	if err != nil {
+		return nil, stageErr(stageToken, "token request: %w", err)
+	}
+
+	return &TokenResult{
+		Token:         *token,
+		Client:        client,
+		FetchedAt:     time.Now(),
+		TokenEndpoint: oidc.TokenEndpoint,
+		Subject:       subject,
+		creds:         creds,
+	}, nil
+}
+
+// findClientByName returns the configured client with the given name
+func findClientByName(clients []Client, name string) (Client, bool) {
+	for _, c := range clients {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return Client{}, false
+}
+
+// refreshToken redeems the refresh token of a previous result against the
+// same token endpoint, reusing the credentials resolved the first time
+func refreshToken(prev TokenResult) (*TokenResult, error) {
+	if prev.Token.RefreshToken == "" {
+		return nil, fmt.Errorf("token response did not include a refresh_token")
+	}
+	token, err := RequestRefreshToken(prev.TokenEndpoint, prev.creds, prev.Token.RefreshToken, "")
+	if err != nil {
+		return nil, stageErr(stageToken, "refresh: %w", err)
+	}
+	// Servers without refresh token rotation omit a new one
+	if token.RefreshToken == "" {
+		token.RefreshToken = prev.Token.RefreshToken
+		token.RefreshExpiresIn = 0
+	}
+	return &TokenResult{
+		Token:         *token,
+		Client:        prev.Client,
+		FetchedAt:     time.Now(),
+		TokenEndpoint: prev.TokenEndpoint,
+		Subject:       prev.Subject,
+		creds:         prev.creds,
+	}, nil
+}
+
+// fetchRefreshedToken redeems a refresh token obtained earlier (e.g. from
+// `tkz token -o json`), resolving credentials and the token endpoint first
+func fetchRefreshedToken(session string, client Client, refresh string) (*TokenResult, error) {
+	creds, err := resolveClientCredentials(session, client)
+	if err != nil {
+		return nil, err
+	}
+
+	oidc, err := DiscoverOIDC(client.Issuer)
+	if err != nil {
+		return nil, stageErr(stageDiscovery, "oidc discovery: %w", err)
+	}
+	if creds, oidc, err = settleCredentials(client, creds, oidc); err != nil {
+		return nil, err
+	}
+
+	return refreshToken(TokenResult{
+		Token:         TokenResponse{RefreshToken: refresh},
+		Client:        client,
+		TokenEndpoint: oidc.TokenEndpoint,
+		creds:         creds,
+	})
+}
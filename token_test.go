@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRefreshToken(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.FormValue("grant_type") != "refresh_token" {
+			t.Errorf("expected grant_type refresh_token, got %q", r.FormValue("grant_type"))
+		}
+		if r.FormValue("refresh_token") != "rt-1" {
+			t.Errorf("expected refresh_token 'rt-1', got %q", r.FormValue("refresh_token"))
+		}
+		if r.FormValue("client_id") != "app" || r.FormValue("client_secret") != "s3cret" {
+			t.Errorf("expected cached client credentials, got %q / %q", r.FormValue("client_id"), r.FormValue("client_secret"))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{
+			"access_token": "at-2",
+			"token_type": "Bearer",
+			"expires_in": 300,
+			"refresh_token": "rt-2",
+			"refresh_expires_in": 1800,
+			"id_token": "header.payload.sig"
+		}`))
+	}))
+	defer server.Close()
+	useTLSServer(t, server)
+
+	prev := TokenResult{
+		Token:         TokenResponse{AccessToken: "at-1", RefreshToken: "rt-1"},
+		Client:        Client{Name: "kc"},
+		FetchedAt:     time.Now().Add(-time.Hour),
+		TokenEndpoint: server.URL,
+		creds:         clientCredentials{ClientID: "app", ClientSecret: "s3cret"},
+	}
+
+	result, err := refreshToken(prev)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Token.AccessToken != "at-2" || result.Token.RefreshToken != "rt-2" {
+		t.Errorf("unexpected tokens: %+v", result.Token)
+	}
+	if result.Token.RefreshExpiresIn != 1800 || result.Token.IDToken != "header.payload.sig" {
+		t.Errorf("expected refresh_expires_in and id_token to be captured, got %+v", result.Token)
+	}
+	if result.Client.Name != "kc" || result.TokenEndpoint != server.URL {
+		t.Error("expected client and token endpoint to carry over")
+	}
+	if !result.FetchedAt.After(prev.FetchedAt) {
+		t.Error("expected a new fetched_at")
+	}
+}
+
+func TestRefreshTokenKeepsRefreshTokenWithoutRotation(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token": "at-2", "token_type": "Bearer", "expires_in": 300}`))
+	}))
+	defer server.Close()
+	useTLSServer(t, server)
+
+	result, err := refreshToken(TokenResult{
+		Token:         TokenResponse{RefreshToken: "rt-1"},
+		TokenEndpoint: server.URL,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Token.RefreshToken != "rt-1" {
+		t.Errorf("expected the old refresh token to be kept, got %q", result.Token.RefreshToken)
+	}
+}
+
+func TestRefreshTokenWithoutRefreshToken(t *testing.T) {
+	if _, err := refreshToken(TokenResult{TokenEndpoint: "https://auth.example.com/token"}); err == nil {
+		t.Fatal("expected error without a refresh token")
+	}
+}
+
+// newFakeIssuer serves discovery plus a token endpoint handled by tokenHandler
+func newFakeIssuer(t *testing.T, tokenHandler http.HandlerFunc) *httptest.Server {
+	t.Helper()
+	var server *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":         server.URL,
+			"token_endpoint": server.URL + "/token",
+		})
+	})
+	mux.HandleFunc("/token", tokenHandler)
+	server = httptest.NewTLSServer(mux)
+	t.Cleanup(server.Close)
+	useTLSServer(t, server)
+	return server
+}
+
+func TestTokenExchangeChainsSubjectClient(t *testing.T) {
+	server := newFakeIssuer(t, func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		w.Header().Set("Content-Type", "application/json")
+		switch r.FormValue("grant_type") {
+		case "client_credentials":
+			w.Write([]byte(`{"access_token": "caller-token", "token_type": "Bearer", "expires_in": 300}`))
+		case tokenExchangeGrant:
+			if r.FormValue("subject_token") != "caller-token" {
+				t.Errorf("expected subject_token 'caller-token', got %q", r.FormValue("subject_token"))
+			}
+			if r.FormValue("subject_token_type") != tokenTypeAccessToken {
+				t.Errorf("expected default subject_token_type, got %q", r.FormValue("subject_token_type"))
+			}
+			if r.FormValue("audience") != "orders-api" {
+				t.Errorf("expected audience 'orders-api', got %q", r.FormValue("audience"))
+			}
+			if r.FormValue("client_id") != "mesh-gateway" {
+				t.Errorf("expected exchanging client's id, got %q", r.FormValue("client_id"))
+			}
+			w.Write([]byte(`{
+				"access_token": "downstream-token",
+				"token_type": "Bearer",
+				"expires_in": 60,
+				"issued_token_type": "urn:ietf:params:oauth:token-type:access_token"
+			}`))
+		default:
+			t.Errorf("unexpected grant_type %q", r.FormValue("grant_type"))
+		}
+	})
+
+	caller := Client{Name: "caller", ClientID: "caller-app", AuthMethod: authNone, Issuer: server.URL}
+	exchanger := Client{
+		Name:          "orders",
+		ClientID:      "mesh-gateway",
+		AuthMethod:    authNone,
+		Issuer:        server.URL,
+		GrantType:     grantTokenExchange,
+		SubjectClient: "caller",
+		Audience:      "orders-api",
+	}
+	p := tokenPipeline{clients: []Client{caller, exchanger}}
+
+	result, err := p.fetchToken(context.Background(), exchanger)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Token.AccessToken != "downstream-token" {
+		t.Errorf("expected exchanged token, got %q", result.Token.AccessToken)
+	}
+	if result.Subject == nil || result.Subject.Token.AccessToken != "caller-token" {
+		t.Errorf("expected subject result to be kept, got %+v", result.Subject)
+	}
+}
+
+func TestResolveClientCredentialsManualClientID(t *testing.T) {
+	for _, c := range []Client{
+		{ClientID: "app", AuthMethod: authNone},
+		{ClientID: "app", GrantType: grantAuthorizationCode},
+		{ClientID: "app", GrantType: grantDeviceCode},
+	} {
+		if creds, err := resolveClientCredentials("", c); err != nil || creds.ClientID != "app" {
+			t.Errorf("expected %+v to be public, got %+v, %v", c, creds, err)
+		}
+	}
+
+	// Confidential clients without an item have nothing to authenticate with
+	for _, c := range []Client{
+		{ClientID: "app"},
+		{ClientID: "app", GrantType: grantPassword, UserItemID: "user"},
+		{ClientID: "app", GrantType: grantAuthorizationCode, AuthMethod: authPrivateKeyJWT},
+	} {
+		_, err := resolveClientCredentials("", c)
+		var te *tokenError
+		if !errors.As(err, &te) || te.stage != stageResolve {
+			t.Errorf("expected a resolve error for %+v, got %v", c, err)
+		}
+	}
+}
+
+func TestTokenExchangeDetectsCycle(t *testing.T) {
+	a := Client{Name: "a", ClientID: "a", GrantType: grantTokenExchange, SubjectClient: "b"}
+	b := Client{Name: "b", ClientID: "b", GrantType: grantTokenExchange, SubjectClient: "a"}
+	p := tokenPipeline{clients: []Client{a, b}}
+
+	_, err := p.fetchToken(context.Background(), a)
+	if err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Errorf("expected cycle error, got %v", err)
+	}
+}
+
+func TestTokenExchangeMissingSubject(t *testing.T) {
+	c := Client{Name: "x", ClientID: "x", GrantType: grantTokenExchange, SubjectClient: "ghost"}
+	p := tokenPipeline{clients: []Client{c}}
+
+	_, err := p.fetchToken(context.Background(), c)
+	if err == nil || !strings.Contains(err.Error(), "ghost") {
+		t.Errorf("expected missing subject error, got %v", err)
+	}
+}
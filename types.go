@@ -1,30 +1,119 @@
 package main
 
-import "time"
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
 
 // viewMode represents the current TUI screen
 type viewMode int
 
 const (
-	listView       viewMode = iota // Home screen with client list
-	bwSelectView                   // Pick a Bitwarden item (bubbles/list)
-	formView                       // Add/edit client form (huh)
-	tokenView                      // Token request in progress / result display
-	errorView                      // Error details
-	deleteView                     // Confirm client deletion
-	bwPasswordView                 // Master password prompt for locked vault
-	bwLoginView                    // Instructions to run bw login (unauthenticated)
+	listView             viewMode = iota // Home screen with client list
+	providerSelectView                   // Pick a provider item (bubbles/list)
+	formView                             // Add/edit client form (huh)
+	tokenView                            // Token request in progress / result display
+	errorView                            // Error details
+	deleteView                           // Confirm client deletion
+	providerPasswordView                 // Master password prompt for a locked provider
+	providerLoginView                    // Instructions to install or sign in to the provider
+	deviceCodeView                       // User code + verification URL while polling (device flow)
+	dpopProofView                        // Method + URL prompt for a DPoP proof
+	proxyInputView                       // Upstream + listen address prompt for a proxy
+	proxyView                            // Running proxy with its request log
+	inspectView                          // Decoded JWT header and claims
+	revokeView                           // Confirm revoking the shown tokens
+)
+
+// OAuth grant types a client can use (Client.GrantType)
+const (
+	grantClientCredentials = "client_credentials"
+	grantAuthorizationCode = "authorization_code"
+	grantDeviceCode        = "device_code"
+	grantPassword          = "password"
+	grantTokenExchange     = "token-exchange"
 )
 
 // Client represents a configured OAuth client (stored in clients.json)
 type Client struct {
 	Name              string `json:"name"`
-	BitwardenItemID   string `json:"bitwarden_item_id"`
+	ItemID            string `json:"bitwarden_item_id"`
 	Issuer            string `json:"issuer"`
 	Scopes            string `json:"scopes"`
 	ClientID          string `json:"client_id,omitempty"`
 	ClientIDField     string `json:"client_id_field,omitempty"`
 	ClientSecretField string `json:"client_secret_field,omitempty"`
+	GrantType         string `json:"grant_type,omitempty"`
+	UserItemID        string `json:"user_bitwarden_item_id,omitempty"`
+
+	// Secret provider holding the items above; empty is Bitwarden. Item IDs
+	// are in the provider's format and keep their JSON names from when
+	// Bitwarden was the only provider.
+	Provider string `json:"provider,omitempty"`
+
+	// HashiCorp Vault settings; empty ones come from VAULT_ADDR,
+	// VAULT_NAMESPACE and the "secret" mount
+	VaultAddr      string `json:"vault_addr,omitempty"`
+	VaultNamespace string `json:"vault_namespace,omitempty"`
+	VaultMount     string `json:"vault_mount,omitempty"`
+
+	// Token endpoint client authentication; empty picks one from discovery
+	AuthMethod string `json:"token_endpoint_auth_method,omitempty"`
+
+	// Mutual TLS (RFC 8705): PEM client certificate and key locations in the
+	// client's item; the key field may be empty if the certificate value
+	// holds both
+	TLSCertField string `json:"tls_cert_field,omitempty"`
+	TLSKeyField  string `json:"tls_key_field,omitempty"`
+
+	// Seconds before expiry a cached token is replaced; 0 uses defaultCacheSkew
+	CacheSkew int `json:"cache_skew,omitempty"`
+
+	// Request DPoP-bound tokens (RFC 9449) with the session's ephemeral key
+	DPoP bool `json:"dpop,omitempty"`
+
+	// private_key_jwt: PEM key location in the client's item, used to sign
+	// a client assertion instead of sending client_secret
+	PrivateKeyField string `json:"private_key_field,omitempty"`
+	SigningAlg      string `json:"signing_alg,omitempty"`
+	KeyID           string `json:"key_id,omitempty"`
+
+	// Token exchange (RFC 8693): the subject token comes from another client
+	SubjectClient      string `json:"subject_client,omitempty"`
+	SubjectTokenType   string `json:"subject_token_type,omitempty"`
+	Audience           string `json:"audience,omitempty"`
+	RequestedTokenType string `json:"requested_token_type,omitempty"`
+
+	// Runtime only, not persisted: when this client's cached token goes
+	// stale (list display)
+	cachedUntil time.Time
+}
+
+// grant returns the client's grant type, defaulting to client_credentials
+func (c Client) grant() string {
+	if c.GrantType == "" {
+		return grantClientCredentials
+	}
+	return c.GrantType
+}
+
+// public reports whether the client authenticates with its client_id alone:
+// auth method none, or a browser or device grant left to discovery
+func (c Client) public() bool {
+	switch c.AuthMethod {
+	case authNone:
+		return true
+	case "":
+		return c.grant() == grantAuthorizationCode || c.grant() == grantDeviceCode
+	}
+	return false
+}
+
+// needsProvider reports whether the client reads anything from its provider.
+// Public clients with a manual client_id and no item never do.
+func (c Client) needsProvider() bool {
+	return c.ItemID != "" || c.UserItemID != "" || c.ClientID == ""
 }
 
 // Title implements list.Item
@@ -39,6 +128,9 @@ func (c Client) Description() string {
 	if c.ClientID != "" {
 		desc += " (ID: " + c.ClientID + ")"
 	}
+	if left := time.Until(c.cachedUntil); left > 0 {
+		desc += " • cached, " + formatRemaining(left) + " left"
+	}
 	return desc
 }
 
@@ -85,13 +177,21 @@ type BWField struct {
 	Type  int    `json:"type"`
 }
 
+// BWAttachment represents a file attached to a Bitwarden item
+type BWAttachment struct {
+	ID       string `json:"id"`
+	FileName string `json:"fileName"`
+	Size     string `json:"size"`
+}
+
 // BWFullItem represents a complete Bitwarden item with custom fields and notes
 type BWFullItem struct {
-	ID     string    `json:"id"`
-	Name   string    `json:"name"`
-	Login  *BWLogin  `json:"login"`
-	Fields []BWField `json:"fields"`
-	Notes  string    `json:"notes"`
+	ID          string         `json:"id"`
+	Name        string         `json:"name"`
+	Login       *BWLogin       `json:"login"`
+	Fields      []BWField      `json:"fields"`
+	Notes       string         `json:"notes"`
+	Attachments []BWAttachment `json:"attachments"`
 }
 
 // BWCredentials holds credentials fetched from Bitwarden
@@ -103,41 +203,154 @@ type BWCredentials struct {
 
 // OIDCConfig represents relevant fields from an OpenID Connect discovery document
 type OIDCConfig struct {
-	TokenEndpoint string `json:"token_endpoint"`
-	Issuer        string `json:"issuer"`
+	TokenEndpoint               string               `json:"token_endpoint"`
+	AuthorizationEndpoint       string               `json:"authorization_endpoint"`
+	DeviceAuthorizationEndpoint string               `json:"device_authorization_endpoint"`
+	Issuer                      string               `json:"issuer"`
+	JWKSURI                     string               `json:"jwks_uri"`
+	IntrospectionEndpoint       string               `json:"introspection_endpoint"`
+	RevocationEndpoint          string               `json:"revocation_endpoint"`
+	UserinfoEndpoint            string               `json:"userinfo_endpoint"`
+	TokenEndpointAuthMethods    []string             `json:"token_endpoint_auth_methods_supported"`
+	MTLSEndpointAliases         *mtlsEndpointAliases `json:"mtls_endpoint_aliases"`
+}
+
+// DeviceAuthResponse is the device authorization response (RFC 8628 §3.2)
+type DeviceAuthResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete,omitempty"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval,omitempty"`
 }
 
 // TokenResponse represents an OAuth token response
 type TokenResponse struct {
-	AccessToken string `json:"access_token"`
-	TokenType   string `json:"token_type"`
-	ExpiresIn   int    `json:"expires_in"`
-	Scope       string `json:"scope,omitempty"`
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type"`
+	ExpiresIn        int    `json:"expires_in"`
+	Scope            string `json:"scope,omitempty"`
+	RefreshToken     string `json:"refresh_token,omitempty"`
+	RefreshExpiresIn int    `json:"refresh_expires_in,omitempty"`
+	IDToken          string `json:"id_token,omitempty"`
+	IssuedTokenType  string `json:"issued_token_type,omitempty"`
 }
 
 // TokenResult holds the complete result of a token request flow
 type TokenResult struct {
-	Token     TokenResponse
-	Client    Client
-	FetchedAt time.Time
+	Token         TokenResponse
+	Client        Client
+	FetchedAt     time.Time
+	TokenEndpoint string
+	Subject       *TokenResult // Subject token a token exchange was based on
+
+	// creds are the resolved client credentials, kept in memory only so a
+	// refresh can skip the Bitwarden and discovery round trip
+	creds clientCredentials
+}
+
+// AuthorizationHeader returns the Authorization header value for the access
+// token: the DPoP scheme for DPoP-bound tokens, Bearer otherwise
+func (r TokenResult) AuthorizationHeader() string {
+	if strings.EqualFold(r.Token.TokenType, "DPoP") {
+		return "DPoP " + r.Token.AccessToken
+	}
+	return "Bearer " + r.Token.AccessToken
+}
+
+// dpopBound reports whether the token is or may be bound to a DPoP key
+func (r TokenResult) dpopBound() bool {
+	return r.creds.dpop != nil || r.Client.DPoP || strings.EqualFold(r.Token.TokenType, "DPoP")
+}
+
+// ExpiresAt returns the absolute expiry time, or the zero time when the
+// token endpoint did not report expires_in
+func (r TokenResult) ExpiresAt() time.Time {
+	if r.Token.ExpiresIn <= 0 {
+		return time.Time{}
+	}
+	return r.FetchedAt.Add(time.Duration(r.Token.ExpiresIn) * time.Second)
+}
+
+// RefreshExpiresAt returns when the refresh token expires, or the zero time
+// when the token endpoint did not report refresh_expires_in
+func (r TokenResult) RefreshExpiresAt() time.Time {
+	if r.Token.RefreshExpiresIn <= 0 {
+		return time.Time{}
+	}
+	return r.FetchedAt.Add(time.Duration(r.Token.RefreshExpiresIn) * time.Second)
+}
+
+// tokenResultJSON is the flat shape of a TokenResult in `--output json`
+type tokenResultJSON struct {
+	AccessToken string    `json:"access_token"`
+	TokenType   string    `json:"token_type"`
+	ExpiresIn   int       `json:"expires_in"`
+	Scope       string    `json:"scope,omitempty"`
+	Client      string    `json:"client"`
+	Issuer      string    `json:"issuer"`
+	FetchedAt   time.Time `json:"fetched_at"`
+	ExpiresAt   time.Time `json:"expires_at,omitzero"`
+
+	RefreshToken     string    `json:"refresh_token,omitempty"`
+	RefreshExpiresIn int       `json:"refresh_expires_in,omitempty"`
+	RefreshExpiresAt time.Time `json:"refresh_expires_at,omitzero"`
+	IDToken          string    `json:"id_token,omitempty"`
+	IssuedTokenType  string    `json:"issued_token_type,omitempty"`
+
+	Subject *TokenResult `json:"subject,omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler
+func (r TokenResult) MarshalJSON() ([]byte, error) {
+	return json.Marshal(tokenResultJSON{
+		AccessToken: r.Token.AccessToken,
+		TokenType:   r.Token.TokenType,
+		ExpiresIn:   r.Token.ExpiresIn,
+		Scope:       r.Token.Scope,
+		Client:      r.Client.Name,
+		Issuer:      r.Client.Issuer,
+		FetchedAt:   r.FetchedAt,
+		ExpiresAt:   r.ExpiresAt(),
+
+		RefreshToken:     r.Token.RefreshToken,
+		RefreshExpiresIn: r.Token.RefreshExpiresIn,
+		RefreshExpiresAt: r.RefreshExpiresAt(),
+		IDToken:          r.Token.IDToken,
+		IssuedTokenType:  r.Token.IssuedTokenType,
+
+		Subject: r.Subject,
+	})
 }
 
 // --- Bubble Tea message types ---
 
-type bwStatusMsg struct {
+type providerStatusMsg struct {
+	provider  string
 	installed bool
 	status    string // "unlocked", "locked", "unauthenticated"
 	session   string
 }
 
-type bwUnlockResultMsg struct {
-	session string
-	err     error
+type providerUnlockResultMsg struct {
+	provider string
+	session  string // "" if the provider keeps its sign-in itself
+	err      error
 }
 
-type bwItemsFetchedMsg struct {
-	items []BWItem
-	err   error
+type providerItemsFetchedMsg struct {
+	provider string
+	items    []secretRef
+	err      error
+}
+
+// clientItemsFetchedMsg carries the items listed with a client's own
+// provider settings
+type clientItemsFetchedMsg struct {
+	client string
+	items  []secretRef
+	err    error
 }
 
 type bwCredentialsFetchedMsg struct {
@@ -150,6 +363,16 @@ type oidcDiscoveryMsg struct {
 	err    error
 }
 
+// deviceCodeMsg carries the user code of a running device authorization flow
+type deviceCodeMsg struct {
+	auth DeviceAuthResponse
+}
+
+// authURLMsg carries the authorize URL of a running authorization_code flow
+type authURLMsg struct {
+	url string
+}
+
 type tokenResponseMsg struct {
 	result TokenResult
 	err    error
@@ -161,6 +384,47 @@ type clipboardCopyMsg struct {
 	err     error
 }
 
+// diskCacheMsg reports tokens loaded from, or a failure of, the disk cache
+type diskCacheMsg struct {
+	cache tokenCache
+	err   error
+}
+
+// cacheTickMsg re-renders cached token countdowns
+type cacheTickMsg struct{}
+
+// tokenVerifiedMsg carries the JWKS verification of the shown access token
+type tokenVerifiedMsg struct {
+	token        string
+	verification tokenVerification
+}
+
+// introspectionMsg carries the introspection response for the shown token
+type introspectionMsg struct {
+	token  string
+	claims map[string]any
+	err    error
+}
+
+// userInfoMsg carries the UserInfo response for the shown token
+type userInfoMsg struct {
+	token  string
+	claims map[string]any
+	err    error
+}
+
+// revokedMsg reports the revocation of tokens of the shown result
+type revokedMsg struct {
+	tokens   []revocation // The tokens that were revoked, even if err is set
+	err      error
+	cacheErr error // Revoked, but a cached copy could not be dropped
+}
+
+// proxyLogMsg carries one log line from the running proxy
+type proxyLogMsg struct {
+	line string
+}
+
 type clientsSavedMsg struct {
 	err error
 }
@@ -1,7 +1,10 @@
 package main
 
 import (
+	"errors"
+	"path"
 	"strings"
+	"time"
 
 	"github.com/charmbracelet/bubbles/list"
 	"github.com/charmbracelet/bubbles/spinner"
@@ -17,7 +20,7 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.width = msg.Width
 		m.height = msg.Height
 		m.list.SetSize(msg.Width, msg.Height-4)
-		m.bwSelectList.SetSize(msg.Width, msg.Height-2)
+		m.providerSelectList.SetSize(msg.Width, msg.Height-2)
 		m.viewport.Width = msg.Width - 4
 		m.viewport.Height = msg.Height - 8
 		return m, nil
@@ -26,60 +29,74 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		return m.handleKey(msg)
 
 	case spinner.TickMsg:
-		if m.tokenLoading || m.bwUnlocking {
+		if m.tokenLoading || m.providerUnlocking || m.providerChecking {
 			var cmd tea.Cmd
 			m.spinner, cmd = m.spinner.Update(msg)
 			cmds = append(cmds, cmd)
 		}
 
-	case bwStatusMsg:
-		m.bwChecking = false
-		m.bwInstalled = msg.installed
-		m.bwStatus = msg.status
+	case providerStatusMsg:
+		// Drop answers about a provider the model has switched away from
+		if msg.provider != m.provider.info().name {
+			return m, nil
+		}
+		info := m.provider.info()
+		m.providerChecking = false
+		m.providerInstalled = msg.installed
+		m.providerStatus = msg.status
 		if msg.session != "" {
-			m.bwSession = msg.session
+			m.session = msg.session
 		}
 		if !msg.installed {
-			m.statusMsg = "bw CLI not found"
-			m.mode = bwLoginView
+			m.statusMsg = info.command + " CLI not found"
+			m.mode = providerLoginView
 		} else if msg.status == "unauthenticated" {
-			m.statusMsg = "Not logged in to Bitwarden"
-			m.mode = bwLoginView
+			m.statusMsg = "Not logged in to " + info.label
+			m.mode = providerLoginView
 		} else if msg.status == "locked" {
-			m.bwUnlocked = false
-			m.statusMsg = "Vault locked"
-			m.mode = bwPasswordView
-			m.bwPwInput.Reset()
-			m.bwPwInput.Focus()
-			return m, m.bwPwInput.Cursor.BlinkCmd()
+			m.providerUnlocked = false
+			m.statusMsg = info.label + " locked"
+			m.mode = providerPasswordView
+			m.providerPwInput.Reset()
+			m.providerPwInput.Focus()
+			return m, m.providerPwInput.Cursor.BlinkCmd()
 		} else if msg.status == "unlocked" {
-			m.bwUnlocked = true
-			m.statusMsg = "Vault unlocked"
-			cmds = append(cmds, fetchBWItems(m.bwSession, ""))
+			m.providerUnlocked = true
+			m.statusMsg = info.label + " unlocked"
+			cmds = append(cmds, fetchProviderItems(m.provider, m.session), loadDiskCacheCmd(m.settings, m.session))
 		}
 
-	case bwUnlockResultMsg:
+	case providerUnlockResultMsg:
+		if msg.provider != m.provider.info().name {
+			return m, nil
+		}
 		if msg.err != nil {
-			m.bwUnlocking = false
-			m.bwPwInput.Reset()
-			m.bwUnlockErr = msg.err.Error()
-			m.bwPwInput.Focus()
-			return m, m.bwPwInput.Cursor.BlinkCmd()
-		}
-		// Keep bwUnlocking true to show spinner while fetching items
-		m.bwSession = msg.session
-		m.bwUnlocked = true
-		m.bwStatus = "unlocked"
-		m.bwUnlockErr = ""
-		m.statusMsg = "Vault unlocked"
-		return m, fetchBWItems(m.bwSession, "")
-
-	case bwItemsFetchedMsg:
-		m.bwUnlocking = false
-		m.bwPwInput.Reset()
+			m.providerUnlocking = false
+			m.providerPwInput.Reset()
+			m.providerUnlockErr = msg.err.Error()
+			m.providerPwInput.Focus()
+			return m, m.providerPwInput.Cursor.BlinkCmd()
+		}
+		// Keep providerUnlocking true to show spinner while fetching items
+		m.providerUnlocked = true
+		m.providerStatus = "unlocked"
+		m.providerUnlockErr = ""
+		m.statusMsg = m.provider.info().label + " unlocked"
+		if msg.session == "" {
+			return m, fetchProviderItems(m.provider, m.session)
+		}
+		m.session = msg.session
+		return m, tea.Batch(fetchProviderItems(m.provider, m.session), loadDiskCacheCmd(m.settings, m.session), shareSessionCmd(m.session))
+
+	case providerItemsFetchedMsg:
+		if msg.provider != m.provider.info().name {
+			return m, nil
+		}
+		m.providerUnlocking = false
+		m.providerPwInput.Reset()
 		if msg.err == nil {
-			m.bwItems = msg.items
-			m.updateBWSelectList()
+			m.providerItems = msg.items
+			m.updateProviderSelectList()
 		}
 		if m.pendingAction != "" {
 			action := m.pendingAction
@@ -88,49 +105,162 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			case "add":
 				m.editingIndex = -1
 				m.formClient = &Client{}
-				m.updateBWSelectList()
-				m.bwSelectList.ResetFilter()
-				m.mode = bwSelectView
+				m.openProviderFolder("")
+				m.mode = providerSelectView
 			case "edit":
 				if item, ok := m.list.SelectedItem().(Client); ok {
-					for i, c := range m.clients {
-						if c.Name == item.Name && c.BitwardenItemID == item.BitwardenItemID {
-							m.editingIndex = i
-							break
-						}
-					}
-					clientCopy := item
-					m.formClient = &clientCopy
-					m.form = buildClientForm(m.formClient)
-					m.mode = formView
-					return m, m.form.Init()
+					return m.editClient(item)
 				}
 			case "token":
 				if item, ok := m.list.SelectedItem().(Client); ok {
+					return m, m.startTokenRequest(item, false)
+				}
+			case "proxy":
+				return m.openProxyInput()
+			case "revoke":
+				if m.tokenResult != nil {
+					m.mode = revokeView
+					return m, nil
+				}
+				m.mode = listView
+			case "introspect":
+				if m.tokenResult != nil {
 					m.mode = tokenView
-					m.tokenLoading = true
-					m.tokenResult = nil
-					return m, tea.Batch(m.spinner.Tick, requestToken(m.bwSession, item))
+					return m, m.startIntrospection()
 				}
+				m.mode = listView
+			case "refresh":
+				if m.tokenResult != nil {
+					m.mode = tokenView
+					return m, m.startRefresh()
+				}
+				m.mode = listView
 			default:
 				m.mode = listView
 			}
-		} else if m.mode == bwPasswordView || m.mode == bwLoginView {
+		} else if m.mode == providerPasswordView || m.mode == providerLoginView {
 			m.mode = listView
 		}
 
+	case clientItemsFetchedMsg:
+		if msg.client != m.formItemsFor || m.mode != listView {
+			return m, nil
+		}
+		m.formItemsFor = ""
+		m.statusMsg = ""
+		if msg.err != nil {
+			m.statusMsg = msg.err.Error()
+		}
+		return m.openForm(msg.items)
+
+	case authURLMsg:
+		m.authURL = msg.url
+		return m, tea.Batch(openBrowserCmd(msg.url), waitForPrompt(m.tokenPrompts))
+
+	case deviceCodeMsg:
+		m.deviceAuth = &msg.auth
+		m.mode = deviceCodeView
+		return m, waitForPrompt(m.tokenPrompts)
+
 	case tokenResponseMsg:
 		m.tokenLoading = false
+		m.authURL = ""
+		m.deviceAuth = nil
+		m.cancelTokenRequest()
+		if m.mode == deviceCodeView {
+			m.mode = tokenView
+		}
 		if msg.err != nil {
 			m.errorMsg = msg.err.Error()
-			if strings.HasPrefix(m.errorMsg, "bitwarden:") {
-				m.bwUnlocked = false
+			if strings.HasPrefix(m.errorMsg, m.provider.info().name+":") {
+				m.providerUnlocked = false
 			}
 			m.prevMode = tokenView
 			m.mode = errorView
 			m.setErrorContent(m.errorMsg)
 		} else {
 			m.tokenResult = &msg.result
+			m.tokenCached = false
+			m.tokenCache.put(msg.result)
+			m.updateList()
+			cmds = append(cmds, persistTokenCmd(m.settings, m.session, msg.result), m.verifyToken())
+		}
+
+	case introspectionMsg:
+		// Drop results for a token that is no longer shown
+		if m.tokenResult == nil || m.tokenResult.Token.AccessToken != msg.token || m.mode != tokenView {
+			return m, nil
+		}
+		m.statusMsg = ""
+		if msg.err != nil {
+			m.errorMsg = "introspection: " + msg.err.Error()
+			m.prevMode = tokenView
+			m.mode = errorView
+			m.setErrorContent(m.errorMsg)
+			return m, nil
+		}
+		m.openDetail("Introspection", formatIntrospection(msg.claims, time.Now()))
+
+	case userInfoMsg:
+		// Drop results for a token that is no longer shown
+		if m.tokenResult == nil || m.tokenResult.Token.AccessToken != msg.token || m.mode != tokenView {
+			return m, nil
+		}
+		m.statusMsg = ""
+		// The ID token is worth showing even if the call failed
+		m.openDetail("UserInfo", formatUserInfo(msg.claims, msg.err, m.tokenResult.Token.IDToken, time.Now()))
+
+	case revokedMsg:
+		m.statusMsg = ""
+		var names []string
+		for _, r := range msg.tokens {
+			m.tokenCache.forget(r.token)
+			names = append(names, r.name())
+			// A revoked refresh token must not be offered for refreshing
+			if m.tokenResult != nil && r.hint == hintRefreshToken && m.tokenResult.Token.RefreshToken == r.token {
+				m.tokenResult.Token.RefreshToken = ""
+			}
+		}
+		m.updateList()
+		if msg.err != nil {
+			m.errorMsg = msg.err.Error()
+			if len(names) > 0 {
+				m.errorMsg = "Revoked " + strings.Join(names, " and ") + ", but: " + m.errorMsg
+			}
+			m.prevMode = tokenView
+			m.mode = errorView
+			m.setErrorContent(m.errorMsg)
+			return m, nil
+		}
+		m.statusMsg = "Revoked " + strings.Join(names, " and ")
+		if msg.cacheErr != nil {
+			m.statusMsg += " (cache: " + msg.cacheErr.Error() + ")"
+		}
+
+	case tokenVerifiedMsg:
+		// Drop results for a token that is no longer shown
+		if m.tokenResult != nil && m.tokenResult.Token.AccessToken == msg.token {
+			m.tokenVerify = &msg.verification
+		}
+
+	case diskCacheMsg:
+		// A locked Bitwarden vault loads the cache again once unlocked
+		locked := errors.Is(msg.err, errNoCacheSession) && m.provider.info().name == providerBitwarden
+		if msg.err != nil && !locked {
+			m.statusMsg = "Token cache: " + msg.err.Error()
+		}
+		m.mergeCache(msg.cache)
+
+	case cacheTickMsg:
+		cmds = append(cmds, cacheTick())
+
+	case proxyLogMsg:
+		if m.proxy != nil {
+			m.proxyLog = append(m.proxyLog, msg.line)
+			if len(m.proxyLog) > maxProxyLog {
+				m.proxyLog = m.proxyLog[len(m.proxyLog)-maxProxyLog:]
+			}
+			cmds = append(cmds, waitForProxyLog(m.proxy))
 		}
 
 	case clipboardCopyMsg:
@@ -154,9 +284,9 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		var cmd tea.Cmd
 		m.list, cmd = m.list.Update(msg)
 		cmds = append(cmds, cmd)
-	case bwSelectView:
+	case providerSelectView:
 		var cmd tea.Cmd
-		m.bwSelectList, cmd = m.bwSelectList.Update(msg)
+		m.providerSelectList, cmd = m.providerSelectList.Update(msg)
 		cmds = append(cmds, cmd)
 	case formView:
 		if m.form != nil {
@@ -180,27 +310,39 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 func (m model) handleKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	switch m.mode {
-	case bwPasswordView:
-		return m.handleBWPasswordKey(msg)
-	case bwLoginView:
-		return m.handleBWLoginKey(msg)
-	case bwSelectView:
-		return m.handleBWSelectKey(msg)
+	case providerPasswordView:
+		return m.handleProviderPasswordKey(msg)
+	case providerLoginView:
+		return m.handleProviderLoginKey(msg)
+	case providerSelectView:
+		return m.handleProviderSelectKey(msg)
 	case formView:
 		return m.handleFormKey(msg)
 	case tokenView:
 		return m.handleTokenKey(msg)
+	case deviceCodeView:
+		return m.handleDeviceCodeKey(msg)
+	case dpopProofView:
+		return m.handleDPoPProofKey(msg)
+	case inspectView:
+		return m.handleInspectKey(msg)
+	case proxyInputView:
+		return m.handleProxyInputKey(msg)
+	case proxyView:
+		return m.handleProxyKey(msg)
 	case errorView:
 		return m.handleErrorKey(msg)
 	case deleteView:
 		return m.handleDeleteKey(msg)
+	case revokeView:
+		return m.handleRevokeKey(msg)
 	default:
 		return m.handleListKey(msg)
 	}
 }
 
-func (m model) handleBWPasswordKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
-	if m.bwUnlocking {
+func (m model) handleProviderPasswordKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	if m.providerUnlocking {
 		return m, nil
 	}
 
@@ -211,37 +353,37 @@ func (m model) handleBWPasswordKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 		m.mode = listView
 		return m, nil
 	case "enter":
-		pw := m.bwPwInput.Value()
+		pw := m.providerPwInput.Value()
 		if pw == "" {
 			return m, nil
 		}
-		m.bwUnlocking = true
-		m.bwUnlockErr = ""
-		return m, tea.Batch(m.spinner.Tick, unlockBWVault(pw))
+		m.providerUnlocking = true
+		m.providerUnlockErr = ""
+		return m, tea.Batch(m.spinner.Tick, unlockProvider(m.provider, pw))
 	}
 
 	var cmd tea.Cmd
-	m.bwPwInput, cmd = m.bwPwInput.Update(msg)
+	m.providerPwInput, cmd = m.providerPwInput.Update(msg)
 	return m, cmd
 }
 
-func (m model) handleBWLoginKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+func (m model) handleProviderLoginKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	switch msg.String() {
 	case "q", "ctrl+c":
 		return m, tea.Quit
 	case "r":
-		return m, checkBWStatus(m.bwSession)
+		return m, checkProviderStatus(m.provider, m.session)
 	case "esc":
 		m.mode = listView
 	}
 	return m, nil
 }
 
-func (m model) handleBWSelectKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+func (m model) handleProviderSelectKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	// Let the list handle filtering first
-	if m.bwSelectList.FilterState() == list.Filtering {
+	if m.providerSelectList.FilterState() == list.Filtering {
 		var cmd tea.Cmd
-		m.bwSelectList, cmd = m.bwSelectList.Update(msg)
+		m.providerSelectList, cmd = m.providerSelectList.Update(msg)
 		return m, cmd
 	}
 
@@ -251,23 +393,43 @@ func (m model) handleBWSelectKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	case "esc":
 		m.mode = listView
 		return m, nil
+	case "backspace":
+		if m.providerFolder != "" {
+			parent := path.Dir(m.providerFolder)
+			if parent == "." {
+				parent = ""
+			}
+			m.openProviderFolder(parent)
+			return m, nil
+		}
+	case "tab":
+		// Pick the item from the next provider instead
+		if len(secretProviders) > 1 {
+			cmd, _ := m.awaitProvider(nextProvider(m.provider).info().name, "add")
+			return m, cmd
+		}
 	case "enter":
-		if item, ok := m.bwSelectList.SelectedItem().(BWItem); ok {
-			m.formClient.BitwardenItemID = item.ID
+		if item, ok := m.providerSelectList.SelectedItem().(secretRef); ok {
+			if item.Folder {
+				m.openProviderFolder(item.ID)
+				return m, nil
+			}
+			m.formClient.Provider = providerKey(m.provider)
+			m.formClient.ItemID = item.ID
 			if m.formClient.Name == "" {
 				m.formClient.Name = item.Name
 			}
-			if m.formClient.Issuer == "" && len(item.Login.URIs) > 0 {
-				m.formClient.Issuer = item.Login.URIs[0].URI
+			if m.formClient.Issuer == "" {
+				m.formClient.Issuer = item.URL
 			}
-			m.form = buildClientForm(m.formClient)
+			m.form = buildClientForm(m.formClient, m.provider, m.providerItems, m.clients)
 			m.mode = formView
 			return m, m.form.Init()
 		}
 	}
 
 	var cmd tea.Cmd
-	m.bwSelectList, cmd = m.bwSelectList.Update(msg)
+	m.providerSelectList, cmd = m.providerSelectList.Update(msg)
 	return m, cmd
 }
 
@@ -296,25 +458,215 @@ func (m model) handleFormKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 func (m model) handleTokenKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	switch msg.String() {
 	case "esc":
+		m.cancelTokenRequest()
 		m.mode = listView
 		m.tokenResult = nil
 		m.tokenLoading = false
+		m.authURL = ""
 		return m, nil
 	case "c":
 		if m.tokenResult != nil {
 			return m, copyToClipboard(m.tokenResult.Token.AccessToken, "token")
 		}
+		if m.authURL != "" {
+			return m, copyToClipboard(m.authURL, "authorize URL")
+		}
 	case "h":
 		if m.tokenResult != nil {
-			header := "Authorization: Bearer " + m.tokenResult.Token.AccessToken
+			header := "Authorization: " + m.tokenResult.AuthorizationHeader()
 			return m, copyToClipboard(header, "header")
 		}
+	case "s":
+		if m.tokenResult != nil && m.tokenResult.Subject != nil {
+			return m, copyToClipboard(m.tokenResult.Subject.Token.AccessToken, "subject token")
+		}
+	case "p":
+		if m.tokenResult != nil && m.tokenResult.creds.dpop != nil {
+			m.statusMsg = ""
+			m.dpopInput.Reset()
+			m.dpopInput.Focus()
+			m.mode = dpopProofView
+			return m, m.dpopInput.Cursor.BlinkCmd()
+		}
+	case "j":
+		if m.tokenResult != nil && !m.tokenLoading {
+			m.openInspect("Access Token", m.tokenResult.Token.AccessToken)
+			return m, nil
+		}
+	case "u":
+		if m.tokenResult != nil && !m.tokenLoading {
+			m.statusMsg = "Fetching UserInfo..."
+			return m, userInfoCmd(*m.tokenResult)
+		}
+	case "x":
+		if m.tokenResult != nil && !m.tokenLoading {
+			if m.tokenResult.creds.ClientID == "" && m.tokenResult.Client.needsProvider() {
+				if cmd, wait := m.awaitProvider(m.tokenResult.Client.Provider, "revoke"); wait {
+					return m, cmd
+				}
+			}
+			m.statusMsg = ""
+			m.mode = revokeView
+			return m, nil
+		}
+	case "i":
+		if m.tokenResult != nil && !m.tokenLoading {
+			// Cached tokens need the client credentials from the provider again
+			if m.tokenResult.creds.ClientID == "" && m.tokenResult.Client.needsProvider() {
+				if cmd, wait := m.awaitProvider(m.tokenResult.Client.Provider, "introspect"); wait {
+					return m, cmd
+				}
+			}
+			return m, m.startIntrospection()
+		}
+	case "f":
+		if m.tokenResult != nil && !m.tokenLoading {
+			if cmd, wait := m.awaitClientProvider(m.tokenResult.Client, "token"); wait {
+				return m, cmd
+			}
+			return m, m.startTokenRequest(m.tokenResult.Client, true)
+		}
+	case "r":
+		if m.tokenResult != nil && !m.tokenLoading {
+			if m.tokenResult.Token.RefreshToken == "" {
+				m.statusMsg = "No refresh token for this client"
+				return m, nil
+			}
+			// Cached tokens need the client credentials from the provider again
+			if m.tokenResult.creds.ClientID == "" && m.tokenResult.Client.needsProvider() {
+				if cmd, wait := m.awaitProvider(m.tokenResult.Client.Provider, "refresh"); wait {
+					return m, cmd
+				}
+			}
+			return m, m.startRefresh()
+		}
 	case "q", "ctrl+c":
 		return m, tea.Quit
 	}
 	return m, nil
 }
 
+func (m model) handleDeviceCodeKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "esc":
+		m.cancelTokenRequest()
+		m.mode = listView
+		m.tokenLoading = false
+		m.deviceAuth = nil
+		return m, nil
+	case "c":
+		if m.deviceAuth != nil {
+			return m, copyToClipboard(m.deviceAuth.UserCode, "user code")
+		}
+	case "o":
+		if m.deviceAuth != nil {
+			u := m.deviceAuth.VerificationURIComplete
+			if u == "" {
+				u = m.deviceAuth.VerificationURI
+			}
+			return m, openBrowserCmd(u)
+		}
+	case "ctrl+c":
+		return m, tea.Quit
+	}
+	return m, nil
+}
+
+func (m model) handleDPoPProofKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "ctrl+c":
+		return m, tea.Quit
+	case "esc":
+		m.mode = tokenView
+		return m, nil
+	case "enter":
+		method, target, err := parseProofTarget(m.dpopInput.Value())
+		if err != nil {
+			m.statusMsg = err.Error()
+			return m, nil
+		}
+		proof, err := m.tokenResult.creds.dpop.proof(method, target, m.tokenResult.Token.AccessToken)
+		if err != nil {
+			m.statusMsg = "DPoP proof: " + err.Error()
+			return m, nil
+		}
+		m.statusMsg = ""
+		m.mode = tokenView
+		return m, copyToClipboard(proof, "DPoP proof for "+method+" "+target)
+	}
+
+	var cmd tea.Cmd
+	m.dpopInput, cmd = m.dpopInput.Update(msg)
+	return m, cmd
+}
+
+func (m model) handleInspectKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "ctrl+c":
+		return m, tea.Quit
+	case "esc", "q":
+		m.mode = tokenView
+		return m, nil
+	}
+	var cmd tea.Cmd
+	m.viewport, cmd = m.viewport.Update(msg)
+	return m, cmd
+}
+
+// openProxyInput asks for the upstream of a proxy for the selected client
+func (m model) openProxyInput() (tea.Model, tea.Cmd) {
+	m.statusMsg = ""
+	m.proxyInput.Reset()
+	m.proxyInput.Focus()
+	m.mode = proxyInputView
+	return m, m.proxyInput.Cursor.BlinkCmd()
+}
+
+func (m model) handleProxyInputKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "ctrl+c":
+		return m, tea.Quit
+	case "esc":
+		m.statusMsg = ""
+		m.mode = listView
+		return m, nil
+	case "enter":
+		item, ok := m.list.SelectedItem().(Client)
+		if !ok {
+			m.mode = listView
+			return m, nil
+		}
+		p, err := startTUIProxy(m.proxyInput.Value(), m.session, m.clients, item)
+		if err != nil {
+			m.statusMsg = err.Error()
+			return m, nil
+		}
+		m.statusMsg = ""
+		m.proxy = p
+		m.proxyLog = nil
+		m.mode = proxyView
+		return m, waitForProxyLog(p)
+	}
+
+	var cmd tea.Cmd
+	m.proxyInput, cmd = m.proxyInput.Update(msg)
+	return m, cmd
+}
+
+func (m model) handleProxyKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "ctrl+c":
+		m.proxy.stop()
+		return m, tea.Quit
+	case "esc", "q":
+		m.proxy.stop()
+		m.proxy = nil
+		m.statusMsg = "Proxy stopped"
+		m.mode = listView
+	}
+	return m, nil
+}
+
 func (m model) handleErrorKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	switch msg.String() {
 	case "q", "ctrl+c":
@@ -348,6 +700,34 @@ func (m model) handleDeleteKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	return m, nil
 }
 
+// handleRevokeKey picks which of the shown tokens to revoke
+func (m model) handleRevokeKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	if m.tokenResult == nil {
+		m.mode = listView
+		return m, nil
+	}
+	var tokens []revocation
+	switch msg.String() {
+	case "a":
+		tokens = revocationsFor(m.tokenResult.Token, true, false)
+	case "r":
+		tokens = revocationsFor(m.tokenResult.Token, false, true)
+	case "b", "y", "enter":
+		tokens = revocationsFor(m.tokenResult.Token, true, true)
+	case "n", "esc":
+		m.mode = tokenView
+		return m, nil
+	case "ctrl+c":
+		return m, tea.Quit
+	}
+	if len(tokens) == 0 {
+		return m, nil
+	}
+	m.mode = tokenView
+	m.statusMsg = "Revoking..."
+	return m, revokeCmd(m.settings, m.session, *m.tokenResult, tokens)
+}
+
 func (m model) handleListKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	if m.list.FilterState() == list.Filtering {
 		var cmd tea.Cmd
@@ -359,53 +739,51 @@ func (m model) handleListKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	case "q", "ctrl+c":
 		return m, tea.Quit
 
-	case "enter":
+	case "enter", "f":
 		if item, ok := m.list.SelectedItem().(Client); ok {
-			if !m.bwUnlocked {
-				m.pendingAction = "token"
-				return m.requireBWUnlock()
+			// f forces a new token even if a cached one is still fresh
+			if msg.String() == "enter" {
+				if cached, ok := m.tokenCache.get(item, time.Now()); ok {
+					m.showCachedToken(cached)
+					return m, m.verifyToken()
+				}
 			}
-			m.mode = tokenView
-			m.tokenLoading = true
-			m.tokenResult = nil
-			return m, tea.Batch(m.spinner.Tick, requestToken(m.bwSession, item))
+			if cmd, wait := m.awaitClientProvider(item, "token"); wait {
+				return m, cmd
+			}
+			return m, m.startTokenRequest(item, msg.String() == "f")
 		}
 
 	case "a":
-		if !m.bwUnlocked {
-			m.pendingAction = "add"
-			return m.requireBWUnlock()
+		if cmd, wait := m.awaitProvider(m.provider.info().name, "add"); wait {
+			return m, cmd
 		}
 		m.editingIndex = -1
 		m.formClient = &Client{}
-		m.updateBWSelectList()
-		m.bwSelectList.ResetFilter()
-		m.mode = bwSelectView
+		m.openProviderFolder("")
+		m.mode = providerSelectView
 		return m, nil
 
 	case "e":
 		if item, ok := m.list.SelectedItem().(Client); ok {
-			if !m.bwUnlocked {
-				m.pendingAction = "edit"
-				return m.requireBWUnlock()
+			if cmd, wait := m.awaitProvider(item.Provider, "edit"); wait {
+				return m, cmd
 			}
-			for i, c := range m.clients {
-				if c.Name == item.Name && c.BitwardenItemID == item.BitwardenItemID {
-					m.editingIndex = i
-					break
-				}
+			return m.editClient(item)
+		}
+
+	case "P":
+		if item, ok := m.list.SelectedItem().(Client); ok {
+			if cmd, wait := m.awaitClientProvider(item, "proxy"); wait {
+				return m, cmd
 			}
-			clientCopy := item
-			m.formClient = &clientCopy
-			m.form = buildClientForm(m.formClient)
-			m.mode = formView
-			return m, m.form.Init()
+			return m.openProxyInput()
 		}
 
 	case "d", "x":
 		if item, ok := m.list.SelectedItem().(Client); ok {
 			for i, c := range m.clients {
-				if c.Name == item.Name && c.BitwardenItemID == item.BitwardenItemID {
+				if c.Name == item.Name && c.ItemID == item.ItemID {
 					m.deleteIndex = i
 					break
 				}
@@ -420,20 +798,85 @@ func (m model) handleListKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	return m, cmd
 }
 
-func (m model) requireBWUnlock() (tea.Model, tea.Cmd) {
-	if m.bwChecking {
-		m.statusMsg = "Checking Bitwarden status..."
-		return m, nil
+// awaitProvider makes sure the named provider is active and unlocked before
+// action runs. Otherwise it switches to the provider or asks to unlock it,
+// leaving action pending, and reports that the caller has to wait.
+func (m *model) awaitProvider(provider, action string) (tea.Cmd, bool) {
+	p, err := providerFor(provider)
+	if err != nil {
+		m.statusMsg = err.Error()
+		return nil, true
 	}
-	if m.bwStatus == "unauthenticated" || !m.bwInstalled {
-		m.mode = bwLoginView
-		return m, nil
+	if sameProvider(p, m.provider) && m.providerUnlocked {
+		return nil, false
+	}
+	m.pendingAction = action
+	return m.requireUnlock(p), true
+}
+
+// awaitClientProvider is awaitProvider for the provider a client's token needs.
+// Clients whose subject chain reads nothing from a provider never wait.
+func (m *model) awaitClientProvider(client Client, action string) (tea.Cmd, bool) {
+	vc, ok := firstProviderClient(client, m.clients)
+	if !ok {
+		return nil, false
+	}
+	return m.awaitProvider(vc.Provider, action)
+}
+
+func (m *model) requireUnlock(p secretProvider) tea.Cmd {
+	if !sameProvider(p, m.provider) {
+		m.useProvider(p)
+		return tea.Batch(m.spinner.Tick, checkProviderStatus(p, m.session))
+	}
+	if m.providerChecking {
+		m.statusMsg = "Checking " + m.provider.info().label + " status..."
+		return nil
+	}
+	if m.providerStatus == "unlocked" {
+		// An error from the provider reset providerUnlocked; ask the provider
+		// again rather than prompting for a password it may not take
+		m.providerChecking = true
+		m.statusMsg = "Checking " + m.provider.info().label + " status..."
+		return tea.Batch(m.spinner.Tick, checkProviderStatus(p, m.session))
+	}
+	if m.providerStatus == "unauthenticated" || !m.providerInstalled {
+		m.mode = providerLoginView
+		return nil
+	}
+	m.mode = providerPasswordView
+	m.providerPwInput.Reset()
+	m.providerPwInput.Focus()
+	m.providerUnlockErr = ""
+	return m.providerPwInput.Cursor.BlinkCmd()
+}
+
+// editClient opens the form for a saved client. Providers with per-client
+// settings list the client's items with those settings first, so the
+// pickers browse the client's Vault server, namespace and mount.
+func (m model) editClient(item Client) (tea.Model, tea.Cmd) {
+	for i, c := range m.clients {
+		if c.Name == item.Name && c.ItemID == item.ItemID {
+			m.editingIndex = i
+			break
+		}
+	}
+	clientCopy := item
+	m.formClient = &clientCopy
+	if _, ok := m.provider.(clientConfigurable); ok {
+		m.formItemsFor = item.Name
+		m.mode = listView
+		m.statusMsg = "Listing " + m.provider.info().label + " items..."
+		return m, fetchClientItems(item, m.session)
 	}
-	m.mode = bwPasswordView
-	m.bwPwInput.Reset()
-	m.bwPwInput.Focus()
-	m.bwUnlockErr = ""
-	return m, m.bwPwInput.Cursor.BlinkCmd()
+	return m.openForm(m.providerItems)
+}
+
+// openForm shows the client form, offering items in its pickers
+func (m model) openForm(items []secretRef) (tea.Model, tea.Cmd) {
+	m.form = buildClientForm(m.formClient, m.provider, items, m.clients)
+	m.mode = formView
+	return m, m.form.Init()
 }
 
 func (m model) saveFormClient() (tea.Model, tea.Cmd) {
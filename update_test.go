@@ -1,7 +1,11 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
 	tea "github.com/charmbracelet/bubbletea"
@@ -9,10 +13,10 @@ import (
 
 func TestPendingActionAdd(t *testing.T) {
 	m := initialModel("")
-	m.bwUnlocked = false
-	m.bwChecking = false
-	m.bwInstalled = true
-	m.bwStatus = "locked"
+	m.providerUnlocked = false
+	m.providerChecking = false
+	m.providerInstalled = true
+	m.providerStatus = "locked"
 
 	// Simulate pressing "a" while locked
 	result, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'a'}})
@@ -21,24 +25,25 @@ func TestPendingActionAdd(t *testing.T) {
 	if m.pendingAction != "add" {
 		t.Errorf("expected pendingAction 'add', got %q", m.pendingAction)
 	}
-	if m.mode != bwPasswordView {
-		t.Errorf("expected bwPasswordView, got %v", m.mode)
+	if m.mode != providerPasswordView {
+		t.Errorf("expected providerPasswordView, got %v", m.mode)
 	}
 }
 
 func TestPendingActionResumedAfterItemsFetched(t *testing.T) {
 	m := initialModel("")
-	m.bwUnlocked = true
+	m.providerUnlocked = true
 	m.pendingAction = "add"
 
-	// Simulate bwItemsFetchedMsg arriving after unlock
-	result, _ := m.Update(bwItemsFetchedMsg{
-		items: []BWItem{{ID: "item-1", Name: "Test Item"}},
+	// Simulate providerItemsFetchedMsg arriving after unlock
+	result, _ := m.Update(providerItemsFetchedMsg{
+		provider: providerBitwarden,
+		items:    []secretRef{{ID: "item-1", Name: "Test Item"}},
 	})
 	m = result.(model)
 
-	if m.mode != bwSelectView {
-		t.Errorf("expected bwSelectView after add action, got %v", m.mode)
+	if m.mode != providerSelectView {
+		t.Errorf("expected providerSelectView after add action, got %v", m.mode)
 	}
 	if m.pendingAction != "" {
 		t.Errorf("expected pendingAction cleared, got %q", m.pendingAction)
@@ -50,12 +55,13 @@ func TestPendingActionResumedAfterItemsFetched(t *testing.T) {
 
 func TestPendingActionClearedWhenNone(t *testing.T) {
 	m := initialModel("")
-	m.bwUnlocked = true
-	m.mode = bwPasswordView
+	m.providerUnlocked = true
+	m.mode = providerPasswordView
 	m.pendingAction = ""
 
-	result, _ := m.Update(bwItemsFetchedMsg{
-		items: []BWItem{{ID: "item-1", Name: "Test Item"}},
+	result, _ := m.Update(providerItemsFetchedMsg{
+		provider: providerBitwarden,
+		items:    []secretRef{{ID: "item-1", Name: "Test Item"}},
 	})
 	m = result.(model)
 
@@ -66,13 +72,13 @@ func TestPendingActionClearedWhenNone(t *testing.T) {
 
 func TestPendingActionTokenFlow(t *testing.T) {
 	m := initialModel("")
-	m.bwUnlocked = false
-	m.bwChecking = false
-	m.bwInstalled = true
-	m.bwStatus = "locked"
+	m.providerUnlocked = false
+	m.providerChecking = false
+	m.providerInstalled = true
+	m.providerStatus = "locked"
 
 	// Add a client to the list first
-	m.clients = []Client{{Name: "test", BitwardenItemID: "bw-1", Issuer: "https://auth.example.com"}}
+	m.clients = []Client{{Name: "test", ItemID: "bw-1", Issuer: "https://auth.example.com"}}
 	m.updateList()
 
 	// Simulate pressing Enter while locked
@@ -84,12 +90,29 @@ func TestPendingActionTokenFlow(t *testing.T) {
 	// Just verify the basic flow works without error
 }
 
+func TestPublicClientSkipsProvider(t *testing.T) {
+	m := initialModel("")
+	m.providerUnlocked = false
+	m.providerChecking = false
+	m.providerInstalled = false
+	m.providerStatus = "unauthenticated"
+	m.clients = []Client{{Name: "public", ClientID: "public-app", Issuer: "https://auth.example.com", GrantType: grantAuthorizationCode}}
+	m.updateList()
+
+	result, cmd := m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	m = result.(model)
+	m.cancelTokenRequest()
+	if m.pendingAction != "" || cmd == nil || !m.tokenLoading {
+		t.Errorf("expected the public client's token request to start without the vault, got pending %q", m.pendingAction)
+	}
+}
+
 func TestPendingActionEditFlow(t *testing.T) {
 	m := initialModel("")
-	m.bwUnlocked = false
-	m.bwChecking = false
-	m.bwInstalled = true
-	m.bwStatus = "locked"
+	m.providerUnlocked = false
+	m.providerChecking = false
+	m.providerInstalled = true
+	m.providerStatus = "locked"
 
 	// Simulate pressing "e" while locked
 	result, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'e'}})
@@ -102,63 +125,64 @@ func TestPendingActionEditFlow(t *testing.T) {
 
 func TestStartupForcesLoginWhenUnauthenticated(t *testing.T) {
 	m := initialModel("")
-	m.bwChecking = true
+	m.providerChecking = true
 	m.mode = listView
 
-	// Simulate bwStatusMsg arriving with "unauthenticated"
-	result, _ := m.Update(bwStatusMsg{installed: true, status: "unauthenticated"})
+	// Simulate providerStatusMsg arriving with "unauthenticated"
+	result, _ := m.Update(providerStatusMsg{provider: providerBitwarden, installed: true, status: "unauthenticated"})
 	m = result.(model)
 
-	if m.mode != bwLoginView {
-		t.Errorf("expected bwLoginView on unauthenticated, got %v", m.mode)
+	if m.mode != providerLoginView {
+		t.Errorf("expected providerLoginView on unauthenticated, got %v", m.mode)
 	}
 }
 
 func TestStartupForcesLoginWhenBWNotInstalled(t *testing.T) {
 	m := initialModel("")
-	m.bwChecking = true
+	m.providerChecking = true
 	m.mode = listView
 
-	// Simulate bwStatusMsg arriving with installed=false
-	result, _ := m.Update(bwStatusMsg{installed: false, status: "unauthenticated"})
+	// Simulate providerStatusMsg arriving with installed=false
+	result, _ := m.Update(providerStatusMsg{provider: providerBitwarden, installed: false, status: "unauthenticated"})
 	m = result.(model)
 
-	if m.mode != bwLoginView {
-		t.Errorf("expected bwLoginView when bw not installed, got %v", m.mode)
+	if m.mode != providerLoginView {
+		t.Errorf("expected providerLoginView when bw not installed, got %v", m.mode)
 	}
 }
 
 func TestUnlockKeepsLoadingUntilItemsFetched(t *testing.T) {
 	m := initialModel("")
-	m.bwUnlocking = true
-	m.mode = bwPasswordView
+	m.providerUnlocking = true
+	m.mode = providerPasswordView
 
 	// Simulate successful unlock
-	result, _ := m.Update(bwUnlockResultMsg{session: "test-session"})
+	result, _ := m.Update(providerUnlockResultMsg{provider: providerBitwarden, session: "test-session"})
 	m = result.(model)
 
 	// Should still show loading state while items are being fetched
-	if !m.bwUnlocking {
-		t.Error("expected bwUnlocking to remain true while fetching items")
+	if !m.providerUnlocking {
+		t.Error("expected providerUnlocking to remain true while fetching items")
 	}
-	if m.mode != bwPasswordView {
-		t.Errorf("expected bwPasswordView while loading, got %v", m.mode)
+	if m.mode != providerPasswordView {
+		t.Errorf("expected providerPasswordView while loading, got %v", m.mode)
 	}
 
 	// Now items arrive
-	result, _ = m.Update(bwItemsFetchedMsg{
-		items: []BWItem{{ID: "item-1", Name: "Test"}},
+	result, _ = m.Update(providerItemsFetchedMsg{
+		provider: providerBitwarden,
+		items:    []secretRef{{ID: "item-1", Name: "Test"}},
 	})
 	m = result.(model)
 
-	if m.bwUnlocking {
-		t.Error("expected bwUnlocking to be false after items fetched")
+	if m.providerUnlocking {
+		t.Error("expected providerUnlocking to be false after items fetched")
 	}
 }
 
 func TestBWErrorResetsUnlockState(t *testing.T) {
 	m := initialModel("")
-	m.bwUnlocked = true
+	m.providerUnlocked = true
 	m.mode = tokenView
 	m.tokenLoading = true
 
@@ -168,7 +192,186 @@ func TestBWErrorResetsUnlockState(t *testing.T) {
 	})
 	m = result.(model)
 
-	if m.bwUnlocked {
-		t.Error("expected bwUnlocked to be reset after bitwarden error")
+	if m.providerUnlocked {
+		t.Error("expected providerUnlocked to be reset after bitwarden error")
+	}
+}
+
+func TestAuthURLShownWhileWaiting(t *testing.T) {
+	m := initialModel("")
+	m.mode = tokenView
+	m.tokenLoading = true
+	m.tokenPrompts = make(tuiPrompter, 1)
+
+	result, _ := m.Update(authURLMsg{url: "https://auth.example.com/authorize?state=x"})
+	m = result.(model)
+
+	if m.authURL != "https://auth.example.com/authorize?state=x" {
+		t.Errorf("expected authURL to be stored, got %q", m.authURL)
+	}
+	if !strings.Contains(m.View(), "Authorize in Browser") {
+		t.Error("expected browser authorization view while waiting")
+	}
+
+	// Esc cancels the flow and clears the URL
+	cancelled := false
+	m.tokenCancel = func() { cancelled = true }
+	result, _ = m.Update(tea.KeyMsg{Type: tea.KeyEsc})
+	m = result.(model)
+
+	if !cancelled {
+		t.Error("expected esc to cancel the running token request")
+	}
+	if m.authURL != "" || m.mode != listView {
+		t.Errorf("expected cleared authURL and listView, got %q / %v", m.authURL, m.mode)
+	}
+}
+
+func TestDeviceCodeViewLandsInTokenView(t *testing.T) {
+	m := initialModel("")
+	m.mode = tokenView
+	m.tokenLoading = true
+	m.tokenPrompts = make(tuiPrompter, 1)
+
+	result, _ := m.Update(deviceCodeMsg{auth: DeviceAuthResponse{
+		UserCode:        "WXYZ-1234",
+		VerificationURI: "https://auth.example.com/device",
+	}})
+	m = result.(model)
+
+	if m.mode != deviceCodeView {
+		t.Fatalf("expected deviceCodeView, got %v", m.mode)
+	}
+	if !strings.Contains(m.View(), "WXYZ-1234") {
+		t.Error("expected user code in device view")
+	}
+
+	result, _ = m.Update(tokenResponseMsg{result: TokenResult{Token: TokenResponse{AccessToken: "tok"}}})
+	m = result.(model)
+
+	if m.mode != tokenView {
+		t.Errorf("expected tokenView after success, got %v", m.mode)
+	}
+	if m.deviceAuth != nil {
+		t.Error("expected device auth to be cleared")
+	}
+}
+
+func TestRefreshKeyWithoutRefreshToken(t *testing.T) {
+	m := initialModel("")
+	m.mode = tokenView
+	m.tokenResult = &TokenResult{Token: TokenResponse{AccessToken: "tok"}}
+
+	result, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'r'}})
+	m = result.(model)
+
+	if cmd != nil {
+		t.Error("expected no command without a refresh token")
+	}
+	if m.tokenLoading {
+		t.Error("expected no refresh to start")
+	}
+	if m.statusMsg == "" {
+		t.Error("expected a status message explaining why refresh is unavailable")
+	}
+}
+
+func TestRefreshKeyStartsRefresh(t *testing.T) {
+	m := initialModel("")
+	m.mode = tokenView
+	m.tokenResult = &TokenResult{Token: TokenResponse{AccessToken: "tok", RefreshToken: "rt"}, creds: clientCredentials{ClientID: "app"}}
+
+	result, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'r'}})
+	m = result.(model)
+
+	if cmd == nil || !m.tokenLoading {
+		t.Error("expected refresh to start loading")
+	}
+}
+
+func TestRefreshDroppedAfterLeavingTokenView(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token": "late", "token_type": "Bearer", "expires_in": 300}`))
+	}))
+	defer server.Close()
+	useTLSServer(t, server)
+
+	m := initialModel("")
+	m.mode = tokenView
+	prev := TokenResult{Token: TokenResponse{AccessToken: "tok", RefreshToken: "rt"}, TokenEndpoint: server.URL, creds: clientCredentials{ClientID: "app"}}
+	m.tokenResult = &prev
+	result, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'r'}})
+	m = result.(model)
+	if m.tokenCancel == nil {
+		t.Fatal("expected the refresh to be cancellable")
+	}
+	result, _ = m.Update(tea.KeyMsg{Type: tea.KeyEsc})
+	m = result.(model)
+	if m.tokenCancel != nil {
+		t.Error("expected Esc to cancel the refresh")
+	}
+
+	// A refresh that finishes after it was cancelled reports nothing
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if msg := refreshTokenCmd(ctx, "", prev)(); msg != nil {
+		t.Errorf("expected no message from a cancelled refresh, got %+v", msg)
+	}
+}
+
+func TestRefreshKeyNeedsProviderForCachedTokens(t *testing.T) {
+	m := initialModel("")
+	m.mode = tokenView
+	m.tokenResult = &TokenResult{Token: TokenResponse{AccessToken: "tok", RefreshToken: "rt"}, Client: Client{Name: "api"}}
+
+	result, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'r'}})
+	m = result.(model)
+	if m.pendingAction != "refresh" || m.tokenLoading {
+		t.Errorf("expected the refresh to wait for the vault, got %q", m.pendingAction)
+	}
+}
+
+func TestDPoPProofKey(t *testing.T) {
+	k, err := newDPoPKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := initialModel("")
+	m.mode = tokenView
+	m.tokenResult = &TokenResult{
+		Token: TokenResponse{AccessToken: "tok", TokenType: "DPoP"},
+		creds: clientCredentials{dpop: k},
+	}
+
+	result, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'p'}})
+	m = result.(model)
+	if m.mode != dpopProofView {
+		t.Fatalf("expected dpopProofView, got %v", m.mode)
+	}
+
+	m.dpopInput.SetValue("not a request")
+	result, _ = m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	m = result.(model)
+	if m.mode != dpopProofView || m.statusMsg == "" {
+		t.Errorf("expected to stay in the prompt with an error, got %v / %q", m.mode, m.statusMsg)
+	}
+
+	m.dpopInput.SetValue("DELETE https://api.example.com/orders/1")
+	result, cmd := m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	m = result.(model)
+	if m.mode != tokenView || cmd == nil {
+		t.Errorf("expected copy command and tokenView, got %v", m.mode)
+	}
+}
+
+func TestDPoPProofKeyIgnoredForBearerTokens(t *testing.T) {
+	m := initialModel("")
+	m.mode = tokenView
+	m.tokenResult = &TokenResult{Token: TokenResponse{AccessToken: "tok", TokenType: "Bearer"}}
+
+	result, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'p'}})
+	if result.(model).mode != tokenView {
+		t.Error("expected p to do nothing without a DPoP key")
 	}
 }
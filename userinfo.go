@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// userInfo calls the issuer's UserInfo endpoint (OIDC Core §5.3) with the
+// access token of result. Bound tokens are presented with their client
+// certificate or a DPoP proof.
+func userInfo(result TokenResult) (map[string]any, error) {
+	oidc, err := DiscoverOIDC(result.Client.Issuer)
+	if err != nil {
+		return nil, stageErr(stageDiscovery, "oidc discovery: %w", err)
+	}
+	if result.creds.certificate != nil {
+		oidc = oidc.withMTLSAliases()
+	}
+	if oidc.UserinfoEndpoint == "" {
+		return nil, stageErr(stageDiscovery, "oidc discovery: response missing userinfo_endpoint")
+	}
+	return fetchUserInfo(oidc.UserinfoEndpoint, result)
+}
+
+// fetchUserInfo GETs the UserInfo endpoint. DPoP clients retry once when
+// the server demands a nonce.
+func fetchUserInfo(endpoint string, result TokenResult) (map[string]any, error) {
+	if !strings.HasPrefix(endpoint, "https://") {
+		return nil, fmt.Errorf("userinfo endpoint must use HTTPS: %s", endpoint)
+	}
+	client := httpClient
+	if cert := result.creds.certificate; cert != nil {
+		var err error
+		if client, err = mtlsHTTPClient(*cert); err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := getUserInfo(client, endpoint, result)
+	if err != nil {
+		return nil, err
+	}
+	if dpop := result.creds.dpop; dpop != nil && resp.StatusCode == http.StatusUnauthorized {
+		if nonce := resp.Header.Get("DPoP-Nonce"); nonce != "" {
+			resp.Body.Close()
+			dpop.setNonce(endpoint, nonce)
+			if resp, err = getUserInfo(client, endpoint, result); err != nil {
+				return nil, err
+			}
+		}
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read userinfo response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg := strings.TrimSpace(string(body))
+		if msg == "" {
+			// Bearer errors come in WWW-Authenticate (RFC 6750 §3)
+			msg = resp.Header.Get("WWW-Authenticate")
+		}
+		return nil, fmt.Errorf("userinfo endpoint returned status %d: %s", resp.StatusCode, msg)
+	}
+
+	// Servers may sign (or encrypt) the response instead of returning JSON
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType == "application/jwt" {
+		claims, err := decodeJWTClaims(strings.TrimSpace(string(body)))
+		if err != nil {
+			return nil, fmt.Errorf("userinfo response: %w", err)
+		}
+		return claims, nil
+	}
+	var claims map[string]any
+	if err := json.Unmarshal(body, &claims); err != nil {
+		return nil, fmt.Errorf("failed to parse userinfo response: %w", err)
+	}
+	return claims, nil
+}
+
+func getUserInfo(client *http.Client, endpoint string, result TokenResult) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", result.AuthorizationHeader())
+	req.Header.Set("Accept", "application/json")
+	if dpop := result.creds.dpop; dpop != nil {
+		proof, err := dpop.proof(http.MethodGet, endpoint, result.Token.AccessToken)
+		if err != nil {
+			return nil, fmt.Errorf("DPoP proof: %w", err)
+		}
+		req.Header.Set("DPoP", proof)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("userinfo request failed: %w", err)
+	}
+	return resp, nil
+}
+
+// formatUserInfo renders the UserInfo claims (or why they could not be
+// fetched) followed by the decoded ID token, if there is one
+func formatUserInfo(claims map[string]any, err error, idToken string, now time.Time) string {
+	var b strings.Builder
+	b.WriteString(accentStyle.Render("UserInfo"))
+	b.WriteString("\n")
+	if err != nil {
+		b.WriteString(errorStyle.Render(err.Error()))
+	} else {
+		b.WriteString(prettyClaims(claims, highlightedClaims))
+	}
+
+	if idToken != "" {
+		b.WriteString("\n\n")
+		b.WriteString(titleStyle.Render("ID Token"))
+		b.WriteString("\n\n")
+		b.WriteString(inspectToken(idToken, now))
+	}
+	return b.String()
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+func TestUserInfo(t *testing.T) {
+	idToken := newTestJWT(t, map[string]any{"sub": "alice"})
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		want    string
+		wantErr string
+	}{
+		{
+			name: "json",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Authorization") != "Bearer tok" {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(map[string]any{"sub": "alice", "email": "alice@example.com"})
+			},
+			want: "alice@example.com",
+		},
+		{
+			name: "signed response",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/jwt; charset=utf-8")
+				w.Write([]byte(idToken))
+			},
+			want: "alice",
+		},
+		{
+			name: "bearer error",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope"`)
+				w.WriteHeader(http.StatusForbidden)
+			},
+			wantErr: `status 403: Bearer error="insufficient_scope"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeEndpointIssuer(t, "userinfo_endpoint", tt.handler)
+			result := TokenResult{Token: TokenResponse{AccessToken: "tok", TokenType: "Bearer"}, Client: Client{Issuer: server.URL}}
+			claims, err := userInfo(result)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if claims["sub"] != "alice" || !strings.Contains(prettyClaims(claims, nil), tt.want) {
+				t.Errorf("unexpected claims %v", claims)
+			}
+		})
+	}
+}
+
+func TestUserInfoMissingEndpoint(t *testing.T) {
+	server := newFakeIssuer(t, func(w http.ResponseWriter, r *http.Request) {})
+	_, err := userInfo(TokenResult{Token: TokenResponse{AccessToken: "tok"}, Client: Client{Issuer: server.URL}})
+	if err == nil || !strings.Contains(err.Error(), "userinfo_endpoint") {
+		t.Errorf("expected a missing endpoint error, got %v", err)
+	}
+}
+
+func TestUserInfoDPoP(t *testing.T) {
+	k, err := newDPoPKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var calls int
+	server := newFakeEndpointIssuer(t, "userinfo_endpoint", func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		claims, err := decodeJWTClaims(r.Header.Get("DPoP"))
+		if err != nil || r.Header.Get("Authorization") != "DPoP tok" || claims["ath"] == nil {
+			t.Errorf("expected a DPoP-bound request, got %q / %v", r.Header.Get("Authorization"), claims)
+		}
+		if claims["nonce"] != "n-1" {
+			w.Header().Set("DPoP-Nonce", "n-1")
+			w.Header().Set("WWW-Authenticate", `DPoP error="use_dpop_nonce"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"sub": "alice"})
+	})
+
+	result := TokenResult{Token: TokenResponse{AccessToken: "tok", TokenType: "DPoP"}, Client: Client{Issuer: server.URL}, creds: clientCredentials{dpop: k}}
+	claims, err := userInfo(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims["sub"] != "alice" || calls != 2 {
+		t.Errorf("expected a retry with the nonce, got %v after %d calls", claims, calls)
+	}
+}
+
+func TestUserInfoKey(t *testing.T) {
+	server := newFakeEndpointIssuer(t, "userinfo_endpoint", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"sub": "alice", "preferred_username": "alice.tester"})
+	})
+	m := initialModel("")
+	m.mode = tokenView
+	m.tokenResult = &TokenResult{
+		Token:  TokenResponse{AccessToken: "tok", TokenType: "Bearer", IDToken: newTestJWT(t, map[string]any{"sub": "alice", "nonce": "abc"})},
+		Client: Client{Name: "user", Issuer: server.URL},
+	}
+
+	updated, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'u'}})
+	m = updated.(model)
+	if cmd == nil {
+		t.Fatal("expected a UserInfo request")
+	}
+	updated, _ = m.Update(cmd())
+	m = updated.(model)
+	if m.mode != inspectView {
+		t.Fatalf("expected inspectView, got %v", m.mode)
+	}
+	view := m.View()
+	for _, want := range []string{"alice.tester", "ID Token", `"nonce": "abc"`} {
+		if !strings.Contains(view, want) {
+			t.Errorf("expected %q in the view:\n%s", want, view)
+		}
+	}
+}
+
+func TestFormatUserInfoError(t *testing.T) {
+	out := formatUserInfo(nil, errors.New("userinfo endpoint returned status 401"), "", time.Now())
+	if !strings.Contains(out, "status 401") || strings.Contains(out, "ID Token") {
+		t.Errorf("unexpected rendering:\n%s", out)
+	}
+}
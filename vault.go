@@ -0,0 +1,358 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	providerVault    = "vault"
+	defaultVaultAddr = "https://127.0.0.1:8200"
+	defaultVaultKV   = "secret"
+)
+
+// vaultProvider resolves secrets from a HashiCorp Vault KV v2 engine over
+// Vault's HTTP API. Item IDs are secret paths inside the mount, e.g.
+// oauth/keycloak for secret/data/oauth/keycloak, and field paths are keys
+// of the secret's data.
+//
+// Empty settings come from VAULT_ADDR and VAULT_NAMESPACE, as for the vault
+// CLI; clients may override them with vault_addr, vault_namespace and
+// vault_mount. The token is VAULT_TOKEN or the vault CLI's token helper.
+// VAULT_CACERT, VAULT_CAPATH and VAULT_SKIP_VERIFY set up TLS.
+type vaultProvider struct {
+	addr      string
+	namespace string
+	mount     string
+}
+
+// VaultResponse is the envelope of Vault API responses
+type VaultResponse struct {
+	Data   json.RawMessage `json:"data"`
+	Errors []string        `json:"errors"`
+}
+
+// VaultKVSecret is the data of a KV v2 read
+type VaultKVSecret struct {
+	Data map[string]any `json:"data"`
+}
+
+func (p vaultProvider) info() providerInfo {
+	return providerInfo{
+		name:          providerVault,
+		label:         "Vault",
+		command:       "vault",
+		install:       "brew install hashicorp/tap/vault",
+		login:         "vault login",
+		unlock:        "vault login",
+		fields:        "<key> of the secret's data",
+		idField:       "client_id",
+		secretField:   "client_secret",
+		userField:     "username",
+		passwordField: "password",
+		tree:          true,
+	}
+}
+
+// forClient applies the client's Vault settings
+func (p vaultProvider) forClient(client Client) secretProvider {
+	if client.VaultAddr != "" {
+		p.addr = client.VaultAddr
+	}
+	if client.VaultNamespace != "" {
+		p.namespace = client.VaultNamespace
+	}
+	if client.VaultMount != "" {
+		p.mount = client.VaultMount
+	}
+	return p
+}
+
+// status is "unlocked" once a token is available. Whether Vault accepts it
+// shows when a secret is read, as the address may differ per client.
+func (p vaultProvider) status(string) (bool, string) {
+	if token, err := vaultToken(); err != nil || token == "" {
+		return true, "unauthenticated"
+	}
+	return true, "unlocked"
+}
+
+func (p vaultProvider) unlock(string) (string, error) {
+	return "", fmt.Errorf("sign in to Vault with vault login or VAULT_TOKEN")
+}
+
+// listItems lists the secrets below the mount, walking its folders
+func (p vaultProvider) listItems(string) ([]secretRef, error) {
+	var refs []secretRef
+	var walk func(dir string) error
+	walk = func(dir string) error {
+		var data struct {
+			Keys []string `json:"keys"`
+		}
+		found, err := p.request(p.mountPath()+"/metadata/"+escapeVaultPath(dir)+"?list=true", &data)
+		if err != nil || !found {
+			return err
+		}
+		slices.Sort(data.Keys)
+		for _, key := range data.Keys {
+			if strings.HasSuffix(key, "/") {
+				if err := walk(dir + key); err != nil {
+					return err
+				}
+				continue
+			}
+			refs = append(refs, secretRef{ID: dir + key, Name: dir + key})
+		}
+		return nil
+	}
+	if err := walk(""); err != nil {
+		return nil, err
+	}
+	return refs, nil
+}
+
+// item reads the latest version of a secret
+func (p vaultProvider) item(_, id string) (secretItem, error) {
+	id = strings.Trim(id, "/")
+	if id == "" {
+		return nil, fmt.Errorf("no secret path configured")
+	}
+	var secret VaultKVSecret
+	found, err := p.request(p.mountPath()+"/data/"+escapeVaultPath(id), &secret)
+	if err != nil {
+		return nil, err
+	}
+	if !found || secret.Data == nil {
+		return nil, fmt.Errorf("secret %s/%s not found", p.mountPath(), id)
+	}
+	return vaultSecretItem{path: id, data: secret.Data}, nil
+}
+
+// request GETs a Vault API path into out, reporting false for 404s
+func (p vaultProvider) request(apiPath string, out any) (bool, error) {
+	base, err := parseVaultAddr(p.address())
+	if err != nil {
+		return false, err
+	}
+	token, err := vaultToken()
+	if err != nil {
+		return false, err
+	}
+	if token == "" {
+		return false, fmt.Errorf("no Vault token (set VAULT_TOKEN or run: vault login)")
+	}
+
+	req, err := http.NewRequest(http.MethodGet, strings.TrimRight(base.String(), "/")+"/v1/"+apiPath, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	req.Header.Set("X-Vault-Request", "true")
+	if ns := p.ns(); ns != "" {
+		req.Header.Set("X-Vault-Namespace", ns)
+	}
+
+	client, err := vaultHTTPClient()
+	if err != nil {
+		return false, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("Vault request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return false, fmt.Errorf("read Vault response: %w", err)
+	}
+
+	var envelope VaultResponse
+	_ = json.Unmarshal(body, &envelope)
+	switch {
+	case resp.StatusCode == http.StatusNotFound && len(envelope.Errors) == 0:
+		return false, nil
+	case resp.StatusCode == http.StatusForbidden:
+		return false, fmt.Errorf("permission denied for %s (check the token's policies, or run: vault login)", apiPath)
+	case resp.StatusCode != http.StatusOK:
+		if len(envelope.Errors) > 0 {
+			return false, fmt.Errorf("Vault returned status %d: %s", resp.StatusCode, strings.Join(envelope.Errors, "; "))
+		}
+		return false, fmt.Errorf("Vault returned status %d", resp.StatusCode)
+	}
+	if err := json.Unmarshal(envelope.Data, out); err != nil {
+		return false, fmt.Errorf("parse Vault response: %w", err)
+	}
+	return true, nil
+}
+
+// vaultHTTPClient applies VAULT_CACERT, VAULT_CAPATH and VAULT_SKIP_VERIFY
+// to the shared client, as the vault CLI does, for Vault servers with a
+// private CA
+func vaultHTTPClient() (*http.Client, error) {
+	caCert, caPath := os.Getenv("VAULT_CACERT"), os.Getenv("VAULT_CAPATH")
+	skipVerify := false
+	if v := os.Getenv("VAULT_SKIP_VERIFY"); v != "" {
+		var err error
+		if skipVerify, err = strconv.ParseBool(v); err != nil {
+			return nil, fmt.Errorf("invalid VAULT_SKIP_VERIFY %q", v)
+		}
+	}
+	if caCert == "" && caPath == "" && !skipVerify {
+		return httpClient, nil
+	}
+	base, ok := httpClient.Transport.(*http.Transport)
+	if !ok {
+		return nil, fmt.Errorf("unexpected HTTP transport %T", httpClient.Transport)
+	}
+	transport := base.Clone()
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	transport.TLSClientConfig.InsecureSkipVerify = skipVerify
+
+	// Like the CLI, VAULT_CACERT wins over VAULT_CAPATH
+	var files []string
+	switch {
+	case caCert != "":
+		files = []string{caCert}
+	case caPath != "":
+		entries, err := os.ReadDir(caPath)
+		if err != nil {
+			return nil, fmt.Errorf("read VAULT_CAPATH: %w", err)
+		}
+		for _, e := range entries {
+			if !e.IsDir() {
+				files = append(files, filepath.Join(caPath, e.Name()))
+			}
+		}
+	}
+	if len(files) > 0 {
+		pool := x509.NewCertPool()
+		for _, f := range files {
+			data, err := os.ReadFile(f)
+			if err != nil {
+				return nil, fmt.Errorf("read Vault CA certificate: %w", err)
+			}
+			if !pool.AppendCertsFromPEM(data) && caCert != "" {
+				return nil, fmt.Errorf("no PEM certificates in VAULT_CACERT %s", f)
+			}
+		}
+		transport.TLSClientConfig.RootCAs = pool
+	}
+	return &http.Client{Timeout: httpClient.Timeout, Transport: transport}, nil
+}
+
+func (p vaultProvider) address() string {
+	if p.addr != "" {
+		return p.addr
+	}
+	if addr := os.Getenv("VAULT_ADDR"); addr != "" {
+		return addr
+	}
+	return defaultVaultAddr
+}
+
+func (p vaultProvider) ns() string {
+	if p.namespace != "" {
+		return p.namespace
+	}
+	return os.Getenv("VAULT_NAMESPACE")
+}
+
+func (p vaultProvider) mountPath() string {
+	if mount := strings.Trim(p.mount, "/"); mount != "" {
+		return escapeVaultPath(mount)
+	}
+	return defaultVaultKV
+}
+
+// parseVaultAddr accepts HTTPS addresses, and plain HTTP only on loopback
+// for `vault server -dev`, so tokens never cross the network in the clear
+func parseVaultAddr(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid Vault address %q (use https://host:port)", s)
+	}
+	switch u.Scheme {
+	case "https":
+		return u, nil
+	case "http":
+		if isLoopbackHost(u.Hostname()) {
+			return u, nil
+		}
+		return nil, fmt.Errorf("Vault address %s must use HTTPS (plain HTTP is only allowed on localhost)", s)
+	}
+	return nil, fmt.Errorf("invalid Vault address %q (use https://host:port)", s)
+}
+
+func escapeVaultPath(p string) string {
+	parts := strings.Split(p, "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return strings.Join(parts, "/")
+}
+
+// tokenHelperLine matches token_helper in the vault CLI's config file
+var tokenHelperLine = regexp.MustCompile(`(?m)^\s*token_helper\s*=\s*"([^"]*)"`)
+
+// vaultToken finds the token like the vault CLI: VAULT_TOKEN, else the
+// token_helper from its config file, else ~/.vault-token where the
+// default helper keeps it after vault login
+func vaultToken() (string, error) {
+	if token := os.Getenv("VAULT_TOKEN"); token != "" {
+		return token, nil
+	}
+	home, _ := os.UserHomeDir()
+	config := os.Getenv("VAULT_CONFIG_PATH")
+	if config == "" {
+		config = filepath.Join(home, ".vault")
+	}
+	if data, err := os.ReadFile(config); err == nil {
+		if m := tokenHelperLine.FindSubmatch(data); m != nil && len(m[1]) > 0 {
+			out, err := exec.Command(string(m[1]), "get").Output()
+			if err != nil {
+				return "", fmt.Errorf("Vault token helper %s: %w", m[1], err)
+			}
+			return strings.TrimSpace(string(out)), nil
+		}
+	}
+	data, err := os.ReadFile(filepath.Join(home, ".vault-token"))
+	if err != nil {
+		return "", nil
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// vaultSecretItem resolves keys of a KV secret's data
+type vaultSecretItem struct {
+	path string
+	data map[string]any
+}
+
+func (i vaultSecretItem) field(path string) (string, error) {
+	v, ok := i.data[path]
+	if !ok {
+		return "", fmt.Errorf("key %q not found in Vault secret %s", path, i.path)
+	}
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
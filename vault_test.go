@@ -0,0 +1,309 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// fakeVault serves a KV v2 engine at secret/ and one at team-kv/ inside the
+// "team" namespace, accepting the token "tok"
+func fakeVault(t *testing.T) *httptest.Server {
+	t.Helper()
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method != http.MethodGet || r.Header.Get("X-Vault-Request") != "true" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		}
+		if r.Header.Get("X-Vault-Token") != "tok" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+		key := r.Header.Get("X-Vault-Namespace") + " " + r.URL.Path
+		if r.URL.Query().Get("list") == "true" {
+			key += "?list"
+		}
+		responses := map[string]string{
+			" /v1/secret/data/oauth/keycloak":     `{"data":{"data":{"client_id":"my-client","client_secret":"s3cret","port":8443},"metadata":{"version":3}}}`,
+			" /v1/secret/data/oauth/deleted":      `{"data":{"data":null,"metadata":{"version":2,"deletion_time":"2026-10-01T00:00:00Z"}}}`,
+			" /v1/secret/metadata/?list":          `{"data":{"keys":["top","oauth/"]}}`,
+			" /v1/secret/metadata/oauth/?list":    `{"data":{"keys":["keycloak","deleted"]}}`,
+			"team /v1/team-kv/data/svc/okta":      `{"data":{"data":{"id":"okta-client","secret":"team-secret"}}}`,
+			"team /v1/team-kv/metadata/?list":     `{"data":{"keys":["svc/"]}}`,
+			"team /v1/team-kv/metadata/svc/?list": `{"data":{"keys":["okta"]}}`,
+			" /v1/sys/broken":                     `{"errors":["internal error"]}`,
+		}
+		body, ok := responses[key]
+		switch {
+		case strings.HasSuffix(key, "/broken"):
+			w.WriteHeader(http.StatusInternalServerError)
+		case strings.HasSuffix(key, "/deleted"):
+			// Vault answers 404 with the metadata of a deleted version
+			w.WriteHeader(http.StatusNotFound)
+		case !ok:
+			w.WriteHeader(http.StatusNotFound)
+			body = `{"errors":[]}`
+		}
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	useTLSServer(t, server)
+	t.Setenv("VAULT_ADDR", server.URL)
+	t.Setenv("VAULT_TOKEN", "tok")
+	t.Setenv("VAULT_NAMESPACE", "")
+	t.Setenv("VAULT_CACERT", "")
+	t.Setenv("VAULT_CAPATH", "")
+	t.Setenv("VAULT_SKIP_VERIFY", "")
+	return server
+}
+
+func TestVaultCredentials(t *testing.T) {
+	server := fakeVault(t)
+	tests := []struct {
+		name    string
+		client  Client
+		token   string
+		id      string
+		secret  string
+		wantErr string
+	}{
+		{
+			name:   "default fields",
+			client: Client{Provider: providerVault, ItemID: "oauth/keycloak"},
+			id:     "my-client", secret: "s3cret",
+		},
+		{
+			name:   "non-string value",
+			client: Client{Provider: providerVault, ItemID: "oauth/keycloak", ClientSecretField: "port"},
+			id:     "my-client", secret: "8443",
+		},
+		{
+			name:   "per-client mount and namespace",
+			client: Client{Provider: providerVault, ItemID: "svc/okta", VaultMount: "team-kv", VaultNamespace: "team", ClientIDField: "id", ClientSecretField: "secret"},
+			id:     "okta-client", secret: "team-secret",
+		},
+		{
+			name:   "per-client address",
+			client: Client{Provider: providerVault, ItemID: "oauth/keycloak", VaultAddr: server.URL},
+			id:     "my-client", secret: "s3cret",
+		},
+		{
+			name:    "missing key",
+			client:  Client{Provider: providerVault, ItemID: "oauth/keycloak", ClientSecretField: "token"},
+			wantErr: `key "token" not found in Vault secret oauth/keycloak`,
+		},
+		{
+			name:    "missing secret",
+			client:  Client{Provider: providerVault, ItemID: "oauth/okta"},
+			wantErr: "vault: secret secret/oauth/okta not found",
+		},
+		{
+			name:    "deleted version",
+			client:  Client{Provider: providerVault, ItemID: "oauth/deleted"},
+			wantErr: "vault: secret secret/oauth/deleted not found",
+		},
+		{
+			name:    "rejected token",
+			client:  Client{Provider: providerVault, ItemID: "oauth/keycloak"},
+			token:   "expired",
+			wantErr: "vault: permission denied for secret/data/oauth/keycloak",
+		},
+		{
+			name:    "plain HTTP",
+			client:  Client{Provider: providerVault, ItemID: "oauth/keycloak", VaultAddr: "http://vault.example.com:8200"},
+			wantErr: "must use HTTPS",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.token != "" {
+				t.Setenv("VAULT_TOKEN", tt.token)
+			}
+			creds, err := resolveClientCredentials("", tt.client)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if creds.ClientID != tt.id || creds.ClientSecret != tt.secret {
+				t.Errorf("expected %s/%s, got %+v", tt.id, tt.secret, creds)
+			}
+		})
+	}
+}
+
+func TestVaultListItems(t *testing.T) {
+	fakeVault(t)
+	refs, err := vaultProvider{}.listItems("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, r := range refs {
+		ids = append(ids, r.ID)
+	}
+	if got := strings.Join(ids, " "); got != "oauth/deleted oauth/keycloak top" {
+		t.Errorf("unexpected secrets %q", got)
+	}
+
+	if refs, err := (vaultProvider{mount: "empty"}).listItems(""); err != nil || len(refs) != 0 {
+		t.Errorf("expected an empty mount to list nothing, got %v, %v", refs, err)
+	}
+	_, err = vaultProvider{}.request("sys/broken", new(any))
+	if err == nil || err.Error() != "Vault returned status 500: internal error" {
+		t.Errorf("expected Vault's error, got %v", err)
+	}
+}
+
+func TestVaultEditListsClientItems(t *testing.T) {
+	fakeVault(t)
+	client := Client{Name: "okta", Provider: providerVault, ItemID: "svc/okta", VaultNamespace: "team", VaultMount: "team-kv"}
+	m := initialModel("")
+	m.useProvider(vaultProvider{})
+	m.providerChecking = false
+	m.providerUnlocked = true
+	m.providerItems = []secretRef{{ID: "oauth/keycloak", Name: "oauth/keycloak"}}
+	m.clients = []Client{client}
+
+	result, cmd := m.editClient(client)
+	m = result.(model)
+	if m.mode != listView || cmd == nil {
+		t.Fatalf("expected the client's items to be listed first, got mode %v", m.mode)
+	}
+	msg := cmd().(clientItemsFetchedMsg)
+	if msg.err != nil || len(msg.items) != 1 || msg.items[0].ID != "svc/okta" {
+		t.Fatalf("expected the items of the client's namespace and mount, got %+v, %v", msg.items, msg.err)
+	}
+	result, _ = m.Update(msg)
+	m = result.(model)
+	if m.mode != formView || m.formClient.VaultMount != "team-kv" || m.formClient.VaultNamespace != "team" {
+		t.Errorf("expected the form with the client's Vault settings, got mode %v, %+v", m.mode, m.formClient)
+	}
+}
+
+func TestVaultTLSSettings(t *testing.T) {
+	server := fakeVault(t)
+	// The shared client no longer trusts the test server's CA
+	orig := httpClient
+	httpClient = &http.Client{Timeout: orig.Timeout, Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{MinVersion: tls.VersionTLS12},
+	}}
+	t.Cleanup(func() { httpClient = orig })
+
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caFile, ca, 0600); err != nil {
+		t.Fatal(err)
+	}
+	client := Client{Provider: providerVault, ItemID: "oauth/keycloak"}
+
+	tests := []struct {
+		name    string
+		env     map[string]string
+		wantErr string
+	}{
+		{name: "untrusted CA", wantErr: "certificate"},
+		{name: "VAULT_CACERT", env: map[string]string{"VAULT_CACERT": caFile}},
+		{name: "VAULT_CAPATH", env: map[string]string{"VAULT_CAPATH": dir}},
+		{name: "VAULT_CACERT wins", env: map[string]string{"VAULT_CACERT": caFile, "VAULT_CAPATH": t.TempDir()}},
+		{name: "VAULT_SKIP_VERIFY", env: map[string]string{"VAULT_SKIP_VERIFY": "true"}},
+		{name: "missing VAULT_CACERT", env: map[string]string{"VAULT_CACERT": filepath.Join(dir, "missing.pem")}, wantErr: "read Vault CA certificate"},
+		{name: "invalid VAULT_SKIP_VERIFY", env: map[string]string{"VAULT_SKIP_VERIFY": "maybe"}, wantErr: "invalid VAULT_SKIP_VERIFY"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			creds, err := resolveClientCredentials("", client)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if creds.ClientSecret != "s3cret" {
+				t.Errorf("unexpected credentials %+v", creds)
+			}
+		})
+	}
+}
+
+func TestVaultToken(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("VAULT_TOKEN", "")
+	t.Setenv("VAULT_CONFIG_PATH", "")
+
+	if token, err := vaultToken(); err != nil || token != "" {
+		t.Fatalf("expected no token, got %q, %v", token, err)
+	}
+	if err := requireProvider("", Client{Provider: providerVault}); !isProviderLocked(err) || !strings.Contains(err.Error(), "vault login") {
+		t.Errorf("expected a hint to log in, got %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(home, ".vault-token"), []byte("from-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if token, _ := vaultToken(); token != "from-file" {
+		t.Errorf("expected the default helper's token, got %q", token)
+	}
+
+	if runtime.GOOS != "windows" {
+		helper := filepath.Join(home, "helper")
+		if err := os.WriteFile(helper, []byte("#!/bin/sh\n[ \"$1\" = get ] && echo from-helper\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		config := filepath.Join(home, "vault.hcl")
+		if err := os.WriteFile(config, []byte("# tkz test\ntoken_helper = \""+helper+"\"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		t.Setenv("VAULT_CONFIG_PATH", config)
+		if token, err := vaultToken(); err != nil || token != "from-helper" {
+			t.Errorf("expected the token helper's token, got %q, %v", token, err)
+		}
+	}
+
+	t.Setenv("VAULT_TOKEN", "from-env")
+	if token, _ := vaultToken(); token != "from-env" {
+		t.Errorf("expected VAULT_TOKEN to win, got %q", token)
+	}
+	if err := requireProvider("", Client{Provider: providerVault}); err != nil {
+		t.Errorf("expected a token to be enough, got %v", err)
+	}
+}
+
+func TestParseVaultAddr(t *testing.T) {
+	tests := []struct {
+		addr    string
+		wantErr bool
+	}{
+		{"https://vault.example.com:8200", false},
+		{"http://127.0.0.1:8200", false},
+		{"http://localhost:8200", false},
+		{"http://vault.example.com:8200", true},
+		{"vault.example.com:8200", true},
+		{"", true},
+	}
+	for _, tt := range tests {
+		_, err := parseVaultAddr(tt.addr)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseVaultAddr(%q): got error %v, wantErr %v", tt.addr, err, tt.wantErr)
+		}
+	}
+}
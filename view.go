@@ -3,74 +3,88 @@ package main
 import (
 	"fmt"
 	"strings"
+	"time"
 )
 
 func (m model) View() string {
 	switch m.mode {
-	case bwPasswordView:
-		return m.viewBWPassword()
-	case bwLoginView:
-		return m.viewBWLogin()
-	case bwSelectView:
-		return m.viewBWSelect()
+	case providerPasswordView:
+		return m.viewProviderPassword()
+	case providerLoginView:
+		return m.viewProviderLogin()
+	case providerSelectView:
+		return m.viewProviderSelect()
 	case formView:
 		return m.viewForm()
 	case tokenView:
 		return m.viewToken()
+	case deviceCodeView:
+		return m.viewDeviceCode()
+	case dpopProofView:
+		return m.viewDPoPProof()
+	case inspectView:
+		return m.viewInspect()
+	case proxyInputView:
+		return m.viewProxyInput()
+	case proxyView:
+		return m.viewProxy()
 	case errorView:
 		return m.viewError()
 	case deleteView:
 		return m.viewDelete()
+	case revokeView:
+		return m.viewRevoke()
 	default:
 		return m.viewList()
 	}
 }
 
-func (m model) viewBWPassword() string {
+func (m model) viewProviderPassword() string {
 	var b strings.Builder
-	b.WriteString(titleStyle.Render("Unlock Bitwarden Vault"))
+	b.WriteString(titleStyle.Render("Unlock " + m.provider.info().label))
 	b.WriteString("\n\n")
 
-	if m.bwUnlocking {
+	if m.providerUnlocking {
 		b.WriteString(m.spinner.View())
-		if m.bwUnlocked {
-			b.WriteString(" Loading vault items...")
+		if m.providerUnlocked {
+			b.WriteString(" Loading " + m.provider.info().label + " items...")
 		} else {
-			b.WriteString(" Unlocking vault...")
+			b.WriteString(" Unlocking " + m.provider.info().label + "...")
 		}
 		b.WriteString("\n\n")
 		return b.String()
 	}
 
-	if m.bwUnlockErr != "" {
-		b.WriteString(errorStyle.Render(m.bwUnlockErr))
+	if m.providerUnlockErr != "" {
+		b.WriteString(errorStyle.Render(m.providerUnlockErr))
 		b.WriteString("\n\n")
 	}
 
-	b.WriteString("Master password: ")
-	b.WriteString(m.bwPwInput.View())
+	b.WriteString(m.provider.info().prompt + ": ")
+	b.WriteString(m.providerPwInput.View())
 	b.WriteString("\n\n")
 	b.WriteString(helpStyle.Render("enter: unlock • esc: back • ctrl+c: quit"))
 
 	return b.String()
 }
 
-func (m model) viewBWLogin() string {
+func (m model) viewProviderLogin() string {
+	info := m.provider.info()
 	var b strings.Builder
-	b.WriteString(titleStyle.Render("Bitwarden Not Logged In"))
+	b.WriteString(titleStyle.Render(info.label + " Not Logged In"))
 	b.WriteString("\n\n")
 
-	if !m.bwInstalled {
-		b.WriteString("The Bitwarden CLI (")
-		b.WriteString(accentStyle.Render("bw"))
+	if !m.providerInstalled {
+		b.WriteString("The " + info.label + " CLI (")
+		b.WriteString(accentStyle.Render(info.command))
 		b.WriteString(") was not found.\n\n")
 		b.WriteString("Install it:\n\n")
-		b.WriteString(accentStyle.Render("  brew install bitwarden-cli"))
+		b.WriteString(accentStyle.Render("  " + info.install))
 		b.WriteString("\n\n")
 	} else {
-		b.WriteString("You need to log in to Bitwarden first.\n\n")
+		b.WriteString("You need to log in to " + info.label + " first.\n\n")
 		b.WriteString("Run in another terminal:\n\n")
-		b.WriteString(accentStyle.Render("  bw login"))
+		b.WriteString(accentStyle.Render("  " + info.login))
 		b.WriteString("\n\n")
 		b.WriteString("Then press ")
 		b.WriteString(accentStyle.Render("r"))
@@ -82,8 +96,22 @@ func (m model) viewBWLogin() string {
 	return b.String()
 }
 
-func (m model) viewBWSelect() string {
-	return m.bwSelectList.View()
+func (m model) viewProviderSelect() string {
+	var help []string
+	if m.provider.info().tree {
+		help = append(help, "enter: open folder", "backspace: parent folder")
+	}
+	if len(secretProviders) > 1 {
+		help = append(help, "tab: pick from "+nextProvider(m.provider).info().label)
+	}
+	if len(help) == 0 {
+		return m.providerSelectList.View()
+	}
+	var b strings.Builder
+	b.WriteString(m.providerSelectList.View())
+	b.WriteString("\n")
+	b.WriteString(helpStyle.Render(strings.Join(help, " • ")))
+	return b.String()
 }
 
 func (m model) viewForm() string {
@@ -103,6 +131,23 @@ func (m model) viewForm() string {
 func (m model) viewToken() string {
 	var b strings.Builder
 
+	if m.tokenLoading && m.authURL != "" {
+		b.WriteString(titleStyle.Render("Authorize in Browser"))
+		b.WriteString("\n\n")
+		b.WriteString(m.spinner.View())
+		b.WriteString(" Waiting for authorization in your browser...")
+		b.WriteString("\n\n")
+		b.WriteString("If it did not open, visit:\n\n")
+		b.WriteString(accentStyle.Render(m.authURL))
+		b.WriteString("\n\n")
+		if m.statusMsg != "" {
+			b.WriteString(successStyle.Render(m.statusMsg))
+			b.WriteString("\n\n")
+		}
+		b.WriteString(helpStyle.Render("c: copy URL • esc: cancel"))
+		return b.String()
+	}
+
 	if m.tokenLoading {
 		b.WriteString(titleStyle.Render("Requesting Token"))
 		b.WriteString("\n\n")
@@ -117,10 +162,7 @@ func (m model) viewToken() string {
 		b.WriteString(titleStyle.Render("Token for " + m.tokenResult.Client.Name))
 		b.WriteString("\n\n")
 
-		truncated := m.tokenResult.Token.AccessToken
-		if len(truncated) > 80 {
-			truncated = truncated[:40] + "..." + truncated[len(truncated)-40:]
-		}
+		truncated := truncateMiddle(m.tokenResult.Token.AccessToken, 80)
 
 		content := fmt.Sprintf(
 			"%s %s\n%s %d seconds\n%s %s",
@@ -131,6 +173,18 @@ func (m model) viewToken() string {
 			accentStyle.Render("Token:"),
 			tokenStyle.Render(truncated),
 		)
+		content += fmt.Sprintf("\n%s %s", accentStyle.Render("Format:"), describeTokenFormat(m.tokenResult.Token.AccessToken))
+		if tokenFormat(m.tokenResult.Token.AccessToken) == formatJWT {
+			content += "\n" + viewVerification(m.tokenVerify)
+		}
+
+		if m.tokenCached {
+			content += fmt.Sprintf("\n%s fetched %s ago, %s left",
+				accentStyle.Render("Cached:"),
+				formatRemaining(time.Since(m.tokenResult.FetchedAt)),
+				formatRemaining(time.Until(m.tokenResult.ExpiresAt())),
+			)
+		}
 
 		if m.tokenResult.Token.Scope != "" {
 			content += fmt.Sprintf("\n%s %s",
@@ -139,20 +193,234 @@ func (m model) viewToken() string {
 			)
 		}
 
+		if rt := m.tokenResult.Token.RefreshToken; rt != "" {
+			content += fmt.Sprintf("\n\n%s %s",
+				accentStyle.Render("Refresh:"),
+				dimStyle.Render(truncateMiddle(rt, 60)),
+			)
+			if m.tokenResult.Token.RefreshExpiresIn > 0 {
+				content += fmt.Sprintf("\n%s %d seconds",
+					accentStyle.Render("Refresh expires:"),
+					m.tokenResult.Token.RefreshExpiresIn,
+				)
+			}
+		}
+
+		if idt := m.tokenResult.Token.IDToken; idt != "" {
+			content += fmt.Sprintf("\n%s %s",
+				accentStyle.Render("ID token:"),
+				dimStyle.Render(truncateMiddle(idt, 60)),
+			)
+		}
+
+		if t := m.tokenResult.Token.IssuedTokenType; t != "" {
+			content += fmt.Sprintf("\n%s %s", accentStyle.Render("Issued type:"), t)
+		}
+
+		if bound, presented := m.tokenResult.certThumbprints(); bound != "" || presented != "" {
+			content += "\n\n" + viewKeyBinding("cnf.x5t#S256:", bound, presented, "client certificate")
+		}
+		if bound, presented := m.tokenResult.dpopThumbprints(); bound != "" || presented != "" {
+			content += "\n\n" + viewKeyBinding("cnf.jkt:", bound, presented, "DPoP key")
+		}
+
 		b.WriteString(tokenBoxStyle.Render(content))
 		b.WriteString("\n\n")
 
+		if sub := m.tokenResult.Subject; sub != nil {
+			b.WriteString(dimStyle.Render("Exchanged from subject token of " + sub.Client.Name + ":"))
+			b.WriteString("\n")
+			b.WriteString(dimStyle.Render(truncateMiddle(sub.Token.AccessToken, 80)))
+			b.WriteString("\n\n")
+		}
+
 		if m.statusMsg != "" {
 			b.WriteString(successStyle.Render(m.statusMsg))
 			b.WriteString("\n\n")
 		}
 
-		b.WriteString(helpStyle.Render("c: copy token • h: copy as Authorization header • esc: back"))
+		help := "c: copy token • h: copy as Authorization header • j: inspect • i: introspect • u: userinfo"
+		if m.tokenResult.Subject != nil {
+			help += " • s: copy subject token"
+		}
+		if m.tokenResult.creds.dpop != nil {
+			help += " • p: copy DPoP proof"
+		}
+		if m.tokenResult.Token.RefreshToken != "" {
+			help += " • r: refresh"
+		}
+		help += " • x: revoke • f: force new token"
+		b.WriteString(helpStyle.Render(help + " • esc: back"))
+	}
+
+	return b.String()
+}
+
+// viewVerification renders the signature badge and the iss/aud checks, or
+// a placeholder while the JWKS is being fetched
+func viewVerification(v *tokenVerification) string {
+	label := accentStyle.Render("Signature:") + " "
+	if v == nil {
+		return label + dimStyle.Render("verifying against the issuer's JWKS...")
+	}
+	var line string
+	switch v.Signature {
+	case sigValid:
+		line = label + successStyle.Render("✓ "+v.signatureSummary())
+	case sigInvalid:
+		line = label + errorStyle.Render("✗ "+v.signatureSummary())
+	default:
+		line = label + warningStyle.Render("? "+v.signatureSummary())
+	}
+	checks := []struct {
+		label string
+		check *claimCheck
+	}{
+		{"Issuer:", v.Issuer},
+		{"Audience:", v.Audience},
+		{"Expiry:", v.Expiry},
+	}
+	for _, c := range checks {
+		if c.check == nil || (c.label == "Expiry:" && c.check.OK) {
+			continue
+		}
+		mark := successStyle.Render("✓")
+		if !c.check.OK {
+			mark = errorStyle.Render("✗")
+		}
+		line += fmt.Sprintf("\n%s %s %s", accentStyle.Render(c.label), mark, c.check)
+	}
+	return line
+}
+
+// viewKeyBinding shows the key thumbprint a token is bound to (bound)
+// next to the one of the certificate or key tkz presented
+func viewKeyBinding(label, bound, presented, holder string) string {
+	line := accentStyle.Render(label) + " "
+	switch {
+	case bound == "":
+		return line + dimStyle.Render("not bound ("+holder+" "+presented+")")
+	case presented == "":
+		return line + bound
+	case bound == presented:
+		return line + bound + " " + successStyle.Render("✓ matches "+holder)
+	default:
+		return line + bound + " " + errorStyle.Render("✗ "+holder+" is "+presented)
+	}
+}
+
+func (m model) viewDPoPProof() string {
+	var b strings.Builder
+	b.WriteString(titleStyle.Render("DPoP Proof"))
+	b.WriteString("\n\n")
+	b.WriteString("HTTP method and URL of the request to sign a proof for:\n\n")
+	b.WriteString(m.dpopInput.View())
+	b.WriteString("\n\n")
+	if m.statusMsg != "" {
+		b.WriteString(errorStyle.Render(m.statusMsg))
+		b.WriteString("\n\n")
+	}
+	b.WriteString(helpStyle.Render("enter: copy proof • esc: back"))
+	return b.String()
+}
+
+func (m model) viewInspect() string {
+	var b strings.Builder
+	b.WriteString(titleStyle.Render(m.inspectTitle))
+	b.WriteString("\n\n")
+	b.WriteString(m.viewport.View())
+	b.WriteString("\n\n")
+	b.WriteString(helpStyle.Render("↑/↓: scroll • esc: back"))
+	return b.String()
+}
+
+func (m model) viewProxyInput() string {
+	var b strings.Builder
+	b.WriteString(titleStyle.Render("Start Proxy"))
+	b.WriteString("\n\n")
+	if item, ok := m.list.SelectedItem().(Client); ok {
+		b.WriteString("Forward requests with the token of " + accentStyle.Render(item.Name) + " to:\n\n")
+	}
+	b.WriteString(m.proxyInput.View())
+	b.WriteString("\n\n")
+	b.WriteString(dimStyle.Render("Upstream URL, optionally followed by the listen address (default " + defaultProxyListen + ")"))
+	b.WriteString("\n\n")
+	if m.statusMsg != "" {
+		b.WriteString(errorStyle.Render(m.statusMsg))
+		b.WriteString("\n\n")
+	}
+	b.WriteString(helpStyle.Render("enter: start • esc: back"))
+	return b.String()
+}
+
+func (m model) viewProxy() string {
+	var b strings.Builder
+	b.WriteString(titleStyle.Render("Proxy"))
+	b.WriteString("\n\n")
+	b.WriteString(fmt.Sprintf("%s http://%s → %s (%s)",
+		accentStyle.Render("Listening:"), m.proxy.addr, m.proxy.route.upstream, m.proxy.route.client))
+	b.WriteString("\n\n")
+
+	// Show as many of the latest lines as fit
+	lines := m.proxyLog
+	if room := m.height - 8; room > 0 && len(lines) > room {
+		lines = lines[len(lines)-room:]
+	}
+	if len(lines) == 0 {
+		b.WriteString(dimStyle.Render("Waiting for requests..."))
+	} else {
+		b.WriteString(strings.Join(lines, "\n"))
+	}
+	b.WriteString("\n\n")
+	b.WriteString(helpStyle.Render("esc: stop proxy"))
+	return b.String()
+}
+
+func (m model) viewDeviceCode() string {
+	var b strings.Builder
+	b.WriteString(titleStyle.Render("Authorize Device"))
+	b.WriteString("\n\n")
+
+	if m.deviceAuth != nil {
+		content := fmt.Sprintf(
+			"%s %s\n%s %s",
+			accentStyle.Render("Visit:"),
+			m.deviceAuth.VerificationURI,
+			accentStyle.Render("Code: "),
+			tokenStyle.Render(m.deviceAuth.UserCode),
+		)
+		if m.deviceAuth.VerificationURIComplete != "" {
+			content += fmt.Sprintf("\n\n%s\n%s",
+				dimStyle.Render("Or open directly:"),
+				m.deviceAuth.VerificationURIComplete,
+			)
+		}
+		b.WriteString(tokenBoxStyle.Render(content))
+		b.WriteString("\n\n")
+	}
+
+	b.WriteString(m.spinner.View())
+	b.WriteString(" Waiting for authorization...")
+	b.WriteString("\n\n")
+
+	if m.statusMsg != "" {
+		b.WriteString(successStyle.Render(m.statusMsg))
+		b.WriteString("\n\n")
 	}
 
+	b.WriteString(helpStyle.Render("c: copy code • o: open in browser • esc: cancel"))
 	return b.String()
 }
 
+// truncateMiddle shortens s to about max characters, keeping both ends
+func truncateMiddle(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	half := max / 2
+	return s[:half] + "..." + s[len(s)-half:]
+}
+
 func (m model) viewError() string {
 	var b strings.Builder
 	b.WriteString(errorStyle.Render("Error"))
@@ -176,13 +444,30 @@ func (m model) viewDelete() string {
 	return b.String()
 }
 
+func (m model) viewRevoke() string {
+	var b strings.Builder
+	if m.tokenResult == nil {
+		return b.String()
+	}
+	b.WriteString(warningStyle.Render("Revoke tokens of " + m.tokenResult.Client.Name + "?"))
+	b.WriteString("\n\n")
+	b.WriteString("The authorization server invalidates them and cached copies are dropped.")
+	b.WriteString("\n\n")
+	if m.tokenResult.Token.RefreshToken != "" {
+		b.WriteString(helpStyle.Render("a: access token • r: refresh token • b/enter: both • esc: cancel"))
+	} else {
+		b.WriteString(helpStyle.Render("a/enter: revoke access token • esc: cancel"))
+	}
+	return b.String()
+}
+
 func (m model) viewList() string {
-	if m.bwChecking {
+	if m.providerChecking {
 		var b strings.Builder
 		b.WriteString(titleStyle.Render("tkz"))
 		b.WriteString("\n\n")
 		b.WriteString(m.spinner.View())
-		b.WriteString(" Connecting to Bitwarden...")
+		b.WriteString(" Connecting to " + m.provider.info().label + "...")
 		b.WriteString("\n\n")
 		b.WriteString(helpStyle.Render("ctrl+c: quit"))
 		return b.String()
@@ -197,17 +482,17 @@ func (m model) viewList() string {
 		b.WriteString(" ")
 	}
 
-	bwIndicator := ""
-	if !m.bwInstalled {
-		bwIndicator = errorStyle.Render("[bw not found]")
-	} else if !m.bwUnlocked {
-		bwIndicator = warningStyle.Render("[vault locked]")
+	providerIndicator := ""
+	if !m.providerInstalled {
+		providerIndicator = errorStyle.Render("[" + m.provider.info().command + " not found]")
+	} else if !m.providerUnlocked {
+		providerIndicator = warningStyle.Render("[" + m.provider.info().label + " locked]")
 	} else {
-		bwIndicator = successStyle.Render("[vault unlocked]")
+		providerIndicator = successStyle.Render("[" + m.provider.info().label + " unlocked]")
 	}
-	b.WriteString(bwIndicator)
+	b.WriteString(providerIndicator)
 	b.WriteString("\n")
-	b.WriteString(helpStyle.Render("enter: get token • a: add • e: edit • d: delete • /: filter • q: quit"))
+	b.WriteString(helpStyle.Render("enter: get token • f: force new token • P: proxy • a: add • e: edit • d: delete • /: filter • q: quit"))
 
 	return b.String()
 }